@@ -1,136 +1,731 @@
 package main
 
 import (
-	"crypto/md5"
+	"archive/zip"
+	"context"
+	"encoding/json"
 	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"hash"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"sort"
-	"sync"
+	"strings"
+	"time"
+
+	"github.com/jo12bar/gosandbox/fromgoblog/2014/march/pipelines/md5sum/checksum"
 )
 
-// walkFiles starts a goroutine to walk the directory tree at root and send the
-// path of each regular file on the string channel. It sends the result of the
-// walk on the error channel. If done is closed, walkFiles abandons its work.
-func walkFiles(done <-chan struct{}, root string) (<-chan string, <-chan error) {
-	paths := make(chan string)
-	errc := make(chan error, 1)
+// patterns is a flag.Value collecting the patterns of a repeatable flag.
+type patterns []string
 
-	go func() {
-		// Close the paths channel after Walk returns.
-		defer close(paths)
+func (p *patterns) String() string { return strings.Join(*p, ",") }
 
-		// No select needed for this send, since errc is buffered.
-		errc <- filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.Mode().IsRegular() {
-				return nil
-			}
+func (p *patterns) Set(pattern string) error {
+	*p = append(*p, pattern)
+	return nil
+}
+
+var (
+	algo    = flag.String("algo", "md5", "hash algorithm: blake2b, crc32, md5, sha1, sha256, sha512 or xxhash; several, separated by commas, are computed in a single read, the first being the one formats with a single sum print")
+	workers = flag.Int("workers", 0, "number of files to read at once (default four per CPU, at least 20)")
+	adapt   = flag.Bool("adaptive", false, "vary the number of files read at once with the throughput, up to -workers")
+	asJSON  = flag.Bool("json", false, "print the sorted results as a JSON array")
+	verify  = flag.String("verify", "", "check the tree against the `manifest` instead of printing sums")
+	check   = flag.String("check", "", "check the files listed in the md5sum or sha256sum style `file`, like md5sum -c")
+	sorted  = flag.Bool("sorted", false, "print the results sorted by path once they are all computed")
+	_       = flag.Bool("stream", false, "print each sum as soon as it is computed, unsorted (the default, unless -sorted or -json)")
+	strict  = flag.Bool("strict", false, "with -verify, fail on files missing from the manifest")
+	keepTmp = flag.Bool("keep-temp", false, "also hash editor swap files and partial downloads")
+	keepOn  = flag.Bool("keep-going", false, "report files that cannot be read and go on with the others")
+	output  = flag.String("o", "", "write the results to `file` instead of standard output; the file itself is not hashed")
+	format  = flag.String("output", "manifest", "print the results in `format`: "+strings.Join(checksum.EncoderFormats(), ", "))
+	depth   = flag.Int("max-depth", 0, "descend at most `n` directory levels below the root; 1 hashes only the files in it")
+	gitIgn  = flag.Bool("gitignore", false, "honor the .gitignore files found in the tree, and skip .git directories")
+	dupes   = flag.Bool("dupes", false, "list the groups of files with identical content instead of printing sums; with -json, as a JSON object")
+	diff    = flag.Bool("diff", false, "compare the trees given as the two arguments by content instead of printing sums")
+	showPro = flag.Bool("progress", false, "show the files and bytes hashed so far, the throughput and the time left on standard error, counting the files first")
+	metrics = flag.String("metrics", "", "serve the progress of the scan on `addr`, as Prometheus metrics on /metrics and with expvar on /debug/vars")
+	links   = flag.String("symlinks", "ignore", "how to treat symbolic links: ignore, follow (links to files), follow-all (also into directories, skipping loops) or target (hash the link target)")
+	hardLnk = flag.Bool("hardlinks", false, "read each hard-linked file once, and report the other links to it on standard error")
+	special = flag.Bool("special", false, "report the sockets, named pipes and devices skipped on standard error")
+	watch   = flag.Bool("watch", false, "after hashing the tree, keep watching it and print each file hashed again, or removed, until interrupted, as NDJSON")
+	archive = flag.Bool("archives", false, "hash the members of tar, tar.gz and zip archives instead of the archives, as archive!member")
+	maxBps  = flag.Int64("max-bytes-per-sec", 0, "read at most `n` bytes per second, to run in the background (0 for no limit)")
+	maxFps  = flag.Float64("max-files-per-sec", 0, "open at most `n` files per second, to run in the background (0 for no limit)")
+	resume  = flag.String("resume", "", "on Ctrl-C, let the files being read finish and keep the sums so far in the checkpoint `file`, and skip the files they are of, unless changed, when run again with it; a second Ctrl-C stops at once, and the file is removed once a scan completes")
+	cacheAt = flag.String("cache", "", "reuse the sums in the cache `file` of files whose size and modification time have not changed, and update it")
+	exclude patterns
+	include patterns
+)
+
+// out is where the results are written.
+var out io.Writer = os.Stdout
+
+func main() {
+	flag.IntVar(workers, "j", 0, "shorthand for -workers")
+	flag.Var(&exclude, "exclude", "skip files and directories whose name matches `pattern` (repeatable)")
+	flag.Var(&include, "include", "only hash files whose name matches `pattern` (repeatable)")
+	flag.Parse()
+	root := flag.Arg(0)
+
+	if *check != "" {
+		// The hash of a checksum file goes by the length of its sums,
+		// unless -algo says otherwise.
+		algoSet := false
+		flag.Visit(func(f *flag.Flag) { algoSet = algoSet || f.Name == "algo" })
+		checkAll(*check, !algoSet)
+		return
+	}
+
+	algos := strings.Split(*algo, ",")
+	newHash, err := checksum.HashFunc(algos[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var extra map[string]func() hash.Hash
+	for _, name := range algos[1:] {
+		f, err := checksum.HashFunc(name)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if extra == nil {
+			extra = make(map[string]func() hash.Hash)
+		}
+		extra[name] = f
+	}
+	if _, err := checksum.NewEncoder(*format, ioutil.Discard); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	h := &checksum.Hasher{
+		Hash:            newHash,
+		ExtraHashes:     extra,
+		Workers:         *workers,
+		AdaptiveWorkers: *adapt,
+		Exclude:         exclude,
+		Include:         include,
+		MaxDepth:        *depth,
+		GitIgnore:       *gitIgn,
+		StrictExtra:     *strict,
+	}
+	if h.SymlinkMode, err = symlinkMode(*links); err != nil {
+		fmt.Println(err)
+		return
+	}
+	h.DedupHardLinks = *hardLnk
+	if *archive {
+		h.Expanders = checksum.ArchiveExpanders()
+	}
+	if *maxBps > 0 {
+		h.ByteLimiter = checksum.NewRateLimiter(float64(*maxBps), 0)
+	}
+	if *maxFps > 0 {
+		h.FileLimiter = checksum.NewRateLimiter(*maxFps, 0)
+	}
+	if h.FS, root, err = openSource(root); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *special || h.SymlinkMode == checksum.SymlinkFollowAll {
+		h.Stats = new(checksum.Stats)
+	}
+	if *keepTmp {
+		h.TempPatterns = []string{}
+	}
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer f.Close()
+		out = f
+		h.ExcludePaths = append(h.ExcludePaths, *output)
+	}
+
+	if *cacheAt != "" {
+		c, err := checksum.OpenCache(*cacheAt)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		h.Cache = c
+		h.ExcludePaths = append(h.ExcludePaths, *cacheAt)
+	}
+
+	// With -resume, a first Ctrl-C only stops the walk, so that the files
+	// being read are finished and saved to the checkpoint, while a second
+	// one cancels the scan at once.
+	var stop chan struct{}
+	if *resume != "" {
+		if *cacheAt != "" || *verify != "" || *diff || *dupes || *watch {
+			fmt.Println("-resume only applies to scans, and not with -cache")
+			return
+		}
+		c, err := checksum.OpenCache(*resume)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		h.Cache = c
+		h.ExcludePaths = append(h.ExcludePaths, *resume)
+		stop = make(chan struct{})
+		h.Stop = stop
+	}
 
-			select {
-			case paths <- path:
-			case <-done:
-				return errors.New("walk canceled")
+	if *showPro || *metrics != "" {
+		var v checksum.ProgressVar
+		if *metrics != "" {
+			expvar.Publish("checksum", &v)
+			http.Handle("/metrics", &v)
+			go func() {
+				if err := http.ListenAndServe(*metrics, nil); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}()
+		}
+		h.CountFirst = *showPro
+		h.ProgressFunc = func(p checksum.Progress) {
+			v.Update(p)
+			if *showPro {
+				printProgress(p)
 			}
+		}
+	}
+
+	// Cancel the scan on Ctrl-C, so that what has been hashed so far can
+	// still be printed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-			return nil
-		})
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	go func() {
+		<-sigc
+		if stop != nil {
+			fmt.Fprintln(os.Stderr, "stopping: finishing the files being read, Ctrl-C again to stop at once")
+			close(stop)
+			<-sigc
+		}
+		cancel()
 	}()
 
-	return paths, errc
+	switch {
+	case *verify != "":
+		verifyAll(h, root, *verify)
+	case *diff:
+		diffTrees(ctx, h, root, flag.Arg(1))
+	case *dupes:
+		dupesAll(ctx, h, root)
+	case *watch:
+		watchAll(ctx, h, root)
+	case *sorted || *asJSON:
+		printAll(ctx, h, root)
+	default:
+		streamAll(ctx, h, root)
+	}
 }
 
-// result is a MD5 checksum computation result, with an optional error.
-type result struct {
-	path string
-	sum  [md5.Size]byte
-	err  error
+// printProgress shows p on a line of standard error of its own, which the next
+// call overwrites. The line is ended once the totals have been reached.
+func printProgress(p checksum.Progress) {
+	line := fmt.Sprintf("%d/%d files, %s/%s, %s/s", p.Files+p.Failed, p.Found, sizeString(p.Bytes), sizeString(p.FoundBytes), sizeString(int64(p.Throughput())))
+	if eta, ok := p.ETA(); ok {
+		line += ", " + eta.Round(time.Second).String() + " left"
+	}
+	end := ""
+	if p.Counted && p.Files+p.Failed >= p.Found {
+		end = "\n"
+	}
+	// Pad over whatever is left of a longer previous line.
+	fmt.Fprintf(os.Stderr, "\r%-60s%s", line, end)
 }
 
-// digester reads path names from paths and sends digests of the corresponding
-// files on c until either paths or done is closed.
-func digester(done <-chan struct{}, paths <-chan string, c chan<- result) {
-	for path := range paths {
-		data, err := ioutil.ReadFile(path)
+// sizeString formats a number of bytes with a binary unit, such as "1.5 MiB".
+func sizeString(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
 
-		select {
-		case c <- result{path, md5.Sum(data), err}:
-		case <-done:
-			return
+// sources open the file systems of the roots given as scheme://location, by
+// scheme, and return the root of the tree in them.
+var sources = map[string]func(loc string) (fs.FS, string, error){
+	"file": func(loc string) (fs.FS, string, error) { return os.DirFS(loc), ".", nil },
+	"zip":  openZip,
+}
+
+// openSource returns the file system the root named by a scheme://location
+// URL is in, along with the root of the tree in it, or nil and root itself for
+// a root that is a plain path.
+func openSource(root string) (fs.FS, string, error) {
+	i := strings.Index(root, "://")
+	if i < 0 {
+		return nil, root, nil
+	}
+	scheme, loc := root[:i], root[i+len("://"):]
+	open, ok := sources[scheme]
+	if !ok {
+		return nil, "", fmt.Errorf("cannot read %s:// roots: only file:// and zip:// are built in, others such as s3:// need an fs.FS set as checksum.Hasher.FS", scheme)
+	}
+	return open(loc)
+}
+
+// openZip opens the zip archive of a zip://archive.zip!dir root, where the
+// directory inside it is optional. The archive stays open until the program
+// exits.
+func openZip(loc string) (fs.FS, string, error) {
+	name, dir := loc, "."
+	if i := strings.Index(loc, checksum.MemberSep); i >= 0 {
+		name, dir = loc[:i], loc[i+len(checksum.MemberSep):]
+	}
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return zr, dir, nil
+}
+
+// symlinkModes are the values of -symlinks.
+var symlinkModes = map[string]checksum.SymlinkMode{
+	"ignore":     checksum.SymlinkIgnore,
+	"follow":     checksum.SymlinkFollow,
+	"follow-all": checksum.SymlinkFollowAll,
+	"target":     checksum.SymlinkHashTarget,
+}
+
+// symlinkMode returns the SymlinkMode named by the -symlinks value name.
+func symlinkMode(name string) (checksum.SymlinkMode, error) {
+	mode, ok := symlinkModes[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown -symlinks mode %q", name)
+	}
+	return mode, nil
+}
+
+// noteLink reports r on standard error, with -hardlinks, if it is a hard link
+// to a file found under another path before.
+func noteLink(r checksum.Result) {
+	if *hardLnk && r.LinkOf != "" {
+		fmt.Fprintf(os.Stderr, "hard link: %s is %s\n", r.Path, r.LinkOf)
+	}
+}
+
+// reportSkipped reports on standard error what the walk of a completed scan
+// skipped, as -special and -symlinks follow-all ask: special files, and the
+// symbolic links that would have made the walk loop.
+func reportSkipped(h *checksum.Hasher) {
+	if h.Stats == nil {
+		return
+	}
+	if *special {
+		for _, path := range h.Stats.SkippedSpecial {
+			fmt.Fprintf(os.Stderr, "special file skipped: %s\n", path)
 		}
 	}
+	for _, path := range h.Stats.SymlinkLoops {
+		fmt.Fprintf(os.Stderr, "symbolic link loop skipped: %s\n", path)
+	}
 }
 
-// MD5All reads all the files in the file tree rooted at root and returns a map
-// from file path to the MD5 sum of the file's contents. If the directory walk
-// fails or any read operation fails, MD5All returns an error. In that case,
-// MD5All does not wait for inflight read operations to complete.
-func MD5All(root string) (map[string][md5.Size]byte, error) {
-	// MD5All closes the done channel when it returns; it may do so before
-	// receiving all the values from c and errc.
-	done := make(chan struct{})
-	defer close(done)
+// exitInterrupted exits the way a process killed by SIGINT conventionally
+// does, after the partial results of an interrupted scan have been printed.
+func exitInterrupted() {
+	if *showPro {
+		// The progress line was left unfinished.
+		fmt.Fprintln(os.Stderr)
+	}
+	fmt.Fprintln(os.Stderr, "interrupted: results are incomplete")
+	os.Exit(130)
+}
+
+// printAll calculates the sum of all files under root, then prints all the
+// results sorted by name. If ctx is canceled, it prints the results collected
+// so far and exits.
+func printAll(ctx context.Context, h *checksum.Hasher, root string) {
+	c, errc := h.Stream(ctx, root)
 
-	paths, errc := walkFiles(done, root)
+	var results []checksum.Result
+	var failed int
+	for r := range c {
+		if r.Err != nil {
+			if !*keepOn {
+				fmt.Println(r.Err)
+				return
+			}
+			fmt.Fprintln(os.Stderr, r.Err)
+			failed++
+			continue
+		}
+		noteLink(r)
+		results = append(results, r)
+	}
 
-	// Start a fixed number of goroutines to read and digest files.
-	c := make(chan result)
-	var wg sync.WaitGroup
-	const numDigesters = 20
+	err := <-errc
+	interrupted := errors.Is(err, context.Canceled) || errors.Is(err, checksum.ErrStopped)
+	if err != nil && !interrupted {
+		fmt.Println(err)
+		return
+	}
 
-	wg.Add(numDigesters)
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	printResults(results)
 
-	for i := 0; i < numDigesters; i++ {
-		go func() {
-			digester(done, paths, c)
-			wg.Done()
-		}()
+	if interrupted {
+		saveCheckpoint(h)
+		exitInterrupted()
 	}
+	reportSkipped(h)
+	saveCache(h)
+	exitFailed(failed)
+}
 
-	go func() {
-		wg.Wait()
-		close(c)
-	}()
+// exitFailed exits with status 1 if failed files could not be read, as
+// -keep-going allows, once the results of the others have been printed.
+func exitFailed(failed int) {
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d files could not be read\n", failed)
+		os.Exit(1)
+	}
+}
 
-	// Collect the results from c.
-	m := make(map[string][md5.Size]byte)
+// printResults prints results, either in the -output format or, with -json,
+// as a JSON array of file states.
+func printResults(results []checksum.Result) {
+	if *asJSON {
+		states := make([]checksum.FileState, len(results))
+		for i, r := range results {
+			states[i] = r.State()
+		}
+		if err := checksum.WriteJSON(out, states); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	enc, _ := checksum.NewEncoder(*format, out)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if err := enc.Close(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// streamAll prints the sum of each file under root as soon as it has been
+// computed, stopping at the first error unless -keep-going is set. If ctx is
+// canceled, it exits once the results already computed have been printed.
+func streamAll(ctx context.Context, h *checksum.Hasher, root string) {
+	c, errc := h.Stream(ctx, root)
+
+	enc, _ := checksum.NewEncoder(*format, out)
+	var failed int
 	for r := range c {
-		if r.err != nil {
-			return nil, r.err
+		if r.Err != nil {
+			if !*keepOn {
+				fmt.Println(r.Err)
+				return
+			}
+			fmt.Fprintln(os.Stderr, r.Err)
+			failed++
+			continue
+		}
+		noteLink(r)
+		if err := enc.Encode(r); err != nil {
+			fmt.Println(err)
+			return
 		}
-		m[r.path] = r.sum
 	}
 
-	// Check whether the walk failed.
-	if err := <-errc; err != nil {
-		return nil, err
+	// The output is ended even if the scan was interrupted, so that what
+	// was printed is complete in itself.
+	err := <-errc
+	if cerr := enc.Close(); cerr != nil {
+		fmt.Println(cerr)
+		return
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, checksum.ErrStopped) {
+		saveCheckpoint(h)
+		exitInterrupted()
+	} else if err != nil {
+		fmt.Println(err)
+		return
 	}
+	reportSkipped(h)
+	saveCache(h)
+	exitFailed(failed)
+}
 
-	return m, nil
+// watchAll prints the result of each file under root as an NDJSON record,
+// those of the files that could not be read included, then keeps printing
+// those of the files changed or removed, the latter with "removed" set, until
+// ctx is canceled.
+func watchAll(ctx context.Context, h *checksum.Hasher, root string) {
+	enc := checksum.NewNDJSONEncoder(out)
+	err := h.Watch(ctx, root, func(r checksum.Result) error {
+		noteLink(r)
+		return enc.Encode(r)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Println(err)
+		os.Exit(2)
+	}
 }
 
-func main() {
-	// Calculate the MD5 sum of all files under the specified directory,
-	// then print all the results sorted by name.
-	m, err := MD5All(os.Args[1])
+// saveCache writes out the cache of h, if -cache set one, once a scan has
+// completed. The cache of an interrupted or failed scan is left as it was,
+// since it would lose the files the scan did not get to.
+func saveCache(h *checksum.Hasher) {
+	if h.Cache == nil {
+		return
+	}
+	// The checkpoint of -resume is done with once a scan completes.
+	if *resume != "" {
+		if err := os.Remove(*resume); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return
+	}
+	if err := h.Cache.Save(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
 
-	if err != nil {
+// saveCheckpoint writes out the checkpoint of -resume, if set, once a scan has
+// been interrupted, keeping the sums of the files it got to along with those
+// of the runs before it.
+func saveCheckpoint(h *checksum.Hasher) {
+	if *resume == "" {
+		return
+	}
+	if err := h.Cache.Checkpoint(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "checkpoint saved: run again with -resume %s to continue\n", *resume)
+}
+
+// dupesAll prints the groups of files with identical content under root, each
+// with the bytes that removing every copy but one would reclaim, followed by
+// the total, or the same as a JSON object with -json.
+func dupesAll(ctx context.Context, h *checksum.Hasher, root string) {
+	if *keepOn {
+		h.ErrorPolicy = checksum.KeepGoing
+	}
+	sets, err := h.FindDuplicates(ctx, root)
+	var readErrs checksum.ReadErrors
+	if errors.As(err, &readErrs) {
+		for _, re := range readErrs {
+			fmt.Fprintln(os.Stderr, re)
+		}
+	} else if errors.Is(err, context.Canceled) {
+		exitInterrupted()
+	} else if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	var paths []string
-	for path := range m {
-		paths = append(paths, path)
+	var total int64
+	for _, s := range sets {
+		total += s.Reclaimable()
 	}
 
-	sort.Strings(paths)
+	if *asJSON {
+		if sets == nil {
+			sets = []checksum.DuplicateSet{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		doc := struct {
+			Sets        []checksum.DuplicateSet `json:"sets"`
+			Reclaimable int64                   `json:"reclaimable"`
+		}{sets, total}
+		if err := enc.Encode(doc); err != nil {
+			fmt.Println(err)
+		}
+	} else {
+		for _, s := range sets {
+			fmt.Fprintf(out, "%x: %d files of %d bytes, %d bytes reclaimable\n", s.Sum, len(s.Paths), s.Size, s.Reclaimable())
+			for _, p := range s.Paths {
+				fmt.Fprintf(out, "\t%s\n", p)
+			}
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "%d groups of duplicates, %d bytes reclaimable\n", len(sets), total)
+	}
+	exitFailed(len(readErrs))
+}
 
-	for _, path := range paths {
-		fmt.Printf("%x\t%s\n", m[path], path)
+// diffTrees compares the trees rooted at a and b by content, printing each file
+// only in one of them or differing between them, and exits as diff -r does:
+// with status 0 if the trees are the same, 1 if they differ and 2 if either
+// could not be read.
+func diffTrees(ctx context.Context, h *checksum.Hasher, a, b string) {
+	if a == "" || b == "" {
+		fmt.Fprintln(os.Stderr, "-diff needs two trees to compare")
+		os.Exit(2)
 	}
+	if *keepOn {
+		h.ErrorPolicy = checksum.KeepGoing
+	}
+	entries, err := h.CompareTrees(ctx, a, b)
+	var readErrs checksum.ReadErrors
+	if errors.As(err, &readErrs) {
+		for _, re := range readErrs {
+			fmt.Fprintln(os.Stderr, re)
+		}
+	} else if errors.Is(err, context.Canceled) {
+		exitInterrupted()
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	for _, e := range entries {
+		switch e.Kind {
+		case checksum.DiffRemoved:
+			fmt.Fprintf(out, "only in %s: %s\n", a, e.Path)
+		case checksum.DiffAdded:
+			fmt.Fprintf(out, "only in %s: %s\n", b, e.Path)
+		case checksum.DiffChanged:
+			fmt.Fprintf(out, "differs: %s (%x, %x)\n", e.Path, e.Old, e.New)
+		}
+	}
+
+	switch {
+	case len(readErrs) > 0:
+		os.Exit(2)
+	case len(entries) > 0:
+		os.Exit(1)
+	}
+}
+
+// sumAlgos are the hash algorithms checkAll tells apart by the length of
+// their sums. BLAKE2b sums are as long as SHA-512 ones, so checking them
+// takes -algo blake2b.
+var sumAlgos = map[int]string{8: "xxhash", 16: "md5", 20: "sha1", 32: "sha256", 64: "sha512"}
+
+// checkAll checks the files listed in the coreutils checksum file at path, as
+// md5sum -c does, printing "OK", "FAILED" or "MISSING" for each, and exits with
+// the code of the verification summary: 0 if every file matches, 1 if files
+// changed, 2 if files are missing and 3 if the checksum file or a listed file
+// could not be read. If guess is set, the hash is the one whose sums have the
+// length of those listed, rather than -algo.
+func checkAll(path string, guess bool) {
+	exit := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(checksum.Summarize(nil, err).ExitCode())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		exit(err)
+	}
+	sums, err := checksum.ParseCoreutilsDigests(f)
+	f.Close()
+	if err != nil {
+		exit(err)
+	}
+
+	name := *algo
+	if guess {
+		for _, sum := range sums {
+			if n, ok := sumAlgos[len(sum)]; ok {
+				name = n
+			}
+			break
+		}
+	}
+	newHash, err := checksum.HashFunc(name)
+	if err != nil {
+		exit(err)
+	}
+
+	// Every listed file is checked, temporary or not.
+	h := &checksum.Hasher{Hash: newHash, Workers: *workers, TempPatterns: []string{}}
+	c, errc := h.Check(context.Background(), sums)
+
+	var mismatches []checksum.Mismatch
+	var readErr error
+	failed := 0
+	for r := range c {
+		if r.Status != checksum.CheckOK {
+			failed++
+		}
+		switch r.Status {
+		case checksum.CheckMissing:
+			mismatches = append(mismatches, checksum.Mismatch{Path: r.Path, Kind: checksum.Missing})
+		case checksum.CheckFailed:
+			if r.Err != nil {
+				fmt.Fprintln(os.Stderr, r.Err)
+				if readErr == nil {
+					readErr = r.Err
+				}
+				break
+			}
+			mismatches = append(mismatches, checksum.Mismatch{Path: r.Path, Kind: checksum.Changed})
+		}
+		fmt.Fprintf(out, "%s: %s\n", r.Path, r.Status)
+	}
+	err = <-errc
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		err = readErr
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "WARNING: %d of %d listed files did NOT match\n", failed, len(sums))
+	}
+	os.Exit(checksum.Summarize(mismatches, err).ExitCode())
+}
+
+// verifyAll checks the files under root against the manifest at path, prints
+// each mismatch, and exits with the code of the verification summary: 0 if
+// the tree matches, 1 if files changed, 2 if files are missing and 3 if the
+// manifest or a file could not be read. The manifest is not checked against
+// itself if it lies under root.
+func verifyAll(h *checksum.Hasher, root, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(checksum.Summarize(nil, err).ExitCode())
+	}
+	manifest, err := checksum.ParseManifest(f)
+	f.Close()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(checksum.Summarize(nil, err).ExitCode())
+	}
+
+	h.ExcludePaths = append(h.ExcludePaths, path)
+	mismatches, err := h.Verify(root, manifest)
+	if err != nil {
+		fmt.Println(err)
+	}
+	for _, m := range mismatches {
+		fmt.Fprintf(out, "%s: %s\n", m.Path, m.Kind)
+	}
+
+	os.Exit(checksum.Summarize(mismatches, err).ExitCode())
 }
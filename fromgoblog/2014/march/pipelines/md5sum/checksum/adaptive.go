@@ -0,0 +1,168 @@
+package checksum
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// adaptInterval is how often the pool of an AdaptiveWorkers run
+	// reconsiders its size.
+	adaptInterval = 100 * time.Millisecond
+
+	// initialWorkers is the number of digesters an AdaptiveWorkers run
+	// starts with.
+	initialWorkers = 2
+)
+
+// adaptive runs the digesters of an AdaptiveWorkers run. A controller
+// goroutine samples the latency of the digesters and the length of the file
+// queue every adaptInterval, and raises or lowers the number of digesters it
+// wants. New digesters are started right away; surplus ones retire themselves
+// between files, after sending their pending results, so no file is dropped.
+type adaptive struct {
+	max int32
+
+	// active is the number of running digesters, and want the number the
+	// controller aims for. nanos and count add up the time taken by the
+	// files digested since the last sample. They are accessed atomically.
+	active int32
+	want   int32
+	nanos  int64
+	count  int64
+
+	// drained is closed once a digester finds the file channel closed.
+	drained   chan struct{}
+	drainOnce sync.Once
+}
+
+// startAdaptive starts the digesters of an AdaptiveWorkers run, and the
+// controller that varies their number, adding them all to wg.
+func (h *Hasher) startAdaptive(done <-chan struct{}, files <-chan file, c chan<- []Result, j *job, wg *sync.WaitGroup) {
+	a := &adaptive{max: int32(h.workers()), drained: make(chan struct{})}
+	j.adaptive = a
+
+	spawn := func() {
+		wg.Add(1)
+		go func() {
+			h.digester(done, files, c, j)
+			wg.Done()
+		}()
+	}
+
+	n := int32(initialWorkers)
+	if n > a.max {
+		n = a.max
+	}
+	a.active, a.want = n, n
+	for i := int32(0); i < n; i++ {
+		spawn()
+	}
+
+	// The controller holds a place in wg while it may still spawn
+	// digesters, so that wg cannot reach zero in between.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.control(done, files, spawn)
+	}()
+}
+
+// control adjusts the size of a every adaptInterval until the files have all
+// been taken or done is closed, calling spawn to start a digester.
+func (a *adaptive) control(done <-chan struct{}, files <-chan file, spawn func()) {
+	tick := time.NewTicker(adaptInterval)
+	defer tick.Stop()
+
+	var prevLatency time.Duration
+	for {
+		select {
+		case <-tick.C:
+		case <-a.drained:
+			return
+		case <-done:
+			return
+		}
+
+		count := atomic.SwapInt64(&a.count, 0)
+		nanos := atomic.SwapInt64(&a.nanos, 0)
+		want := atomic.LoadInt32(&a.want)
+		queued := len(files)
+
+		switch {
+		case count == 0 && queued > 0 && want < a.max:
+			// Files are waiting but none completed in a whole
+			// interval: they are slow to read, so read more at
+			// once.
+			a.grow(want+1, spawn)
+			continue
+		case count == 0:
+			continue
+		}
+		latency := time.Duration(nanos / count)
+
+		switch {
+		case queued > 0 && want < a.max && (prevLatency == 0 || latency <= prevLatency*3/2):
+			// Files are waiting and the storage keeps up: grow,
+			// doubling so that storage that needs many readers,
+			// such as a network filesystem, gets them in a few
+			// intervals.
+			want *= 2
+			if want > a.max {
+				want = a.max
+			}
+		case queued > 0 && prevLatency != 0 && latency > prevLatency*3/2 && want > 1:
+			// Latency climbs with more readers: the storage is
+			// saturated, so back off.
+			want--
+		case queued == 0 && want > 1:
+			// The digesters outpace the walk.
+			want--
+		}
+		prevLatency = latency
+
+		a.grow(want, spawn)
+	}
+}
+
+// grow sets the number of digesters a wants, and calls spawn to start as many
+// as needed to reach it. If want is lower than the number of active
+// digesters, the surplus ones retire themselves.
+func (a *adaptive) grow(want int32, spawn func()) {
+	atomic.StoreInt32(&a.want, want)
+	for {
+		active := atomic.LoadInt32(&a.active)
+		if active >= want {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&a.active, active, active+1) {
+			spawn()
+		}
+	}
+}
+
+// observe records that a digester took d to digest a file.
+func (a *adaptive) observe(d time.Duration) {
+	atomic.AddInt64(&a.nanos, int64(d))
+	atomic.AddInt64(&a.count, 1)
+}
+
+// retire reports whether the calling digester should stop because there are
+// more of them than a wants. If so, it is no longer counted as active.
+func (a *adaptive) retire() bool {
+	for {
+		active := atomic.LoadInt32(&a.active)
+		if active <= atomic.LoadInt32(&a.want) {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&a.active, active, active-1) {
+			return true
+		}
+	}
+}
+
+// drain records that a digester found the file channel closed.
+func (a *adaptive) drain() {
+	a.drainOnce.Do(func() { close(a.drained) })
+}
@@ -0,0 +1,56 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// archived reports whether a file of the given mode is one Hasher.ArchiveMode
+// digests from its metadata.
+func archived(mode os.FileMode) bool {
+	return mode&(os.ModeSymlink|os.ModeDevice|os.ModeNamedPipe) != 0
+}
+
+// archiveSum returns the digest Hasher.ArchiveMode computes for the special
+// file at path, described by info: the hash of its kind, its mode as a
+// big-endian uint32, and, for a symbolic link, its target or, for a device
+// whose numbers are known, its major and minor numbers as big-endian uint32s,
+// each field prefixed with its length.
+func (h *Hasher) archiveSum(path string, info os.FileInfo) (Digest, error) {
+	d := h.newHash()
+	mode := info.Mode()
+
+	var kind string
+	switch {
+	case mode&os.ModeSymlink != 0:
+		kind = "symlink"
+	case mode&os.ModeCharDevice != 0:
+		kind = "chardev"
+	case mode&os.ModeDevice != 0:
+		kind = "blockdev"
+	default:
+		kind = "fifo"
+	}
+	writeField(d, []byte(kind))
+
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(mode))
+	writeField(d, b[:4])
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		writeField(d, []byte(target))
+	case mode&os.ModeDevice != 0:
+		if major, minor, ok := deviceNumbers(info); ok {
+			binary.BigEndian.PutUint32(b[:4], major)
+			binary.BigEndian.PutUint32(b[4:], minor)
+			writeField(d, b[:])
+		}
+	}
+
+	return d.Sum(nil), nil
+}
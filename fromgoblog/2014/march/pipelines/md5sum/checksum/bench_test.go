@@ -0,0 +1,395 @@
+package checksum
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A synthFS is an fs.FS of dirs directories of files files each, named
+// d0000/f0000 and so on, each of size bytes, made up as they are read so that
+// a tree of millions of files or of gigabytes takes no memory. If disk is
+// set, the files are read through it. Opening a file waits for latency, as a
+// request to a network filesystem would, without holding up other opens.
+type synthFS struct {
+	dirs, files int
+	size        int64
+	disk        *synthDisk
+	latency     time.Duration
+}
+
+// synthTime is the modification time of every file of a synthFS.
+var synthTime = time.Date(2014, 3, 13, 0, 0, 0, 0, time.UTC)
+
+// synthInfo is the information of a file or directory of a synthFS.
+type synthInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi synthInfo) Name() string       { return fi.name }
+func (fi synthInfo) Size() int64        { return fi.size }
+func (fi synthInfo) ModTime() time.Time { return synthTime }
+func (fi synthInfo) IsDir() bool        { return fi.dir }
+func (fi synthInfo) Sys() interface{}   { return nil }
+func (fi synthInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// synthIndex parses the number of a d0000 or f0000 name below n, or returns
+// -1.
+func synthIndex(name string, prefix byte, n int) int {
+	if len(name) < 2 || name[0] != prefix {
+		return -1
+	}
+	i, err := strconv.Atoi(name[1:])
+	if err != nil || i < 0 || i >= n {
+		return -1
+	}
+	return i
+}
+
+func (s *synthFS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return synthInfo{name: ".", dir: true}, nil
+	}
+	dir, file := name, ""
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		dir, file = name[:i], name[i+1:]
+	}
+	if synthIndex(dir, 'd', s.dirs) < 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	if file == "" {
+		return synthInfo{name: dir, dir: true}, nil
+	}
+	if synthIndex(file, 'f', s.files) < 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return synthInfo{name: file, size: s.size}, nil
+}
+
+func (s *synthFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	var entries []fs.DirEntry
+	if name == "." {
+		for i := 0; i < s.dirs; i++ {
+			entries = append(entries, fs.FileInfoToDirEntry(synthInfo{name: fmt.Sprintf("d%04d", i), dir: true}))
+		}
+		return entries, nil
+	}
+	for i := 0; i < s.files; i++ {
+		entries = append(entries, fs.FileInfoToDirEntry(synthInfo{name: fmt.Sprintf("f%04d", i), size: s.size}))
+	}
+	return entries, nil
+}
+
+func (s *synthFS) Open(name string) (fs.File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if s.latency > 0 && !info.IsDir() {
+		time.Sleep(s.latency)
+	}
+	return &synthFile{name: name, info: info, disk: s.disk}, nil
+}
+
+// synthBlock is the content the files of a synthFS repeat.
+var synthBlock = func() []byte {
+	b := make([]byte, 64<<10)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}()
+
+// A synthFile is an open file of a synthFS.
+type synthFile struct {
+	name string
+	info fs.FileInfo
+	disk *synthDisk
+	off  int64
+}
+
+func (f *synthFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *synthFile) Close() error               { return nil }
+
+func (f *synthFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	left := f.info.Size() - f.off
+	if left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > left {
+		p = p[:left]
+	}
+	if len(p) > len(synthBlock) {
+		p = p[:len(synthBlock)]
+	}
+	if f.disk != nil {
+		f.disk.read(f.name, f.off)
+	}
+	n := copy(p, synthBlock)
+	f.off += int64(n)
+	return n, nil
+}
+
+// A synthDisk simulates a spinning disk with a single head: a read of a file
+// other than the one read last, or elsewhere than where that read ended, waits
+// for seek first, and reads wait for each other, as the head can only be at
+// one place at a time. Only seeks cost time.
+type synthDisk struct {
+	seek time.Duration
+
+	mu    sync.Mutex
+	file  string
+	off   int64
+	seeks int
+}
+
+func (d *synthDisk) read(name string, off int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if name != d.file || off != d.off {
+		time.Sleep(d.seek)
+		d.seeks++
+	}
+	d.file = name
+	// Every read but the last of a file is of a whole block.
+	d.off = off + int64(len(synthBlock))
+}
+
+// benchCollect runs h over the whole of the tree rooted at root b.N times, and
+// reports the files and bytes digested per second.
+func benchCollect(b *testing.B, h *Hasher, root string) {
+	b.Helper()
+	var files, bytes int64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		done := make(chan struct{})
+		err := h.collect(done, root, func(r Result) error {
+			if r.Err != nil {
+				return r.Err
+			}
+			files++
+			bytes += r.Size
+			return nil
+		})
+		close(done)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	secs := b.Elapsed().Seconds()
+	b.ReportMetric(float64(files)/secs, "files/s")
+	if bytes > 0 {
+		b.SetBytes(bytes / int64(b.N))
+	}
+}
+
+// BenchmarkDelivery measures the throughput of the pipeline over a million
+// empty files, where handing the results to the collector costs as much as
+// digesting them, with the results sent in batches and one at a time.
+func BenchmarkDelivery(b *testing.B) {
+	fsys := &synthFS{dirs: 1000, files: 1000}
+	for _, n := range []int{1, batchSize} {
+		b.Run(fmt.Sprintf("batch=%d", n), func(b *testing.B) {
+			defer func(old int) { batchSize = old }(batchSize)
+			batchSize = n
+			benchCollect(b, &Hasher{FS: fsys}, ".")
+		})
+	}
+}
+
+// BenchmarkSequentialMode compares the default fan-out of digesters with
+// SequentialMode on a disk where every seek costs a millisecond, and reports
+// the seeks each run makes.
+func BenchmarkSequentialMode(b *testing.B) {
+	modes := []struct {
+		name string
+		h    Hasher
+	}{
+		{"fan-out", Hasher{}},
+		{"sequential", Hasher{SequentialMode: true}},
+	}
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			disk := &synthDisk{seek: time.Millisecond}
+			h := m.h
+			h.FS = &synthFS{dirs: 4, files: 25, size: 1 << 20, disk: disk}
+			benchCollect(b, &h, ".")
+			b.ReportMetric(float64(disk.seeks)/float64(b.N), "seeks/op")
+		})
+	}
+}
+
+// BenchmarkIncrementalTreeRoot measures an update of one file of trees of
+// growing size followed by Root, which should take time logarithmic in the
+// size of the tree.
+func BenchmarkIncrementalTreeRoot(b *testing.B) {
+	for _, n := range []int{1000, 100000, 1000000} {
+		b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+			it := NewIncrementalTreeRoot(nil)
+			paths := make([]string, n)
+			for i := range paths {
+				paths[i] = fmt.Sprintf("d%04d/f%04d", i/1000, i%1000)
+				it.Add(paths[i], Digest{byte(i)})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				it.Update(paths[i*7919%n], Digest{byte(i), 1})
+				it.Root()
+			}
+		})
+	}
+}
+
+// sampleHeap samples the heap in use every millisecond until the returned
+// function is called, which returns the most it saw.
+func sampleHeap() func() uint64 {
+	stop := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		var max uint64
+		var ms runtime.MemStats
+		t := time.NewTicker(time.Millisecond)
+		defer t.Stop()
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > max {
+				max = ms.HeapInuse
+			}
+			select {
+			case <-t.C:
+			case <-stop:
+				peak <- max
+				return
+			}
+		}
+	}()
+	return func() uint64 {
+		close(stop)
+		return <-peak
+	}
+}
+
+// BenchmarkLargeFile digests a single file of up to 4 GiB, made up as it is
+// read, with the default buffer and a larger one, and reports the most heap
+// in use while it does, which stays the same however large the file.
+func BenchmarkLargeFile(b *testing.B) {
+	for _, size := range []int64{64 << 20, 1 << 30, 4 << 30} {
+		for _, bufSize := range []int{0, 1 << 20} {
+			b.Run(fmt.Sprintf("size=%dMiB/buffer=%d", size>>20, bufSize), func(b *testing.B) {
+				runtime.GC()
+				peak := sampleHeap()
+				b.ReportAllocs()
+				benchCollect(b, &Hasher{FS: &synthFS{dirs: 1, files: 1, size: size}, BufferSize: bufSize}, ".")
+				b.ReportMetric(float64(peak())/(1<<20), "peak-heap-MiB")
+			})
+		}
+	}
+}
+
+// BenchmarkWorkers compares fixed numbers of digesters and AdaptiveWorkers on
+// a local tree of large files, where the CPUs bound the run, and on a network
+// tree of small files, where every open waits 2ms and more digesters mean
+// more requests in flight. AdaptiveWorkers takes a few intervals to climb to
+// Workers, which it never exceeds, so it only matches a fixed Workers on runs
+// long enough to make up for the climb.
+func BenchmarkWorkers(b *testing.B) {
+	trees := []struct {
+		name string
+		fsys *synthFS
+	}{
+		{"local", &synthFS{dirs: 10, files: 100, size: 256 << 10}},
+		{"network", &synthFS{dirs: 20, files: 250, size: 4 << 10, latency: 2 * time.Millisecond}},
+	}
+	settings := []struct {
+		name string
+		h    Hasher
+	}{
+		{"workers=1", Hasher{Workers: 1}},
+		{"workers=4", Hasher{Workers: 4}},
+		{"workers=default", Hasher{}},
+		{"workers=64", Hasher{Workers: 64}},
+		{"adaptive", Hasher{AdaptiveWorkers: true}},
+		{"adaptive, workers=64", Hasher{Workers: 64, AdaptiveWorkers: true}},
+	}
+	for _, tree := range trees {
+		for _, set := range settings {
+			b.Run(tree.name+"/"+set.name, func(b *testing.B) {
+				h := set.h
+				h.FS = tree.fsys
+				benchCollect(b, &h, ".")
+			})
+		}
+	}
+}
+
+// BenchmarkWalk compares the cost of walking a tree on disk, without reading
+// any file, with walkTree and with the walks of package filepath:
+// filepath.Walk calls Lstat for every entry, and filepath.WalkDir for none.
+func BenchmarkWalk(b *testing.B) {
+	tree := make(map[string]string)
+	for d := 0; d < 50; d++ {
+		for s := 0; s < 20; s++ {
+			for f := 0; f < 10; f++ {
+				tree[fmt.Sprintf("d%02d/s%02d/f%02d", d, s, f)] = ""
+			}
+		}
+	}
+	root := writeTree(b, tree)
+
+	walks := []struct {
+		name string
+		walk func(fn filepath.WalkFunc) error
+	}{
+		{"filepath.Walk", func(fn filepath.WalkFunc) error { return filepath.Walk(root, fn) }},
+		{"filepath.WalkDir", func(fn filepath.WalkFunc) error {
+			return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				return fn(path, nil, err)
+			})
+		}},
+		{"walkTree/workers=1", func(fn filepath.WalkFunc) error { return walkTree(root, 1, fn, nil, nil, nil) }},
+		{"walkTree/workers=8", func(fn filepath.WalkFunc) error { return walkTree(root, 8, fn, nil, nil, nil) }},
+	}
+	for _, w := range walks {
+		b.Run(w.name, func(b *testing.B) {
+			var entries int
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				entries = 0
+				err := w.walk(func(path string, info os.FileInfo, err error) error {
+					entries++
+					return err
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(entries*b.N)/time.Since(start).Seconds(), "entries/s")
+		})
+	}
+}
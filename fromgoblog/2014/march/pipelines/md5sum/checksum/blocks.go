@@ -0,0 +1,80 @@
+package checksum
+
+import (
+	"errors"
+	"hash/adler32"
+	"io"
+)
+
+// A BlockHash describes one block of a file, as returned by BlockHashes: its
+// offset and size, its Adler-32 checksum, cheap to compute and to roll along a
+// file with RollWeak, and a strong digest to confirm a match of the weak one.
+type BlockHash struct {
+	Offset int64
+	Size   int
+	Weak   uint32
+	Strong Digest
+}
+
+// BlockHashes splits the file at path into consecutive blocks of blockSize
+// bytes, the last of which may be shorter, and returns a BlockHash for each,
+// with an MD5 strong digest. These are what the receiving side of an
+// rsync-like transfer needs to find the blocks of its copy in a changed file.
+// An empty file has no blocks.
+//
+// BlockHashes uses a zero Hasher; see Hasher.BlockHashes.
+func BlockHashes(path string, blockSize int) ([]BlockHash, error) {
+	return new(Hasher).BlockHashes(path, blockSize)
+}
+
+// BlockHashes is like the package-level BlockHashes, but reads the file
+// through h and computes the strong digests with h.Hash.
+func (h *Hasher) BlockHashes(path string, blockSize int) ([]BlockHash, error) {
+	if blockSize <= 0 {
+		return nil, errors.New("checksum: block size must be positive")
+	}
+
+	rc, err := h.open(path)
+	if err != nil {
+		return nil, &ReadError{path, pathError("open", path, err)}
+	}
+	defer rc.Close()
+
+	var blocks []BlockHash
+	buf := make([]byte, blockSize)
+	var off int64
+	for {
+		n, err := io.ReadFull(rc, buf)
+		if n > 0 {
+			d := h.newHash()
+			d.Write(buf[:n])
+			blocks = append(blocks, BlockHash{off, n, adler32.Checksum(buf[:n]), d.Sum(nil)})
+			off += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, &ReadError{path, pathError("read", path, err)}
+		}
+	}
+}
+
+// adlerMod is the modulus of Adler-32.
+const adlerMod = 65521
+
+// RollWeak returns the Adler-32 checksum of a window of n bytes that slid one
+// byte along, given weak, the checksum of the window before, out, the byte
+// that left it, and in, the byte that entered it. It lets a file be searched
+// for blocks with a known BlockHash.Weak at every offset, for the cost of one
+// full checksum.
+func RollWeak(weak uint32, n int, out, in byte) uint32 {
+	a, b := weak&0xffff, weak>>16
+
+	a = (a + adlerMod - uint32(out) + uint32(in)) % adlerMod
+	// b loses n times out, along with the 1 every byte's count of a starts
+	// from, and gains the new a.
+	b = (b + adlerMod - uint32(uint64(n)*uint64(out)%adlerMod) + adlerMod - 1 + a) % adlerMod
+
+	return b<<16 | a
+}
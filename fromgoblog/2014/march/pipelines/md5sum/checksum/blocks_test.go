@@ -0,0 +1,100 @@
+package checksum
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"hash/adler32"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jo12bar/gosandbox/fromgoblog/2014/march/pipelines/md5sum/checksum/faultfs"
+)
+
+// blockContent returns n bytes that differ from block to block.
+func blockContent(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*31 + i/7)
+	}
+	return b
+}
+
+// checkBlocks checks that blocks are those of content split every blockSize
+// bytes.
+func checkBlocks(t *testing.T, blocks []BlockHash, content []byte, blockSize int) {
+	t.Helper()
+	want := (len(content) + blockSize - 1) / blockSize
+	if len(blocks) != want {
+		t.Fatalf("got %d blocks, want %d", len(blocks), want)
+	}
+	for i, bl := range blocks {
+		off := i * blockSize
+		end := off + blockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		data := content[off:end]
+		sum := md5.Sum(data)
+		if bl.Offset != int64(off) || bl.Size != len(data) {
+			t.Errorf("block %d at %d of %d bytes, want at %d of %d", i, bl.Offset, bl.Size, off, len(data))
+		}
+		if bl.Weak != adler32.Checksum(data) {
+			t.Errorf("block %d: weak %08x, want %08x", i, bl.Weak, adler32.Checksum(data))
+		}
+		if !bytes.Equal(bl.Strong, sum[:]) {
+			t.Errorf("block %d: strong %x, want %x", i, bl.Strong, sum)
+		}
+	}
+}
+
+func TestBlockHashes(t *testing.T) {
+	const blockSize = 16
+	for _, size := range []int{0, 1, blockSize - 1, blockSize, blockSize + 1, 3 * blockSize, 3*blockSize + 5} {
+		t.Run(fmt.Sprint("size=", size), func(t *testing.T) {
+			content := blockContent(size)
+			h := &Hasher{FS: fstest.MapFS{"f": &fstest.MapFile{Data: content}}}
+			blocks, err := h.BlockHashes("f", blockSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBlocks(t, blocks, content, blockSize)
+		})
+	}
+}
+
+func TestBlockHashesShortReads(t *testing.T) {
+	// Blocks are whole however little each read returns.
+	content := blockContent(1000)
+	root := writeTree(t, map[string]string{"f": string(content)})
+	path := filepath.Join(root, "f")
+	h := &Hasher{OpenFunc: faultOpen(path, faultfs.Fault{MaxRead: 7})}
+	blocks, err := h.BlockHashes(path, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlocks(t, blocks, content, 64)
+}
+
+func TestBlockHashesSize(t *testing.T) {
+	h := &Hasher{FS: fstest.MapFS{"f": mapFile("abc")}}
+	for _, size := range []int{0, -1} {
+		if _, err := h.BlockHashes("f", size); err == nil {
+			t.Errorf("BlockHashes with block size %d succeeded", size)
+		}
+	}
+}
+
+func TestRollWeak(t *testing.T) {
+	content := blockContent(300)
+	for _, n := range []int{1, 16, 100} {
+		weak := adler32.Checksum(content[:n])
+		for i := n; i < len(content); i++ {
+			weak = RollWeak(weak, n, content[i-n], content[i])
+			if want := adler32.Checksum(content[i-n+1 : i+1]); weak != want {
+				t.Fatalf("window of %d at %d: rolled %08x, want %08x", n, i-n+1, weak, want)
+			}
+		}
+	}
+}
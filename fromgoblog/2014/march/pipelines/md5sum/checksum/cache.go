@@ -0,0 +1,124 @@
+package checksum
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A Cache is a file recording the digest of each file of a tree along with
+// the size and modification time the file had, for Hasher.Cache. It holds the
+// states of the files as a JSON array, as WriteJSON writes them.
+type Cache struct {
+	path string
+
+	mu   sync.Mutex
+	prev Snapshot // as loaded by OpenCache
+	cur  Snapshot // the files recorded since
+}
+
+// OpenCache loads the cache stored in the file at path, which need not exist
+// yet, in which case the cache starts out empty.
+func OpenCache(path string) (*Cache, error) {
+	c := &Cache{path: path, prev: make(Snapshot), cur: make(Snapshot)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states []FileState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("checksum: cache %s: %v", path, err)
+	}
+	c.prev = NewSnapshot(states)
+	return c, nil
+}
+
+// lookup returns the digest c holds for the file at path, if the file still
+// has the size and modification time it had then and c has its digest under
+// the hash algo.
+func (c *Cache) lookup(path string, info os.FileInfo, algo string) (Digest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if st, ok := c.prev[path]; !ok || st.Algo != algo {
+		return nil, false
+	}
+	return c.prev.lookup(path, info)
+}
+
+// record adds the state of a file read, or looked up, during a run.
+func (c *Cache) record(st FileState) {
+	c.mu.Lock()
+	c.cur[st.Path] = st
+	c.mu.Unlock()
+}
+
+// Len returns the number of files recorded since c was opened.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.cur)
+}
+
+// Save replaces the file of c with the files recorded by the runs that used
+// it since it was opened. The files those runs did not find, such as those
+// deleted since, are dropped, as are those that could not be read, so a Cache
+// should only be saved after a complete scan of its tree. The file is written
+// to a temporary file first and then renamed, so that it is never left half
+// written.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	states := make([]FileState, 0, len(c.cur))
+	for _, st := range c.cur {
+		states = append(states, st)
+	}
+	c.mu.Unlock()
+	return c.write(states)
+}
+
+// Checkpoint is like Save, but keeps the files c was opened with that the runs
+// did not find, for a scan that was interrupted or stopped, which is to
+// resume from the file of c: the files it got to are not read again by the
+// next scan using the file, as long as they have not changed since.
+func (c *Cache) Checkpoint() error {
+	c.mu.Lock()
+	states := make([]FileState, 0, len(c.prev)+len(c.cur))
+	for _, st := range c.cur {
+		states = append(states, st)
+	}
+	for path, st := range c.prev {
+		if _, ok := c.cur[path]; !ok {
+			states = append(states, st)
+		}
+	}
+	c.mu.Unlock()
+	return c.write(states)
+}
+
+// write replaces the file of c with states.
+func (c *Cache) write(states []FileState) error {
+	sort.Slice(states, func(i, j int) bool { return states[i].Path < states[j].Path })
+
+	f, err := ioutil.TempFile(filepath.Dir(c.path), ".cache-")
+	if err != nil {
+		return err
+	}
+	if err := WriteJSON(f, states); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
@@ -0,0 +1,65 @@
+package checksum
+
+import (
+	"encoding/hex"
+	"hash"
+	"sort"
+)
+
+// ByDigest reads all the files in the file tree rooted at root and returns a
+// map from each distinct digest, computed with newHash, or with md5.New if
+// newHash is nil, and in hexadecimal, to the paths having it, sorted: the
+// index of a content-addressed store holding the tree. Unlike UniqueContent,
+// it keeps every path of each content. Digests shared by files of different
+// sizes, which only a weak hash such as CRC-32 is likely to produce, are
+// reported in Stats.DigestCollisions. If the directory walk fails or any read
+// operation fails, ByDigest returns an error.
+//
+// ByDigest uses a zero Hasher; see Hasher.ByDigest.
+func ByDigest(root string, newHash func() hash.Hash) (map[string][]string, error) {
+	return new(Hasher).ByDigest(root, newHash)
+}
+
+// ByDigest is like the package-level ByDigest, but reads the files through h,
+// and reports collisions in h.Stats, if it is non-nil. h.Hash is not used.
+func (h *Hasher) ByDigest(root string, newHash func() hash.Hash) (map[string][]string, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	dh := *h
+	dh.Hash = newHash
+
+	m := make(map[string][]string)
+	sizes := make(map[string]int64)
+	collided := make(map[string]bool)
+	err := dh.collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		sum := hex.EncodeToString(r.Sum)
+		if size, ok := sizes[sum]; !ok {
+			sizes[sum] = r.Size
+		} else if size != r.Size {
+			collided[sum] = true
+		}
+		m[sum] = append(m[sum], r.Path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var collisions []DigestCollision
+	for sum, paths := range m {
+		sort.Strings(paths)
+		if collided[sum] {
+			collisions = append(collisions, DigestCollision{sum, paths})
+		}
+	}
+	if h.Stats != nil {
+		sort.Slice(collisions, func(i, j int) bool { return collisions[i].Digest < collisions[j].Digest })
+		h.Stats.DigestCollisions = collisions
+	}
+
+	return m, nil
+}
@@ -0,0 +1,132 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A CheckStatus is the outcome of checking a file against its checksum.
+type CheckStatus int
+
+const (
+	// CheckOK means the file's digest matches its checksum.
+	CheckOK CheckStatus = iota + 1
+
+	// CheckFailed means the file's digest differs from its checksum, or
+	// the file could not be read.
+	CheckFailed
+
+	// CheckMissing means the file does not exist.
+	CheckMissing
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckOK:
+		return "OK"
+	case CheckFailed:
+		return "FAILED"
+	case CheckMissing:
+		return "MISSING"
+	}
+	return fmt.Sprintf("CheckStatus(%d)", int(s))
+}
+
+// A CheckResult is the outcome of checking a file listed in a checksum file, as
+// sent by Check. Expected is the listed checksum and Actual the digest of the
+// file, which is nil unless the file could be read. Err is the error reading the
+// file, if that is why it failed.
+type CheckResult struct {
+	Path     string
+	Status   CheckStatus
+	Expected Digest
+	Actual   Digest
+	Err      error
+}
+
+// Check reads the files listed in sums, a map from path to checksum such as
+// ParseCoreutilsDigests returns for the output of md5sum or sha256sum, and
+// checks each against its checksum, as md5sum -c does, but reading the files
+// in parallel. The results are sent on the returned channel sorted by path,
+// except for those of the missing files, which come last. A file that cannot
+// be read does not stop the check: it is reported as CheckFailed, with Err set.
+// Listed directories, and files the Hasher's filters skip, are left out. The
+// result channel is closed once every file has been checked, after which the
+// error channel reports nil, or the error that stopped the check.
+//
+// The caller must either receive from the result channel until it is closed
+// or cancel ctx. If ctx is canceled, Check abandons its work and the error
+// channel reports ctx.Err().
+//
+// Check uses a zero Hasher; see Hasher.Check.
+func Check(ctx context.Context, sums map[string]Digest) (<-chan CheckResult, <-chan error) {
+	return new(Hasher).Check(ctx, sums)
+}
+
+// Check is like the package-level Check, but reads the files through h and
+// digests them with h.Hash, which must be the hash the checksums were computed
+// with.
+func (h *Hasher) Check(ctx context.Context, sums map[string]Digest) (<-chan CheckResult, <-chan error) {
+	out := make(chan CheckResult)
+	errc := make(chan error, 1)
+
+	go func() {
+		err := h.check(ctx, sums, out)
+
+		close(out)
+
+		// No select needed here, since errc is buffered.
+		errc <- err
+	}()
+
+	return out, errc
+}
+
+// check checks the files listed in sums and sends the results on out.
+func (h *Hasher) check(ctx context.Context, sums map[string]Digest, out chan<- CheckResult) error {
+	send := func(cr CheckResult) error {
+		select {
+		case out <- cr:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	j := h.newJob()
+	j.ordered = true
+	j.paths = make([]string, 0, len(sums))
+	for path := range sums {
+		j.paths = append(j.paths, path)
+	}
+	sort.Strings(j.paths)
+
+	err := h.collectJob(ctx.Done(), ".", j, func(r Result) error {
+		cr := CheckResult{Path: r.Path, Expected: sums[r.Path], Err: r.Err}
+		switch {
+		case r.Err != nil:
+			cr.Status = CheckFailed
+		case h.match(cr.Expected, r.Sum):
+			cr.Status, cr.Actual = CheckOK, r.Sum
+		default:
+			cr.Status, cr.Actual = CheckFailed, r.Sum
+		}
+		return send(cr)
+	})
+	if errors.Is(err, ErrWalkCanceled) {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, path := range j.missing {
+		key := h.key(path)
+		if err := send(CheckResult{Path: key, Status: CheckMissing, Expected: sums[key]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
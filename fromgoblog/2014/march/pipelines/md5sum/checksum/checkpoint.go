@@ -0,0 +1,248 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A checkpoint records the digests of the files directly inside one
+// directory, as of the directory's modification time. It is stored as JSON in
+// Hasher.CheckpointDir.
+type checkpoint struct {
+	Dir     string                     `json:"dir"`
+	ModTime time.Time                  `json:"modTime"`
+	Files   map[string]checkpointEntry `json:"files"`
+}
+
+// checkpointEntry is the digest of a single file in a checkpoint, along with
+// the size and modification time it was computed for.
+type checkpointEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Sum     Digest    `json:"sum"`
+}
+
+// dirState tracks a directory whose files are being digested.
+type dirState struct {
+	cp checkpoint
+
+	// prev is the checkpoint loaded for the directory, if its modification
+	// time still matches.
+	prev *checkpoint
+
+	// pending is the number of files sent to the digesters whose results
+	// have not been recorded yet, and walked is set once the walk has moved
+	// past the directory. The checkpoint is written once both are true.
+	pending int
+	walked  bool
+}
+
+// checkpointer loads and writes the per-directory checkpoints of a single run.
+// The walk calls enter, leave, lookup and add; collect calls record.
+type checkpointer struct {
+	dir string
+
+	mu   sync.Mutex
+	dirs map[string]*dirState
+	err  error // first error writing a checkpoint
+
+	// open is the stack of directories the walk is currently inside. It is
+	// only used by the walk goroutine.
+	open []string
+}
+
+// newCheckpointer returns the checkpointer for a run of h, or nil if
+// h.CheckpointDir is not set.
+func (h *Hasher) newCheckpointer() *checkpointer {
+	if h.CheckpointDir == "" {
+		return nil
+	}
+	return &checkpointer{dir: h.CheckpointDir, dirs: make(map[string]*dirState)}
+}
+
+// name returns the name of the file holding the checkpoint for dir.
+func (cp *checkpointer) name(dir string) string {
+	sum := md5.Sum([]byte(dir))
+	return filepath.Join(cp.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns the stored checkpoint for dir, or nil if there is none that
+// matches the directory's current modification time.
+func (cp *checkpointer) load(dir string, modTime time.Time) *checkpoint {
+	data, err := ioutil.ReadFile(cp.name(dir))
+	if err != nil {
+		return nil
+	}
+
+	var prev checkpoint
+	if err := json.Unmarshal(data, &prev); err != nil || prev.Dir != dir || !prev.ModTime.Equal(modTime) {
+		return nil
+	}
+	return &prev
+}
+
+// enter starts tracking the directory at path.
+func (cp *checkpointer) enter(path string, info os.FileInfo) {
+	path = filepath.Clean(path)
+	d := &dirState{
+		cp:   checkpoint{Dir: path, ModTime: info.ModTime(), Files: make(map[string]checkpointEntry)},
+		prev: cp.load(path, info.ModTime()),
+	}
+
+	cp.mu.Lock()
+	cp.dirs[path] = d
+	cp.mu.Unlock()
+
+	cp.open = append(cp.open, path)
+}
+
+// inDir reports whether the clean path lies below the clean directory dir.
+func inDir(path, dir string) bool {
+	if dir == "." {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
+// leave marks every open directory that path does not lie below as walked,
+// since the walk visits the entries of a directory before moving on.
+func (cp *checkpointer) leave(path string) {
+	path = filepath.Clean(path)
+	for len(cp.open) > 0 && !inDir(path, cp.open[len(cp.open)-1]) {
+		cp.walked(cp.open[len(cp.open)-1])
+		cp.open = cp.open[:len(cp.open)-1]
+	}
+}
+
+// leaveAll marks every open directory as walked, once the walk has
+// completed.
+func (cp *checkpointer) leaveAll() {
+	for len(cp.open) > 0 {
+		cp.walked(cp.open[len(cp.open)-1])
+		cp.open = cp.open[:len(cp.open)-1]
+	}
+}
+
+// walked marks dir as walked, and writes its checkpoint if all of its files
+// have been recorded.
+func (cp *checkpointer) walked(dir string) {
+	cp.mu.Lock()
+	d := cp.dirs[dir]
+	d.walked = true
+	complete := d.pending == 0
+	if complete {
+		delete(cp.dirs, dir)
+	}
+	cp.mu.Unlock()
+
+	if complete {
+		cp.write(&d.cp)
+	}
+}
+
+// lookup returns the checkpointed sum of the regular file at path, if its
+// directory is unchanged and the file has the same size and modification time
+// as when the checkpoint was written.
+func (cp *checkpointer) lookup(path string, info os.FileInfo) (Digest, bool) {
+	cp.mu.Lock()
+	d := cp.dirs[filepath.Dir(path)]
+	cp.mu.Unlock()
+
+	if d == nil || d.prev == nil {
+		return nil, false
+	}
+	e, ok := d.prev.Files[filepath.Base(path)]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	return e.Sum, true
+}
+
+// add accounts for the file at path being sent to the digesters.
+func (cp *checkpointer) add(path string) {
+	cp.mu.Lock()
+	if d := cp.dirs[filepath.Dir(path)]; d != nil {
+		d.pending++
+	}
+	cp.mu.Unlock()
+}
+
+// record records the digest in r, unless reading the file failed or it was
+// skipped, and writes
+// the checkpoint of its directory if that was the last file the directory was
+// waiting for.
+func (cp *checkpointer) record(r Result) {
+	dir := filepath.Dir(r.Path)
+
+	cp.mu.Lock()
+	d := cp.dirs[dir]
+	if d == nil {
+		cp.mu.Unlock()
+		return
+	}
+	if r.Err == nil && !r.skipped && !r.locked {
+		d.cp.Files[filepath.Base(r.Path)] = checkpointEntry{r.Size, r.ModTime, r.Sum}
+	}
+	d.pending--
+	complete := d.walked && d.pending == 0
+	if complete {
+		delete(cp.dirs, dir)
+	}
+	cp.mu.Unlock()
+
+	if complete {
+		cp.write(&d.cp)
+	}
+}
+
+// write stores c in its checkpoint file, replacing the file atomically. The
+// first error is kept in cp.err.
+func (cp *checkpointer) write(c *checkpoint) {
+	err := cp.writeFile(c)
+
+	cp.mu.Lock()
+	if cp.err == nil {
+		cp.err = err
+	}
+	cp.mu.Unlock()
+}
+
+func (cp *checkpointer) writeFile(c *checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(cp.dir, ".checkpoint-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), cp.name(c.Dir))
+}
+
+// error returns the first error writing a checkpoint.
+func (cp *checkpointer) error() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.err
+}
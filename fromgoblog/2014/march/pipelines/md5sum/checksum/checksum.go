@@ -0,0 +1,1945 @@
+// Package checksum computes MD5 checksums of every regular file in a
+// directory tree, using a bounded number of digester goroutines. Other hash
+// algorithms can be used through Hasher.Hash.
+//
+// A run is a pipeline of three stages: a walk of the tree sending the files it
+// finds, a fixed number of digesters reading and hashing them, as set by
+// Hasher.Workers, and a collector gathering their results. Run, Stream and
+// StreamInto take a context.Context, whose cancellation, as on a timeout or a
+// signal, stops every stage and returns ctx.Err(); MD5All and the other
+// functions without one run to completion.
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrWalkCanceled is the error walkFiles reports when it abandons the walk
+// because done was closed.
+var ErrWalkCanceled = errors.New("checksum: walk canceled")
+
+// ErrStopped is the error a run reports once it has delivered the results of
+// the files its walk found before Hasher.Stop was closed.
+var ErrStopped = errors.New("checksum: walk stopped")
+
+// ErrDeadlineExceeded is the error a run reports when it is cut short by
+// Hasher.Deadline.
+var ErrDeadlineExceeded = errors.New("checksum: deadline exceeded")
+
+// ErrTooManyFiles is matched, with errors.Is, by the error a run reports when
+// its walk finds more files than Hasher.MaxFiles allows.
+var ErrTooManyFiles = errors.New("checksum: too many files")
+
+// tooManyFilesError is the error a run reports when its walk reaches n files,
+// one more than Hasher.MaxFiles.
+type tooManyFilesError struct {
+	n int
+}
+
+func (e *tooManyFilesError) Error() string {
+	return fmt.Sprintf("%v: walk reached %d files", ErrTooManyFiles, e.n)
+}
+
+func (e *tooManyFilesError) Is(target error) bool { return target == ErrTooManyFiles }
+
+// ErrPathTooDeep is the error, wrapped in a WalkError for the offending
+// directory, that a run reports when its walk finds a directory more than
+// maxWalkDepth levels below the root, such as one of the endless chain a
+// filesystem loop appears as.
+var ErrPathTooDeep = errors.New("checksum: path too deep")
+
+// maxWalkDepth is the number of levels below the root past which the walk
+// gives up with ErrPathTooDeep. It is far deeper than any sane tree, and keeps
+// the walk from recursing without bound on a corrupt or hostile one.
+const maxWalkDepth = 4096
+
+// DefaultTempPatterns are the temporary file patterns skipped when
+// Hasher.TempPatterns is nil: Vim swap files, Emacs lock files, and partial
+// downloads.
+var DefaultTempPatterns = []string{
+	"*.swp", "*.swo", "*.swx",
+	".#*",
+	"*.part", "*.partial", "*.crdownload", "*.download",
+}
+
+const (
+	// numDigesters is the least default number of goroutines used to read
+	// and digest files, enough to keep a disk busy.
+	numDigesters = 20
+
+	// digestersPerCPU is the default number of such goroutines per CPU,
+	// for machines with more CPUs than numDigesters keeps busy hashing
+	// while others wait on storage.
+	digestersPerCPU = 4
+)
+
+// defaultWorkers returns the default number of goroutines used to read and
+// digest files.
+func defaultWorkers() int {
+	if n := digestersPerCPU * runtime.NumCPU(); n > numDigesters {
+		return n
+	}
+	return numDigesters
+}
+
+// A Hasher computes the checksums of the files in a directory tree. The zero
+// value is ready to use, computes MD5 sums and reads files straight from the
+// OS filesystem.
+type Hasher struct {
+	// Hash returns a new hash.Hash for digesting a file. If nil, md5.New
+	// is used.
+	Hash func() hash.Hash
+
+	// HashSelector, if non-nil, chooses the hash of each file found by
+	// the walk, given its path and what the walk saw of it, such as
+	// SHA-256 for the images a tree publishes and CRC-32 for the rest, to
+	// be checked for changes. If it returns nil, Hash is used. The name of
+	// the hash each file was digested with is recorded in Result.Algo, so
+	// that a manifest can say which hash to check each file with; a tree
+	// is verified against such a manifest with the same HashSelector.
+	HashSelector func(path string, info os.FileInfo) func() hash.Hash
+
+	// ExtraHashes, if non-nil, are hashes computed besides Hash, by name,
+	// such as "sha256" for HashFunc("sha256"), in the same read of each
+	// file, for output that needs both MD5 sums for old manifests and
+	// SHA-256 ones for new ones. Their digests are in Result.Sums. A file
+	// is read even if Cache, a checkpoint or one of its hard links has its
+	// digest, since none of those keep the other digests.
+	ExtraHashes map[string]func() hash.Hash
+
+	// Workers is the number of goroutines reading and digesting files. If
+	// zero, four per CPU are used, and no fewer than 20.
+	Workers int
+
+	// WalkWorkers is the number of directories the walk lists at once,
+	// ahead of visiting them, for trees of many small files whose walk
+	// would otherwise be the bottleneck. The walk still visits files in
+	// lexical order, one at a time, so the order and the errors of a run
+	// do not depend on it. If zero, 8 are used.
+	WalkWorkers int
+
+	// Pool, if non-nil, is a pool of long-lived goroutines that digest the
+	// files of every run, instead of goroutines started for each run.
+	// Workers and AdaptiveWorkers are then ignored, unless SequentialMode
+	// or ReplayOrder is set.
+	Pool *Pool
+
+	// Pauser, if non-nil, lets the runs of the Hasher be paused and
+	// resumed while they are in progress.
+	Pauser *Pauser
+
+	// Stop, if non-nil, ends the walks of the runs of the Hasher once it is
+	// closed, as if their trees ended there, while the files already found
+	// are still digested and their results delivered, so that a run can be
+	// shut down without losing the work in progress, such as on Ctrl-C.
+	// Such a run then reports ErrStopped. Unlike canceling a run, a stop
+	// can take as long as the files being read take.
+	Stop <-chan struct{}
+
+	// ChunkBudget, if non-nil, bounds the memory the ranges read at once
+	// by HashReaderAt take up, across every Hasher sharing it. Without it,
+	// up to Workers ranges are held in memory at once.
+	ChunkBudget *MemoryBudget
+
+	// Tokens, if non-nil, is a concurrency budget the Hasher shares with
+	// the rest of the program: each digester takes a token from it before
+	// reading a file, having sent on the results it holds, and gives it
+	// back once the file is digested, so that no more files are read at
+	// once than Tokens allows, on top of the limit of Workers or Pool. If
+	// nil, the digesters take no tokens, as if from an unlimited source.
+	Tokens TokenSource
+
+	// ByteLimiter, if non-nil, paces the bytes the digesters read, and
+	// FileLimiter the files they open, an event each, for runs against a
+	// busy file server that are to stay in the background. Both may be
+	// shared with other Hashers, or with the rest of the program, to bound
+	// their reads together. A file read through UseMmap cannot be paced,
+	// so that UseMmap has no effect with a ByteLimiter.
+	ByteLimiter RateLimiter
+	FileLimiter RateLimiter
+
+	// AdaptiveWorkers is an experimental mode in which the number of
+	// goroutines digesting files varies during the run, between one and
+	// Workers. The run starts with two, and doubles their number every
+	// 100ms while files queue up faster than they are digested and the
+	// time taken by each file does not climb, as it does once the storage
+	// is saturated. Goroutines are retired one at a time when the queue
+	// runs dry or when latency climbs. BenchmarkWorkers compares it with
+	// fixed numbers of goroutines.
+	AdaptiveWorkers bool
+
+	// LoadAware makes a run back off while the machine is busy, for
+	// shared build machines. Every few seconds, the run reads the 1-minute
+	// load average, and while it exceeds the number of CPUs, lets only a
+	// proportional share of the Workers digesters read files at once, and
+	// never fewer than one: with 20 Workers on 8 CPUs, a load of 16 leaves
+	// 10 of them reading. It relies on /proc/loadavg, so it is only
+	// supported on Unix systems that have it; elsewhere, and with
+	// AdaptiveWorkers, SequentialMode, ReplayOrder or a Pool, it has no
+	// effect.
+	LoadAware bool
+
+	// PerDeviceWorkers, if positive, is the most digesters that read files
+	// of the same storage device at once, for trees spanning several disks,
+	// such as one with other filesystems mounted inside it, so that a slow
+	// disk cannot tie up every digester while a fast one waits. The files
+	// of a busy device are held back, up to a thousand or so, while the
+	// digesters it leaves free read those of the others, and the walk waits
+	// once that many are held. Devices are only known on Unix; elsewhere
+	// every file is taken to be on the same device. With AdaptiveWorkers,
+	// SequentialMode, ReplayOrder or a Pool, PerDeviceWorkers has no effect.
+	PerDeviceWorkers int
+
+	// SequentialMode trades concurrency for fewer seeks, for spinning
+	// disks where many concurrent readers spend their time moving the
+	// head between files. A single digester reads one file at a time, in
+	// the order of the walk, which sorts by path and so tends to follow
+	// the layout on disk. Each file is read in large chunks by a goroutine
+	// of its own, so the next chunk is read while the last one is hashed.
+	// Workers, AdaptiveWorkers and Pool are then ignored.
+	// BenchmarkSequentialMode measures the gain on a simulated disk.
+	SequentialMode bool
+
+	// RecordOrder, if non-nil, is written the path of each file, as found
+	// by the walk, as a digester starts reading it, one per line, so that
+	// the order of a run that misbehaved can be replayed with ReplayOrder.
+	// Paths containing a backslash, newline or carriage return are escaped
+	// as in WriteCoreutils. Writes are not buffered, so the log is complete
+	// up to the file being read should the program crash, and the first
+	// one to fail ends the log and is reported by the run, unless it
+	// failed otherwise.
+	RecordOrder io.Writer
+
+	// ReplayOrder, if non-nil, is read a log written to RecordOrder at the
+	// start of a run, which then digests the logged files, in the logged
+	// order, with a single digester, rather than those of the walk, to
+	// reproduce a run deterministically. Files are still filtered as the
+	// walk would, and those that no longer exist are left out. Workers,
+	// AdaptiveWorkers and Pool are then ignored. The log is read through,
+	// so a Hasher with ReplayOrder set can only make one run.
+	ReplayOrder io.Reader
+
+	// Exclude is a list of filepath.Match patterns. Files and directories
+	// whose base name matches any of them are skipped; excluded directories
+	// are not descended into.
+	Exclude []string
+
+	// ExcludePaths lists files and directories that are skipped wherever
+	// they are found in the tree, such as the file a scan writes its
+	// results to. Paths are compared once made absolute and with symbolic
+	// links in their directory resolved, so they need not be spelled the
+	// way the walk spells them.
+	ExcludePaths []string
+
+	// TempPatterns is a list of filepath.Match patterns for the temporary
+	// files editors and downloads write to while they work. Files whose
+	// base name matches any of them are skipped, since they are likely to
+	// be modified while being read. If nil, DefaultTempPatterns is used; an
+	// empty, non-nil slice disables the check.
+	TempPatterns []string
+
+	// IncludeExtensions, if non-empty, restricts the scan to files whose
+	// extension, as returned by filepath.Ext and including the dot, is one
+	// of the listed ones. Extensions are compared case-insensitively.
+	// Directories are always descended into. A file that matches Exclude is
+	// skipped even if its extension is included.
+	IncludeExtensions []string
+
+	// Include, if non-empty, is a list of filepath.Match patterns that
+	// restricts the scan to files whose base name matches one of them.
+	// Directories are always descended into, and Exclude still applies.
+	Include []string
+
+	// MaxDepth, if positive, is the number of levels below the root the
+	// walk goes: with 1, only the files directly in the root are digested,
+	// and no directory is descended into. Directories below are pruned as
+	// they are found, rather than walked and their files filtered out.
+	MaxDepth int
+
+	// GitIgnore makes the walk honor the .gitignore files it finds in the
+	// tree, as git does: the patterns of each apply to the files and
+	// directories below it, the last matching pattern wins, a "!" pattern
+	// re-includes what an earlier one ignored, and ignored directories,
+	// along with every directory named .git, are not descended into. Files
+	// under an ignored directory cannot be re-included. .gitignore files
+	// are read as the walk enters their directory, so they are not
+	// honored for the paths listed to VerifySubset or by ReplayOrder. The
+	// .gitignore files themselves are digested like any other file.
+	GitIgnore bool
+
+	// ExcludeContentTypes lists MIME types, such as "image/png", of files
+	// to skip whatever their name. The type of a file is sniffed from its
+	// first 512 bytes with http.DetectContentType, ignoring any parameters;
+	// an entry of the form "image/*" matches every subtype. Sniffing
+	// happens in the digesters, which go on hashing from the same open file
+	// if it is not skipped, so a skipped file still costs an open and a
+	// short read.
+	ExcludeContentTypes []string
+
+	// UseMmap makes the digesters memory-map files of at least 4 MiB and
+	// hash the mapped contents in one go, rather than copy them through a
+	// buffer, which can be faster for large files on local disks. Files
+	// that cannot be mapped, and all files on platforms other than Unix,
+	// are read as usual. UseMmap is ignored when OpenFunc or NormalizeEOL
+	// is set. A mapped file that is truncated while it is hashed crashes
+	// the program, so UseMmap is only safe on trees nothing else writes to.
+	// With Profile set, the time spent faulting in a mapped file counts as
+	// hashing time. BenchmarkDigestMmap and BenchmarkDigestStream compare
+	// the two on a file in the page cache, where mapping gains little.
+	UseMmap bool
+
+	// BufferSize is the size of the buffer each digester copies files
+	// through, so the memory a run takes up for reading stays at Workers
+	// times BufferSize however large the files are, as BenchmarkLargeFile
+	// shows. Larger buffers mean fewer reads, which helps on network
+	// filesystems. If zero, 32 KiB is used.
+	BufferSize int
+
+	// OpenFunc opens the file at path for reading. The digest of a file is
+	// computed over whatever OpenFunc returns, so it can for instance wrap
+	// the file in a gzip.Reader to hash the decompressed content. If nil,
+	// the file is opened with os.Open.
+	OpenFunc func(path string) (io.ReadCloser, error)
+
+	// FS, if non-nil, is the file system the tree is walked and read from
+	// instead of the operating system's, such as os.DirFS, a zip archive
+	// opened with zip.OpenReader, or an adapter to an object store, whose
+	// objects are listed and fetched as files. Roots and paths are then
+	// paths of FS, slash-separated and "." for its root, as fs.ValidPath
+	// requires, and Result.AbsPath is the same as Path. OpenFunc, if set,
+	// still opens the files. Since an fs.FS cannot follow symbolic links,
+	// they are skipped whatever SymlinkMode says, ArchiveMode has no
+	// effect, and neither has UseMmap.
+	FS fs.FS
+
+	// WatchDebounce is how long Watch waits after the last change to a
+	// file before hashing it again. If zero, 200 milliseconds.
+	WatchDebounce time.Duration
+
+	// VerifyReadsSample is the fraction, between 0 and 1, of files that are
+	// read and digested a second time to check that reading them is stable.
+	// Files whose second digest differs from the first are reported in
+	// Stats.Unstable; this points at flaky storage or at files modified
+	// during the scan. A zero value disables the check.
+	VerifyReadsSample float64
+
+	// DetectModified makes the digesters stat each file again once they
+	// have read it, and set Result.Modified if its size or modification
+	// time changed since the walk saw it, in which case the digest may mix
+	// old and new content.
+	DetectModified bool
+
+	// TruncateToWalkSize caps the read of each file at the size the walk
+	// saw, so that a file being appended to, such as a log, is digested
+	// as it was when the walk found it, and the digest is reproducible.
+	// Bytes appended since are left out, and Result.Truncated is set for
+	// files that had grown past their walked size.
+	TruncateToWalkSize bool
+
+	// ChunkSize, if positive, makes the digesters also digest every file
+	// of at least ChunkThreshold bytes in consecutive chunks of ChunkSize
+	// bytes, as BlockHashes does, while reading it for its whole-file
+	// digest, and set Result.Chunks. Kept in a ChunkManifest, the chunks
+	// let VerifyChunks tell which bytes of a large file are corrupt. It
+	// has no effect with NormalizeEOL, whose digests are not of the bytes
+	// on disk. Each chunk costs about as much memory as a BlockHash.
+	ChunkSize      int
+	ChunkThreshold int64
+
+	// StructureOnly makes the scan skip reading files altogether: the
+	// digest of each file is then computed over its size and mode rather
+	// than its contents. This is far cheaper than a full scan and, used
+	// with TreeRoot, still catches files being added, removed or renamed,
+	// or changing size, but not content changes that keep the size.
+	StructureOnly bool
+
+	// MetadataDigest is like StructureOnly, but computes the digest of each
+	// file over its modification time as well as its size and mode, for a
+	// fast first-pass screen of a tree at near the speed of the walk. It
+	// catches files whose size, modification time or mode changed, which
+	// most edits do, but not edits that keep all three, such as those of a
+	// tool that restores the modification time after writing. Results have
+	// the same shape as those of a full scan, so the digests can be
+	// compared between MetadataDigest runs, but never with content digests.
+	// MetadataDigest takes precedence over StructureOnly.
+	MetadataDigest bool
+
+	// NormalizeEOL makes text files hash as if every "\r\n" in them were a
+	// "\n", so that copies differing only in line endings get the same
+	// digest. Files that are not text, as decided by IsText, are always
+	// hashed as they are. With NormalizeEOL set, the digest of a text file
+	// with CRLF line endings no longer matches what md5sum computes from
+	// the bytes on disk.
+	NormalizeEOL bool
+
+	// IsText reports whether the file at path holds text, given its first
+	// bytes. It is only used when NormalizeEOL is set. If nil, the
+	// package-level IsText is used.
+	IsText func(path string, head []byte) bool
+
+	// SkipLocked makes the digesters skip files another process holds
+	// locked, listing them in Stats.Locked, rather than fail the run. Only
+	// Windows locks files this way, with sharing and lock violations, so
+	// SkipLocked has no effect elsewhere. A locked file that is not skipped
+	// fails with an error matching ErrLocked.
+	SkipLocked bool
+
+	// LockRetry, if positive, is how long a digester waits before trying a
+	// locked file once more, before giving up on it.
+	LockRetry time.Duration
+
+	// SymlinkMode says how symbolic links are treated. By default they are
+	// skipped.
+	SymlinkMode SymlinkMode
+
+	// ConfineToRoot, with SymlinkFollow or SymlinkFollowAll, skips the
+	// symbolic links whose target, once every link on the way is resolved,
+	// lies outside the root, such as a link to /etc/passwd in an untrusted
+	// tree, and lists them in Stats.OutsideRoot. Dangling links are still
+	// followed, to fail. Targets are resolved when the walk finds the link,
+	// so a tree changed while it is scanned can still redirect a link
+	// afterwards.
+	ConfineToRoot bool
+
+	// ArchiveMode records the special files a full filesystem archive
+	// like tar would, rather than skipping them, so that a manifest
+	// captures and verifies the whole state of a tree. Symbolic links,
+	// whatever SymlinkMode says, device nodes and named pipes are then
+	// reported with Result.Special set, and a digest over their kind, their
+	// mode, and the target of a link or the major and minor numbers of a
+	// device. Device numbers are only known on Linux and macOS; elsewhere
+	// devices are told apart by kind and mode alone, so manifests of
+	// devices only compare between systems of the same kind. Sockets are
+	// still skipped.
+	ArchiveMode bool
+
+	// WalkFunc, if non-nil, is called by the walk for each file it finds,
+	// once the other filters have let it through, to rewrite or veto it
+	// before it enters the pipeline. If keep is false, the file is
+	// skipped. Otherwise, a non-empty newPath replaces path from then on,
+	// both to open the file and as the path of its result, before
+	// KeyFunc, so that a path can be canonicalized, or a symbolic link
+	// read through its target; SkipFilter and SkipFunc then see newPath
+	// too. info still describes what the walk found at path. Since newPath
+	// need not lie where the walk is, two files may end up with the same
+	// path, and ResumeAfter is still compared with the paths of the walk.
+	// WalkFunc is only ever called from one goroutine at a time.
+	WalkFunc func(path string, info os.FileInfo) (newPath string, keep bool)
+
+	// SkipFunc, if non-nil, is called by the walk for each file before it
+	// is read, with the state of the scan so far. If it returns true, the
+	// file is skipped. Unlike a static filter, SkipFunc can base its
+	// decision on the progress of the scan, e.g. to stop reading files once
+	// enough bytes have been digested. It is only ever called from one
+	// goroutine at a time.
+	SkipFunc func(path string, info os.FileInfo, state ScanState) bool
+
+	// DedupHardLinks makes each run read a file with several hard links
+	// only once, and reuse its digest for the other links found to the same
+	// inode. A run remembers the digests of the last few thousand such
+	// inodes. Every link found after the first one gets Result.LinkOf. It
+	// only has an effect on Unix, where inodes are known, and is ignored
+	// when OpenFunc or NormalizeEOL is set, since what they make of a file
+	// may depend on the name of each link.
+	DedupHardLinks bool
+
+	// SkipFilter, if non-nil, is called by the walk with the path of each
+	// file, and the file is skipped if it returns true. It is meant for
+	// scans sharded across machines, where it reports the paths covered by
+	// other workers, e.g. by querying a Bloom filter of them. Such a filter
+	// may wrongly report a path as covered, in which case no worker hashes
+	// the file, so the coordinator must tolerate or catch up on these
+	// misses; a path wrongly reported as not covered is merely hashed
+	// twice. SkipFilter is only ever called from one goroutine at a time.
+	SkipFilter func(path string) bool
+
+	// CheckpointDir, if set, is a directory in which a checkpoint is
+	// written for every directory of the tree once all the files directly
+	// inside it have been digested. A later run with the same CheckpointDir
+	// reuses the digests of a checkpointed directory whose modification time
+	// has not changed, for each file whose size and modification time have
+	// not changed either, so an interrupted scan can pick up where it left
+	// off. A directory whose modification time changed is fully re-read.
+	// Hashers with different Hash functions, or differing in StructureOnly
+	// or MetadataDigest, must not share a CheckpointDir.
+	CheckpointDir string
+
+	// Cache, if non-nil, holds the digests of an earlier scan, reused for
+	// each file whose size and modification time have not changed since,
+	// so that only new and changed files are read. Every file read or
+	// looked up is recorded in the Cache, for Cache.Save to write out.
+	// Only digests computed with the same hash, as Result.Algo names it,
+	// are reused, but Hashers differing in StructureOnly, MetadataDigest
+	// or NormalizeEOL must not share a Cache.
+	Cache *Cache
+
+	// Expanders, if non-nil, are tried in turn on each file, and the first
+	// whose Match accepts it has the file, such as an archive, read as a
+	// container: there is a result per regular member instead of one for
+	// the file, with a path made of the path of the file, MemberSep and
+	// the name of the member, and a digest computed with Hash. Members that
+	// are themselves containers are not expanded. A container that cannot
+	// be read as one has a result with an error. Stats and checkpoints
+	// still count a container as a single file, and special files, files
+	// digested from their metadata or structure, and symbolic links
+	// digested from their target are never expanded. ArchiveExpanders
+	// returns the Expanders of the archive formats the package knows.
+	Expanders []Expander
+
+	// TopSlowest, if positive, is the number of files that took longest to
+	// read and digest that are listed in Stats.Slowest.
+	TopSlowest int
+
+	// Profile makes each run measure how long it spends walking the tree,
+	// reading files and hashing their contents, and report it in Stats.
+	// Reading and hashing are timed separately by wrapping the file and
+	// the hash, which slows the digesters down slightly.
+	Profile bool
+
+	// OnFileStart and OnFileDone, if non-nil, are called by the digesters
+	// as they start and finish reading each file, for tracing or metrics.
+	// OnFileStart gets the path as found by the walk, and OnFileDone the
+	// result under that same path, before KeyFunc, along with the time the
+	// file took. A file skipped for its content type is still reported to
+	// OnFileDone, without a digest. Both are called from many goroutines at
+	// once and must be safe for concurrent use; they hold up the digester
+	// calling them until they return.
+	OnFileStart func(path string)
+	OnFileDone  func(r Result, d time.Duration)
+
+	// OnProgress, if non-nil, is called with the number of files whose
+	// results a run has handed over so far, such as by sending them on the
+	// channel of Stream, and the sum of their sizes. It is called at most
+	// every 100ms while the run goes on, and exactly once more as the run
+	// returns, whether it completed, failed or was canceled, with the final
+	// totals. It is only ever called from one goroutine at a time.
+	OnProgress func(files int, bytes int64)
+
+	// ProgressFunc, if non-nil, is called with the progress of a run every
+	// 100ms while it goes on, even while no file completes, and exactly
+	// once more as the run returns, with the final totals. It is only ever
+	// called from one goroutine at a time. CountFirst makes a run with a
+	// ProgressFunc walk the tree once without reading any file before it
+	// starts, so that the totals, and with them Progress.ETA, are known
+	// from the start rather than once the walk is over, for the cost of
+	// that walk.
+	ProgressFunc func(p Progress)
+	CountFirst   bool
+
+	// Transform, if non-nil, is called by the digesters with each file
+	// they read successfully, once it is hashed and before its result is
+	// sent on, to attach derived data to the result, such as a detected
+	// file type, without reading the file a second time. data holds the
+	// bytes that were hashed, unless the file is larger than
+	// TransformBuffer, or its digest came from a checkpoint, a snapshot
+	// or a hard link without reading it, in which case data is nil and
+	// the file can be read again from r.Path, still the path as found by
+	// the walk. data must not be kept once Transform returns. Transform
+	// is called from many goroutines at once, before OnFileDone.
+	Transform func(r *Result, data []byte)
+
+	// TransformBuffer is the size of the largest file whose bytes are
+	// kept for Transform. Each digester may hold that many bytes at a
+	// time on top of its copy buffer, so with 20 Workers, a buffer of
+	// 64 MiB costs up to 1.25 GiB. If zero, 1 MiB is used.
+	TransformBuffer int64
+
+	// FsyncEvery and FsyncInterval say how often StreamToFile flushes the
+	// manifest it writes and syncs it to disk: every FsyncEvery results, if
+	// positive, and every FsyncInterval, if positive, even while no result
+	// comes. Each sync costs a round trip to the disk. If both are zero, the
+	// manifest is only synced once the scan is over.
+	FsyncEvery    int
+	FsyncInterval time.Duration
+
+	// OrderByDirectory makes Stream, StreamInto and the other functions
+	// that deliver results as they come hold back the results of each
+	// directory until every one of its files has been digested, and then
+	// deliver them back to back, in the order the walk found them, as
+	// StreamDirs does. A directory with many files, or one of them slow to
+	// read, holds its results in memory until it is complete.
+	OrderByDirectory bool
+
+	// ResumeAfter, if set, is the path of the last file a previous run
+	// completed, in the same form as Result.Path. The walk visits files in
+	// lexical order, and with ResumeAfter set it skips every path up to and
+	// including ResumeAfter without reading it, and does not descend into
+	// directories that lie entirely before it. This only resumes correctly
+	// if the tree has not changed before ResumeAfter since that run.
+	ResumeAfter string
+
+	// KeyFunc, if non-nil, transforms the path of every file once it has
+	// been digested, before it becomes a map key or the Path of a Result.
+	// It can for instance make paths relative to the root, use forward
+	// slashes, strip a prefix, or normalize Unicode names to NFC with
+	// golang.org/x/text/unicode/norm's norm.NFC.String, so that manifests
+	// made on macOS and Linux agree. Paths KeyFunc maps to the same key
+	// collide, and maps keep only one of them; the collisions are listed in
+	// Stats.Collisions.
+	KeyFunc func(path string) string
+
+	// InvalidNames says what becomes of files and directories whose name
+	// is not valid UTF-8, as found in old backups and trees written under
+	// other locales, which many manifest readers mangle. By default they
+	// are digested like any other.
+	InvalidNames NameMode
+
+	// BindPath makes each run fill in Result.BoundSum, a digest binding
+	// each file's contents to its path, so that a manifest of bound sums
+	// catches two files swapping contents, which a manifest of plain sums
+	// lists as unchanged.
+	BindPath bool
+
+	// ContentOnlyDiff makes DiffSnapshots consider a file unchanged when
+	// its digest is the same in both snapshots, even if its modification
+	// time differs, as is common for files restored from a backup.
+	ContentOnlyDiff bool
+
+	// StrictExtra makes Verify and VerifyStream treat files of the tree
+	// that are not in the manifest as failures rather than informational
+	// mismatches.
+	StrictExtra bool
+
+	// CompareFunc, if non-nil, is how Verify and the functions built on it
+	// decide whether the digest of a file matches the expected one from
+	// the manifest, for match semantics other than exact equality. By
+	// default digests must be equal, and are compared in constant time
+	// with hmac.Equal, so that manifests of HMACs are safe to check.
+	CompareFunc func(expected, actual []byte) bool
+
+	// TopDuplicateBy says how TopDuplicate ranks groups of duplicate files.
+	// By default it picks the group wasting the most bytes.
+	TopDuplicateBy DuplicateRank
+
+	// Deadline, if not zero, is the time at which a run stops reading
+	// files and returns what it has so far along with ErrDeadlineExceeded.
+	// MD5All and ScanStates return their partial results with the error,
+	// and Stream has sent them by the time it reports it.
+	Deadline time.Time
+
+	// MaxFiles, if positive, is the largest number of files a run may
+	// find, as a guard against scanning much more than intended, such as
+	// the whole filesystem. Once the walk finds one more, it stops, and
+	// the run returns an error matching ErrTooManyFiles, after the results
+	// of the files already found. Files left out by the filters do not
+	// count.
+	MaxFiles int
+
+	// ErrorPolicy says whether MD5All, Run, SumAll and ScanStates stop at
+	// the first file that cannot be read, or return the results of the
+	// others along with ReadErrors. Functions that deliver results as they
+	// come, such as Stream, always go on, with Result.Err set.
+	ErrorPolicy ErrorPolicy
+
+	// Stats, if non-nil, is filled in with statistics about each run once
+	// it returns. A Hasher with Stats set must not be used for concurrent
+	// runs.
+	Stats *Stats
+}
+
+// newHash returns a new hash.Hash using h.Hash.
+func (h *Hasher) newHash() hash.Hash {
+	if h.Hash != nil {
+		return h.Hash()
+	}
+	return md5.New()
+}
+
+// workers returns the number of digesters to start.
+func (h *Hasher) workers() int {
+	if h.Workers > 0 {
+		return h.Workers
+	}
+	return defaultWorkers()
+}
+
+// excluded reports whether the file at path matches one of h.Exclude.
+func (h *Hasher) excluded(path string) (bool, error) {
+	return matchAny(h.Exclude, path)
+}
+
+// temp reports whether the file at path matches one of h.TempPatterns.
+func (h *Hasher) temp(path string) (bool, error) {
+	if h.TempPatterns == nil {
+		return matchAny(DefaultTempPatterns, path)
+	}
+	return matchAny(h.TempPatterns, path)
+}
+
+// matchAny reports whether the base name of path matches one of patterns.
+func matchAny(patterns []string, path string) (bool, error) {
+	name := filepath.Base(path)
+	for _, pattern := range patterns {
+		if ok, err := filepath.Match(pattern, name); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// before reports whether the walk should skip path because it comes before
+// h.ResumeAfter in walk order, or is h.ResumeAfter itself, and if so whether
+// it must still descend into path, because h.ResumeAfter lies inside it.
+func (h *Hasher) before(path string) (skip, descend bool) {
+	path, resume := filepath.Clean(path), filepath.Clean(h.ResumeAfter)
+	if walkOrder(path, resume) > 0 {
+		return false, false
+	}
+	return true, path == resume || inDir(resume, path)
+}
+
+// walkOrder compares the clean paths a and b in the order filepath.Walk visits
+// them, which sorts the names in each directory but visits a directory's
+// contents before its next sibling. It returns -1, 0 or +1.
+func walkOrder(a, b string) int {
+	as := strings.Split(a, string(filepath.Separator))
+	bs := strings.Split(b, string(filepath.Separator))
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return +1
+	}
+	return 0
+}
+
+// extensionSet returns the set of h.IncludeExtensions, lowercased, or nil if
+// there are none.
+func (h *Hasher) extensionSet() map[string]bool {
+	if len(h.IncludeExtensions) == 0 {
+		return nil
+	}
+	exts := make(map[string]bool, len(h.IncludeExtensions))
+	for _, ext := range h.IncludeExtensions {
+		exts[strings.ToLower(ext)] = true
+	}
+	return exts
+}
+
+// key returns the path reported for the file at path, using h.KeyFunc, and
+// escaped as h.InvalidNames says.
+func (h *Hasher) key(path string) string {
+	if h.KeyFunc != nil {
+		path = h.KeyFunc(path)
+	}
+	if h.InvalidNames == InvalidNamesEscape {
+		path = EscapePath(path)
+	}
+	return path
+}
+
+// open opens the file at path using h.OpenFunc, or from h.FS.
+func (h *Hasher) open(path string) (io.ReadCloser, error) {
+	if h.OpenFunc != nil {
+		return h.OpenFunc(path)
+	}
+	if h.FS != nil {
+		return h.FS.Open(path)
+	}
+	return os.Open(path)
+}
+
+// file is a regular file found by walkFiles, along with the FileInfo the walk
+// saw for it. If cached is set, sum is the file's digest as recorded in a
+// checkpoint, and the file need not be read. symlink is set if path is a
+// symbolic link, in which case info describes whatever h.SymlinkMode digests.
+// special is set if the file is digested from its metadata, for
+// h.ArchiveMode. dev is the device holding the file, once the scheduler of a
+// h.PerDeviceWorkers run has looked it up. linkOf is the path of the first
+// file the walk found with the same inode, for h.DedupHardLinks. seq counts
+// the files the walk sent before this one.
+type file struct {
+	path    string
+	info    os.FileInfo
+	cached  bool
+	sum     Digest
+	symlink bool
+	special bool
+	linkOf  string
+	dev     uint64
+	seq     int64
+}
+
+// job holds the state shared by the goroutines of a single run of a Hasher.
+type job struct {
+	// digested and digestedBytes count the files digested so far and their
+	// sizes, and failed and failedBytes those of them that could not be
+	// read. They are accessed atomically.
+	digested      int64
+	digestedBytes int64
+	failed        int64
+	failedBytes   int64
+
+	// cp, if non-nil, keeps the checkpoints of the run.
+	cp *checkpointer
+
+	// adaptive, if non-nil, runs the digesters of an AdaptiveWorkers run.
+	adaptive *adaptive
+
+	// throttle, if non-nil, limits how many digesters of a LoadAware run
+	// read files at once.
+	throttle *loadThrottle
+
+	// tokens, if non-nil, is the h.Tokens the digesters take a token from
+	// before reading a file. ctx lasts as long as the run does, and bounds
+	// the waits for tokens and for h.ByteLimiter and h.FileLimiter.
+	tokens TokenSource
+	ctx    context.Context
+
+	// devices, if non-nil, limits how many digesters of a PerDeviceWorkers
+	// run read files of each device at once.
+	devices *deviceScheduler
+
+	// prev, if non-nil, is a snapshot whose digests are reused for files
+	// that have not changed since it was taken.
+	prev Snapshot
+
+	// links, if non-nil, holds the digests of hard-linked files read so
+	// far.
+	links *linkCache
+
+	// linked maps each hard-linked inode the walk found, when links is
+	// non-nil, to the path of the first file found with it. It is only
+	// used by the walk goroutine.
+	linked map[fileID]string
+
+	// order, if non-nil, logs the files digested to h.RecordOrder.
+	order *orderLog
+
+	// ordered is set if collectJob must see the results in the order the
+	// walk sent the files, rather than as they complete.
+	ordered bool
+
+	// dirs, if non-nil, gathers the results per directory.
+	dirs *dirTracker
+
+	// invalid lists the paths with names that are not valid UTF-8 found by
+	// the walk, when h.InvalidNames asks for them, outside the symbolic
+	// links skipped because of h.ConfineToRoot, loops those skipped because
+	// of SymlinkFollowAll, and special the sockets, named pipes and devices
+	// skipped. They are guarded by mu, since the walk may still go on when
+	// the run returns.
+	mu      sync.Mutex
+	invalid []string
+	outside []string
+	loops   []string
+	special []string
+
+	// found and foundBytes count the files the walk sent so far and their
+	// sizes, and counted is set once the walk is over. They are accessed
+	// atomically. total and totalBytes are the totals counted first
+	// because of h.CountFirst, or -1.
+	found      int64
+	foundBytes int64
+	counted    int32
+	total      int64
+	totalBytes int64
+
+	// paths, if non-nil, lists the only paths walkFiles visits, instead of
+	// walking the tree, and missing is set for those that do not exist
+	// once the walk is over.
+	paths   []string
+	missing []string
+
+	// base, if set, is the root of the tree the walk of the directory at
+	// root lies in, from which h.MaxDepth counts and whose .gitignore
+	// files down to root apply, as if the walk of base had reached root.
+	base string
+
+	// profile is set if the run is profiled, in which case walkTime,
+	// readTime and hashTime accumulate the time spent walking, reading and
+	// hashing, in nanoseconds. They are accessed atomically.
+	profile  bool
+	walkTime int64
+	readTime int64
+	hashTime int64
+}
+
+// newJob returns the state for a new run of h.
+func (h *Hasher) newJob() *job {
+	j := &job{cp: h.newCheckpointer(), profile: h.Profile, total: -1}
+	if h.RecordOrder != nil {
+		j.order = &orderLog{w: h.RecordOrder}
+	}
+	if h.DedupHardLinks && h.OpenFunc == nil && !h.NormalizeEOL {
+		j.links = newLinkCache(linkCacheSize)
+		j.linked = make(map[fileID]string)
+	}
+	return j
+}
+
+// rootDepth returns the number of separators in the paths filepath.Walk finds
+// right under root, less one, so that a directory whose path has n more
+// separators than that lies n levels below root.
+func rootDepth(root string) int {
+	root = filepath.Clean(root)
+	if root == "." {
+		return -1
+	}
+	n := strings.Count(root, string(filepath.Separator))
+	if os.IsPathSeparator(root[len(root)-1]) {
+		// Only a volume root, such as "/", ends in a separator, which
+		// the paths under it share.
+		n--
+	}
+	return n
+}
+
+// walkFiles starts a goroutine to walk the directory tree at root and send each
+// regular file, each symbolic link h.SymlinkMode does not skip, and each
+// special file h.ArchiveMode records, on the file channel. It sends the result
+// of the walk on the error channel. If done is closed, walkFiles abandons its
+// work and reports ErrWalkCanceled, unless the walk had already failed with a
+// genuine error, in which case that error is reported instead.
+//
+// Files up to h.ResumeAfter, those in h.ExcludePaths, those matching
+// h.Exclude or h.TempPatterns or lacking one of h.IncludeExtensions or
+// h.Include, those past h.MaxDepth, those ignored with h.GitIgnore, those
+// with names h.InvalidNames skips, symbolic links h.ConfineToRoot keeps from
+// leaving root, those h.WalkFunc vetoes, and those for which h.SkipFilter or
+// h.SkipFunc returns true, are not sent; h.WalkFunc may also change the path
+// of a file. If j has a checkpointer, walkFiles tells it about each directory
+// it walks and each file it sends, and takes the digests of unchanged files
+// from it, or else from j.prev. If j lists paths, or h.ReplayOrder does,
+// walkFiles visits them alone rather than the tree. Once more than h.MaxFiles
+// files have been found, the walk stops with an error matching
+// ErrTooManyFiles, and once a directory lies more than maxWalkDepth levels
+// below root, with ErrPathTooDeep.
+func (h *Hasher) walkFiles(done <-chan struct{}, root string, j *job) (<-chan file, <-chan error) {
+	// With AdaptiveWorkers, the files wait in a queue whose length tells
+	// the pool whether the digesters keep up.
+	var queue int
+	if h.AdaptiveWorkers && h.Pool == nil && !h.serial() {
+		queue = h.workers()
+	}
+	files := make(chan file, queue)
+	errc := make(chan error, 1)
+
+	go func() {
+		cp := j.cp
+		exts := h.extensionSet()
+		var confine string
+		if h.ConfineToRoot && (h.SymlinkMode == SymlinkFollow || h.SymlinkMode == SymlinkFollowAll) && !h.ArchiveMode {
+			confine = canonicalRoot(root)
+		}
+		var state ScanState
+		var seq int64
+		rootDepth := rootDepth(root)
+
+		// The walk is timed without the time spent waiting for a
+		// digester to take a file.
+		start := time.Now()
+		var blocked time.Duration
+
+		// Close the files channel after Walk returns.
+		defer close(files)
+
+		filter := h.newPathFilter(root)
+		if j.base != "" {
+			filter = h.newPathFilter(j.base)
+			if _, err := filter.descend(root); err != nil {
+				errc <- &WalkError{root, err}
+				return
+			}
+		}
+
+		walk := func(path string, info os.FileInfo, err error) error {
+			// A genuine error stops the walk right away, so it is
+			// never replaced by ErrWalkCanceled. Once done is
+			// closed, the walk goes no further, so that it cannot
+			// meet errors after that either.
+			if err != nil {
+				return &WalkError{path, err}
+			}
+			select {
+			case <-done:
+				return ErrWalkCanceled
+			default:
+			}
+			if info.IsDir() && strings.Count(path, string(filepath.Separator))-rootDepth > maxWalkDepth {
+				return &WalkError{path, ErrPathTooDeep}
+			}
+			if h.ResumeAfter != "" {
+				if skip, descend := h.before(path); skip && info.IsDir() && !descend {
+					return filepath.SkipDir
+				} else if skip {
+					return nil
+				}
+			}
+			if skip, err := filter.skip(path, info.IsDir()); err != nil {
+				return &WalkError{path, err}
+			} else if skip && info.IsDir() {
+				return filepath.SkipDir
+			} else if skip {
+				return nil
+			}
+			if h.InvalidNames != InvalidNamesKeep && !utf8.ValidString(info.Name()) {
+				j.note(&j.invalid, path)
+				if h.InvalidNames == InvalidNamesSkip && info.IsDir() {
+					return filepath.SkipDir
+				} else if h.InvalidNames == InvalidNamesSkip {
+					return nil
+				}
+			}
+			if cp != nil {
+				cp.leave(path)
+				if info.IsDir() {
+					cp.enter(path, info)
+				}
+			}
+			if j.dirs != nil {
+				j.dirs.leave(path)
+			}
+
+			f := file{path: path, info: info}
+			if h.ArchiveMode && h.FS == nil && archived(fileType(info)) {
+				f.special = true
+				f.symlink = fileType(info)&os.ModeSymlink != 0
+			} else if fileType(info)&os.ModeSymlink != 0 {
+				if h.FS != nil {
+					return nil
+				}
+				if confine != "" && !inside(confine, path) {
+					j.note(&j.outside, path)
+					return nil
+				}
+				var ok bool
+				if f, ok = h.linkFile(path, info); !ok {
+					return nil
+				}
+			} else if !fileType(info).IsRegular() {
+				if !info.IsDir() {
+					j.note(&j.special, path)
+				}
+				return nil
+			}
+			if exts != nil && !exts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			if len(h.Include) > 0 {
+				if ok, err := matchAny(h.Include, path); err != nil {
+					return &WalkError{path, err}
+				} else if !ok {
+					return nil
+				}
+			}
+			if skip, err := h.temp(path); err != nil {
+				return &WalkError{path, err}
+			} else if skip {
+				return nil
+			}
+
+			if h.WalkFunc != nil {
+				newPath, keep := h.WalkFunc(path, f.info)
+				if !keep {
+					return nil
+				}
+				if newPath != "" {
+					f.path = newPath
+				}
+			}
+			if h.SkipFilter != nil && h.SkipFilter(f.path) {
+				return nil
+			}
+			if h.SkipFunc != nil {
+				state.Digested = int(atomic.LoadInt64(&j.digested))
+				state.DigestedBytes = atomic.LoadInt64(&j.digestedBytes)
+				if h.SkipFunc(f.path, f.info, state) {
+					state.Skipped++
+					return nil
+				}
+			}
+			state.Walked++
+			state.WalkedBytes += f.info.Size()
+			atomic.AddInt64(&j.found, 1)
+			atomic.AddInt64(&j.foundBytes, f.info.Size())
+			if h.MaxFiles > 0 && state.Walked > h.MaxFiles {
+				return &tooManyFilesError{state.Walked}
+			}
+
+			if cp != nil {
+				f.sum, f.cached = cp.lookup(f.path, f.info)
+				cp.add(f.path)
+			}
+			if !f.cached && j.prev != nil {
+				f.sum, f.cached = j.prev.lookup(h.key(f.path), f.info)
+			}
+			if !f.cached && h.Cache != nil {
+				f.sum, f.cached = h.Cache.lookup(h.key(f.path), f.info, hashName(h.Hash))
+			}
+
+			if j.linked != nil && !f.special {
+				if id, ok := hardLinked(f.info); ok {
+					if first, seen := j.linked[id]; seen {
+						f.linkOf = first
+					} else {
+						j.linked[id] = f.path
+					}
+				}
+			}
+
+			select {
+			case <-h.Stop:
+				return ErrStopped
+			default:
+			}
+			f.seq = seq
+			if j.dirs != nil {
+				j.dirs.add(seq, path)
+			}
+			sent := time.Now()
+			select {
+			case files <- f:
+			case <-done:
+				return ErrWalkCanceled
+			case <-h.Stop:
+				return ErrStopped
+			}
+			blocked += time.Since(sent)
+			seq++
+
+			return nil
+		}
+
+		if j.paths == nil && h.ReplayOrder != nil {
+			paths, err := readOrder(h.ReplayOrder)
+			if err != nil {
+				errc <- err
+				return
+			}
+			j.paths = paths
+		}
+
+		var err error
+		if j.paths != nil {
+			j.missing, err = walkPaths(j.paths, h.FS, walk)
+		} else {
+			var follow func(string) (os.FileInfo, bool)
+			if h.SymlinkMode == SymlinkFollowAll && !h.ArchiveMode && h.FS == nil {
+				follow = func(path string) (os.FileInfo, bool) { return followDir(confine, path) }
+			}
+			err = walkTree(root, h.walkWorkers(), walk, follow, func(path string) { j.note(&j.loops, path) }, h.FS)
+		}
+		if err == nil && cp != nil {
+			cp.leaveAll()
+		}
+		if err == nil && j.dirs != nil {
+			j.dirs.leaveAll()
+		}
+		if err == nil {
+			atomic.StoreInt32(&j.counted, 1)
+		}
+		atomic.StoreInt64(&j.walkTime, int64(time.Since(start)-blocked))
+
+		// No select needed for this send, since errc is buffered.
+		errc <- err
+	}()
+
+	return files, errc
+}
+
+// Result is the result of digesting a single file: its path, size and
+// modification time as seen by the walk, the digest of its contents, and the
+// error reading it, if any. Symlink is set if the path is a symbolic link
+// digested according to Hasher.SymlinkMode. Modified is set, when
+// Hasher.DetectModified is, if the file changed while it was being read, so
+// Sum cannot be relied upon. Truncated is set, when Hasher.TruncateToWalkSize
+// is, if the file had grown past Size by the time it was read, in which case
+// Sum only covers its first Size bytes. Special is set, when
+// Hasher.ArchiveMode is, if the file is a symbolic link, device node or named
+// pipe, whose Sum is computed over its metadata. Chunks is set, when
+// Hasher.ChunkSize is, to the digests of the consecutive chunks of a large
+// file.
+//
+// Path is the path as found by the walk, transformed by Hasher.KeyFunc if any,
+// while AbsPath is always the absolute path of the file on disk, or its path
+// in Hasher.FS, which can be opened whatever KeyFunc did to Path.
+//
+// BoundSum is only set with Hasher.BindPath. It is the hash of Path and Sum,
+// each prefixed with its length, so it changes if either does, while Sum only
+// ever depends on the contents.
+//
+// Attrs holds whatever Hasher.Transform attached to the result, and is nil
+// otherwise. Algo names the hash Sum was computed with, as HashFunc knows it,
+// such as "sha256", and is empty for a hash HashFunc does not know. Sums is
+// only set with Hasher.ExtraHashes, for a file whose contents were read, to
+// its digests by hash name, Sum under Algo included. LinkOf is set, when
+// Hasher.DedupHardLinks is, for a file with the same inode as a file the walk
+// found before it, to the path of that file, so that every path of a
+// hard-linked file can be told. Removed is only set by Watch, on a result
+// telling that the file at Path is gone, which has nothing else but AbsPath.
+type Result struct {
+	Path      string
+	AbsPath   string
+	Size      int64
+	ModTime   time.Time
+	Sum       Digest
+	BoundSum  Digest
+	Err       error
+	Symlink   bool
+	Modified  bool
+	Truncated bool
+	Special   bool
+	Chunks    []BlockHash
+	Attrs     map[string]interface{}
+	Algo      string
+	Sums      map[string]Digest
+	LinkOf    string
+	Removed   bool
+
+	// reverified is set if the file was read a second time because of
+	// VerifyReadsSample, and unstable if that read produced a different
+	// digest or failed.
+	reverified bool
+	unstable   bool
+
+	// skipped is set if the file was skipped for its content type, as
+	// sniffed because of ExcludeContentTypes. Such results are dropped
+	// before anyone sees them.
+	skipped bool
+
+	// locked is set if the file was skipped because another process held
+	// it locked, as SkipLocked allows.
+	locked bool
+
+	// elapsed is the time it took to digest the file.
+	elapsed time.Duration
+
+	// data, if non-nil, holds the bytes of the file kept for
+	// Hasher.Transform.
+	data *bytes.Buffer
+
+	// members, if non-nil, are the results of the members of the file, a
+	// container expanded because of Hasher.Expanders, which are delivered
+	// in its place. more is set on each of them but the last.
+	members []Result
+	more    bool
+
+	// seq is the position of the file in the order of the walk.
+	seq int64
+}
+
+// pathError returns err as an *os.PathError for op on path, unless it already
+// is one. Errors from os.Open and os.File.Read already name the file, but
+// those from an OpenFunc or the reader it returns may not.
+func pathError(op, path string, err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// copyBufSize is the default value of Hasher.BufferSize.
+const copyBufSize = 32 << 10
+
+// copyBufs holds the copy buffers of the default size of digesters that have
+// returned, for reuse by those started later, such as by AdaptiveWorkers.
+var copyBufs = sync.Pool{
+	New: func() interface{} { return make([]byte, copyBufSize) },
+}
+
+// bufferSize returns the size of the buffer the digesters of h copy files
+// through.
+func (h *Hasher) bufferSize() int {
+	if h.BufferSize > 0 {
+		return h.BufferSize
+	}
+	return copyBufSize
+}
+
+// copyBuffer returns a buffer for a digester of h to copy files through, to
+// be handed back to putCopyBuffer once the digester is done.
+func (h *Hasher) copyBuffer() []byte {
+	if n := h.bufferSize(); n != copyBufSize {
+		return make([]byte, n)
+	}
+	return copyBufs.Get().([]byte)
+}
+
+// putCopyBuffer keeps buf for reuse, if it has the default size.
+func putCopyBuffer(buf []byte) {
+	if len(buf) == copyBufSize {
+		copyBufs.Put(buf)
+	}
+}
+
+// sum returns the digest of the contents of the file at path, copied through
+// buf, timing the read in j if the run is profiled, and doing what o asks
+// besides, if o is non-nil. If opening or reading the file fails, sum returns
+// no digest at all, rather than the digest of the bytes read so far, and an
+// error naming path.
+func (h *Hasher) sum(path string, o *readOpts, j *job, buf []byte) (Digest, error) {
+	o.reset()
+	if err := h.waitFile(j); err != nil {
+		return nil, err
+	}
+	if h.UseMmap && h.OpenFunc == nil && h.FS == nil && !h.NormalizeEOL && h.ByteLimiter == nil {
+		if sum, ok, err := h.sumMapped(path, o, j); ok {
+			return sum, err
+		}
+	}
+
+	rc, err := h.open(path)
+	if err != nil {
+		return nil, pathError("open", path, err)
+	}
+	defer rc.Close()
+
+	d := h.newHash()
+
+	var src io.Reader = rc
+	var dst io.Writer = d
+	if j.profile {
+		src = &timedReader{rc, &j.readTime}
+		dst = &timedWriter{d, &j.hashTime}
+	}
+	src = h.pace(src, j)
+	dst = o.tee(dst)
+	if h.SequentialMode {
+		ra := newReadahead(src)
+		defer ra.stop()
+		src = ra
+	}
+
+	if len(h.ExcludeContentTypes) > 0 {
+		// The sniffed bytes stay buffered in br, to be read again
+		// when the file is hashed. A short or failed Peek only means
+		// there is less to look at; a read error shows up again when
+		// the file is copied.
+		br := bufio.NewReader(src)
+		if head, _ := br.Peek(sniffLen); h.excludedType(head) {
+			return nil, errContentSkipped
+		}
+		src = br
+	}
+	if o != nil && o.capped {
+		src = &capReader{src, o.limit, o}
+	}
+
+	if h.NormalizeEOL {
+		err = h.copyNormalized(dst, path, src)
+	} else {
+		_, err = io.CopyBuffer(dst, src, buf)
+	}
+	if err != nil {
+		return nil, pathError("read", path, err)
+	}
+
+	return d.Sum(nil), nil
+}
+
+// digest digests the file f for the run j, reading it through buf, and returns
+// its result.
+func (h *Hasher) digest(f file, j *job, buf []byte) Result {
+	if h.HashSelector != nil {
+		if newHash := h.HashSelector(f.path, f.info); newHash != nil {
+			fh := *h
+			fh.Hash, fh.HashSelector = newHash, nil
+			return fh.digest(f, j, buf)
+		}
+	}
+
+	r := Result{Path: f.path, Size: f.info.Size(), ModTime: f.info.ModTime(), Symlink: f.symlink, LinkOf: f.linkOf, Algo: hashName(h.Hash), seq: f.seq}
+	if e := h.expander(f); e != nil {
+		r.members, r.Err = h.expand(e, f, j, buf)
+		return r
+	}
+	if f.cached && h.ExtraHashes == nil {
+		r.Sum = f.sum
+		return r
+	}
+	if f.special {
+		r.Special = true
+		r.Sum, r.Err = h.archiveSum(f.path, f.info)
+		return r
+	}
+	if h.MetadataDigest {
+		r.Sum = h.metadataSum(f.info)
+		return r
+	}
+	if h.StructureOnly {
+		r.Sum = h.structureSum(f.info)
+		return r
+	}
+	if f.symlink && h.SymlinkMode == SymlinkHashTarget {
+		r.Sum, r.Err = h.sumLink(f.path)
+		return r
+	}
+	var id fileID
+	linked := false
+	if j.links != nil && h.ExtraHashes == nil {
+		id, linked = hardLinked(f.info)
+	}
+	if linked {
+		if sum, ok := j.links.get(id); ok {
+			r.Sum = sum
+			return r
+		}
+	}
+	o := h.readOpts(f)
+	if o != nil {
+		r.data = o.keep
+	}
+	r.Sum, r.Err = h.sum(f.path, o, j, buf)
+	if isLocked(r.Err) {
+		r.Sum, r.locked, r.Err = h.retryLocked(f.path, o, j, buf, r.Err)
+		if r.locked {
+			return r
+		}
+	}
+	if r.Err == errContentSkipped {
+		r.Err = nil
+		r.skipped = true
+		return r
+	}
+	if r.Err == nil && linked {
+		j.links.put(id, r.Sum)
+	}
+	if r.Err == nil && o != nil {
+		r.Truncated = o.grown
+		r.Chunks = o.chunks.blocks()
+		r.Sums = o.sums(r.Algo, r.Sum)
+	}
+
+	if r.Err == nil && h.DetectModified {
+		r.Modified = h.modified(f)
+	}
+	if r.Err == nil && h.VerifyReadsSample > 0 && rand.Float64() < h.VerifyReadsSample {
+		var ro *readOpts
+		if o != nil && o.capped {
+			ro = &readOpts{capped: true, limit: f.info.Size()}
+		}
+		sum, err := h.sum(f.path, ro, j, buf)
+		r.reverified = true
+		r.unstable = err != nil || !bytes.Equal(sum, r.Sum)
+	}
+
+	return r
+}
+
+// modified reports whether the file f no longer has the size and modification
+// time the walk saw, or can no longer be stat'ed.
+func (h *Hasher) modified(f file) bool {
+	var info os.FileInfo
+	var err error
+	if h.FS != nil {
+		info, err = fs.Stat(h.FS, f.path)
+	} else {
+		info, err = os.Stat(f.path)
+	}
+	if err != nil {
+		return true
+	}
+	return info.Size() != f.info.Size() || !info.ModTime().Equal(f.info.ModTime())
+}
+
+// timedDigest is like digest, but also times the digest, counts it in j, logs
+// it to h.RecordOrder and reports it to h.OnFileStart and h.OnFileDone.
+func (h *Hasher) timedDigest(f file, j *job, buf []byte) Result {
+	if j.order != nil {
+		j.order.record(f.path)
+	}
+	if h.OnFileStart != nil {
+		h.OnFileStart(f.path)
+	}
+	start := time.Now()
+	r := h.digest(f, j, buf)
+	r.elapsed = time.Since(start)
+	if r.Err != nil {
+		r.Err = &ReadError{f.path, r.Err}
+	}
+	if h.Transform != nil {
+		h.transform(&r)
+	}
+	if h.OnFileDone != nil {
+		h.OnFileDone(r, r.elapsed)
+	}
+	if r.skipped || r.locked {
+		return r
+	}
+
+	atomic.AddInt64(&j.digested, 1)
+	atomic.AddInt64(&j.digestedBytes, r.Size)
+	if r.Err != nil {
+		atomic.AddInt64(&j.failed, 1)
+		atomic.AddInt64(&j.failedBytes, r.Size)
+	}
+	return r
+}
+
+// withDeadline returns a channel that is closed once done is closed or t has
+// passed, whichever comes first, a function reporting whether t passed, and a
+// function that closes the channel early and releases its resources.
+func withDeadline(done <-chan struct{}, t time.Time) (<-chan struct{}, func() bool, func()) {
+	merged := make(chan struct{})
+	quit := make(chan struct{})
+	var hit int32
+
+	timer := time.NewTimer(time.Until(t))
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			atomic.StoreInt32(&hit, 1)
+		case <-done:
+		case <-quit:
+		}
+		close(merged)
+	}()
+
+	expired := func() bool { return atomic.LoadInt32(&hit) == 1 }
+	var once sync.Once
+	stop := func() { once.Do(func() { close(quit) }) }
+	return merged, expired, stop
+}
+
+// batchSize is the number of results a digester accumulates before sending
+// them on as a batch, which saves a channel operation per file on trees with
+// many small files. It is only a variable so that BenchmarkDelivery can
+// compare it with sending each result on its own.
+var batchSize = 64
+
+// flushInterval bounds how long a digester holds on to a partial batch, so
+// results keep flowing when files are large or slow to read.
+const flushInterval = 50 * time.Millisecond
+
+// digester reads files from files and sends digests of their contents on c,
+// in batches of at most batchSize results, until either files or done is
+// closed, or j.adaptive retires it. It counts the files it digests in j, and
+// waits before reading a file while h.Pauser is paused.
+func (h *Hasher) digester(done <-chan struct{}, files <-chan file, c chan<- []Result, j *job) {
+	tick := time.NewTicker(flushInterval)
+	defer tick.Stop()
+
+	var batch []Result
+	buf := h.copyBuffer()
+	defer putCopyBuffer(buf)
+
+	// send sends the pending batch on c. It reports false if done was
+	// closed first.
+	send := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		select {
+		case c <- batch:
+			batch = nil
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	a := j.adaptive
+	for {
+		select {
+		case f, ok := <-files:
+			if !ok {
+				send()
+				if a != nil {
+					a.drain()
+				}
+				return
+			}
+			if h.Pauser.Paused() {
+				// Hand over what we have before waiting.
+				if !send() || !h.Pauser.wait(done) {
+					return
+				}
+			}
+			if t := j.throttle; t != nil && !t.tryAcquire() {
+				// Likewise while the load keeps this digester idle.
+				if !send() || !t.acquire(done) {
+					return
+				}
+			}
+			if j.tokens != nil {
+				// And while the budget shared with the rest of the
+				// program is spent.
+				if !send() || j.tokens.Acquire(j.ctx) != nil {
+					return
+				}
+			}
+			r := h.timedDigest(f, j, buf)
+			if j.throttle != nil {
+				j.throttle.release()
+			}
+			if j.tokens != nil {
+				j.tokens.Release()
+			}
+			if j.devices != nil {
+				j.devices.finish(f.dev, done)
+			}
+			if a != nil {
+				a.observe(r.elapsed)
+			}
+
+			batch = append(batch, r)
+			if len(batch) == batchSize && !send() {
+				return
+			}
+			if a != nil && a.retire() {
+				send()
+				return
+			}
+		case <-tick.C:
+			if !send() {
+				return
+			}
+			if a != nil && a.retire() {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// digestAll starts the walk of the tree at root and h.Workers digesters
+// reading from it, as many of them at once as h.LoadAware allows, or a varying
+// number of them with h.AdaptiveWorkers, or a single one with
+// h.SequentialMode or h.ReplayOrder, or hands the files to h.Pool. Batches of
+// digests are sent on the result channel, which is closed once every file sent
+// has been digested, and the result of the walk is sent on the error channel.
+// If done is closed, digestAll abandons its work. The walk and the digesters
+// keep j up to date.
+func (h *Hasher) digestAll(done <-chan struct{}, root string, j *job) (<-chan []Result, <-chan error) {
+	files, errc := h.walkFiles(done, root, j)
+	if h.Pool != nil && !h.serial() {
+		// Hand the files to the long-lived digesters of h.Pool.
+		return h.Pool.feed(h, done, files, j), errc
+	}
+
+	c := make(chan []Result)
+	var wg sync.WaitGroup
+
+	if h.AdaptiveWorkers && !h.serial() {
+		// Let a pool vary the number of goroutines digesting files.
+		h.startAdaptive(done, files, c, j, &wg)
+	} else {
+		// Start a fixed number of goroutines to read and digest files.
+		n := h.workers()
+		if h.serial() {
+			n = 1
+		} else {
+			if h.LoadAware {
+				j.throttle = startLoadThrottle(done, n)
+			}
+			if h.PerDeviceWorkers > 0 {
+				files = h.scheduleDevices(done, files, j)
+			}
+		}
+		wg.Add(n)
+
+		for i := 0; i < n; i++ {
+			go func() {
+				h.digester(done, files, c, j)
+				wg.Done()
+			}()
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		if j.throttle != nil {
+			j.throttle.stop()
+		}
+		close(c)
+	}()
+
+	return c, errc
+}
+
+// collect digests the tree rooted at root and calls fn with each result, in
+// the order they complete, including results for files that could not be read.
+// Paths are transformed by h.KeyFunc before fn sees them. It returns the first
+// error returned by fn, the result of the walk, the first error writing a
+// checkpoint, or the first error writing to h.RecordOrder, in that order of
+// precedence, and fills in h.Stats on the way out. The caller must close done
+// once collect returns.
+//
+// The precedence does not depend on timing: every file the walk sends is
+// digested before the result channel is closed, even if the walk fails, so fn
+// sees every read error before the walk error is looked at.
+func (h *Hasher) collect(done <-chan struct{}, root string, fn func(r Result) error) error {
+	if h.OrderByDirectory {
+		return h.collectDirs(done, root, func(d DirResult) error {
+			for _, r := range d.Files {
+				if err := fn(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return h.collectJob(done, root, h.newJob(), fn)
+}
+
+// collectJob is like collect, but runs as j, and calls fn in the order of the
+// walk if j is ordered. If h.Deadline passes, the run is abandoned as if done
+// had been closed, and collectJob returns ErrDeadlineExceeded.
+func (h *Hasher) collectJob(done <-chan struct{}, root string, j *job, fn func(r Result) error) error {
+	var expired func() bool
+	if !h.Deadline.IsZero() {
+		var stop func()
+		done, expired, stop = withDeadline(done, h.Deadline)
+		defer stop()
+	}
+
+	abs := newAbsolutizer(root)
+
+	var st Stats
+	slow := slowHeap{n: h.TopSlowest}
+	var keys *collisions
+	if h.KeyFunc != nil && h.Stats != nil {
+		keys = newCollisions()
+	}
+	if h.Stats != nil {
+		defer func() {
+			if j.profile {
+				st.setProfile(j)
+			}
+			st.Slowest = slow.sorted()
+			if keys != nil {
+				st.Collisions = keys.sorted()
+			}
+			st.InvalidNames = j.noted(&j.invalid)
+			st.OutsideRoot = j.noted(&j.outside)
+			st.SymlinkLoops = j.noted(&j.loops)
+			st.SkippedSpecial = j.noted(&j.special)
+			*h.Stats = st
+		}()
+	}
+
+	// delivered and deliveredBytes count the results fn accepted, for
+	// h.OnProgress.
+	var delivered int
+	var deliveredBytes int64
+	last := time.Now()
+	if h.OnProgress != nil {
+		defer func() { h.OnProgress(delivered, deliveredBytes) }()
+	}
+	deliver := func(r Result) error {
+		if err := fn(r); err != nil {
+			return err
+		}
+		delivered++
+		deliveredBytes += r.Size
+		if h.OnProgress != nil && time.Since(last) >= progressInterval {
+			last = time.Now()
+			h.OnProgress(delivered, deliveredBytes)
+		}
+		return nil
+	}
+
+	if h.ProgressFunc != nil {
+		if h.CountFirst && j.paths == nil && h.ReplayOrder == nil {
+			n, size, err := h.countFiles(done, root)
+			if err != nil {
+				return err
+			}
+			j.total, j.totalBytes = n, size
+		}
+		defer h.reportProgress(j)()
+	}
+
+	if h.Tokens != nil || h.ByteLimiter != nil || h.FileLimiter != nil {
+		ctx, cancel := doneContext(done)
+		defer cancel()
+		j.tokens, j.ctx = h.Tokens, ctx
+	}
+
+	cp := j.cp
+	c, errc := h.digestAll(done, root, j)
+
+	var order *reorderer
+	if j.ordered {
+		order = newReorderer()
+	}
+
+	for batch := range c {
+		if order != nil {
+			batch = order.add(batch)
+		}
+		for _, r := range batch {
+			if cp != nil {
+				cp.record(r)
+			}
+			if r.skipped {
+				j.dirs.drop(r.seq)
+				continue
+			}
+			r.AbsPath = r.Path
+			if h.FS == nil {
+				r.AbsPath = abs.abs(r.Path)
+			}
+			path := r.Path
+			r.Path = h.key(path)
+			if r.LinkOf != "" {
+				r.LinkOf = h.key(r.LinkOf)
+			}
+			if h.BindPath && r.Err == nil {
+				r.BoundSum = h.boundSum(r.Path, r.Sum)
+			}
+			if keys != nil {
+				keys.add(r.Path, path)
+			}
+			st.add(r)
+			if r.locked {
+				j.dirs.drop(r.seq)
+				continue
+			}
+			if slow.n > 0 && r.Err == nil {
+				slow.add(SlowFile{r.Path, r.elapsed})
+			}
+			if r.members != nil {
+				if len(r.members) == 0 {
+					j.dirs.drop(r.seq)
+				}
+				for i, m := range r.members {
+					m.AbsPath = r.AbsPath + m.Path[len(path):]
+					m.Path = h.key(m.Path)
+					if h.BindPath {
+						m.BoundSum = h.boundSum(m.Path, m.Sum)
+					}
+					m.more = i < len(r.members)-1
+					if err := deliver(m); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if h.Cache != nil && r.Err == nil && !r.Modified && !r.Truncated {
+				h.Cache.record(r.State())
+			}
+			if err := deliver(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Only now that every result has been seen is the walk error
+	// considered, so that it never hides a read error.
+	err := <-errc
+	if err == nil {
+		// The walk may have completed before done was closed, with
+		// the digesters abandoning the files left.
+		select {
+		case <-done:
+			err = ErrWalkCanceled
+		default:
+		}
+	}
+	if expired != nil && expired() && errors.Is(err, ErrWalkCanceled) {
+		err = ErrDeadlineExceeded
+	}
+	if err != nil {
+		return err
+	}
+	if cp != nil {
+		if err := cp.error(); err != nil {
+			return err
+		}
+	}
+	if j.order != nil {
+		return j.order.error()
+	}
+	return nil
+}
+
+// MD5All reads all the files in the file tree rooted at root and returns a map
+// from file path to the MD5 sum of the file's contents. If the directory walk
+// fails or any read operation fails, MD5All returns an error. In that case,
+// MD5All does not wait for inflight read operations to complete. If both
+// happen, the error reading a file is returned, rather than the error of the
+// walk.
+//
+// MD5All uses a zero Hasher; see Hasher.MD5All.
+func MD5All(root string) (ResultSet, error) {
+	return new(Hasher).MD5All(root)
+}
+
+// MD5All reads all the files in the file tree rooted at root and returns a map
+// from file path to the MD5 sum of the file's contents, as read through h. If
+// the directory walk fails or any read operation fails, MD5All returns an
+// error, preferring a read error to a walk error. In that case, MD5All does
+// not wait for inflight read operations to complete. If h.Deadline passes,
+// MD5All returns the sums computed so far along with ErrDeadlineExceeded, and
+// if h.ErrorPolicy is KeepGoing, the sums of the files that could be read
+// along with ReadErrors. MD5All always computes MD5 sums, whatever h.Hash and
+// h.HashSelector are.
+func (h *Hasher) MD5All(root string) (ResultSet, error) {
+	// MD5All closes the done channel when it returns; it may do so before
+	// receiving all the values from the pipeline.
+	done := make(chan struct{})
+	defer close(done)
+
+	return h.md5All(done, root, h.newJob())
+}
+
+// md5All is like MD5All, but runs as j, and abandons its work if done is
+// closed. The caller must close done once md5All returns.
+func (h *Hasher) md5All(done <-chan struct{}, root string, j *job) (ResultSet, error) {
+	md5h := h.md5Hasher()
+
+	m := make(ResultSet)
+	var errs ReadErrors
+	err := md5h.collectJob(done, root, j, func(r Result) error {
+		if r.Err != nil {
+			return h.readFailed(&errs, r)
+		}
+		sum, err := r.Sum.md5Sum()
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.Path, err)
+		}
+		m[r.Path] = sum
+		return nil
+	})
+	if errors.Is(err, ErrDeadlineExceeded) {
+		return m, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m, errs.err()
+}
+
+// A ResultSet maps the paths of the files of a tree to their MD5 sums, as
+// returned by MD5All.
+type ResultSet map[string][md5.Size]byte
+
+// Sorted returns the files of s as Results sorted by path, the order in which
+// the package lists files everywhere. Only Path and Sum are set.
+func (s ResultSet) Sorted() []Result {
+	results := make([]Result, 0, len(s))
+	for path, sum := range s {
+		results = append(results, Result{Path: path, Sum: append(Digest(nil), sum[:]...)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results
+}
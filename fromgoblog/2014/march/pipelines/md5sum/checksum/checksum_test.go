@@ -0,0 +1,232 @@
+package checksum
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jo12bar/gosandbox/fromgoblog/2014/march/pipelines/md5sum/checksum/faultfs"
+)
+
+// writeTree creates the files of tree, by slash-separated path, below a new
+// temporary directory, and returns that directory.
+func writeTree(t testing.TB, tree map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range tree {
+		name := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// md5Of returns the MD5 sum of s.
+func md5Of(s string) [md5.Size]byte {
+	return md5.Sum([]byte(s))
+}
+
+// relTo returns the path of name below root, slash-separated, as the Results
+// of a walk of root do not have it.
+func relTo(t testing.TB, root, name string) string {
+	t.Helper()
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// A hookFS is a MapFS whose directories are listed through readDir, if set,
+// which can fail a listing, or act on it, before the MapFS lists it.
+type hookFS struct {
+	fstest.MapFS
+	readDir func(name string) error
+}
+
+func (f hookFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if f.readDir != nil {
+		if err := f.readDir(name); err != nil {
+			return nil, err
+		}
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+// mapFile returns a MapFile holding content.
+func mapFile(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content), Mode: 0644}
+}
+
+func TestMD5All(t *testing.T) {
+	tree := map[string]string{
+		"a.txt":     "hello",
+		"sub/b.txt": "world",
+		"sub/c":     "",
+	}
+	root := writeTree(t, tree)
+
+	m, err := MD5All(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != len(tree) {
+		t.Fatalf("MD5All returned %d sums, want %d", len(m), len(tree))
+	}
+	for path, sum := range m {
+		rel := relTo(t, root, path)
+		if want := md5Of(tree[rel]); sum != want {
+			t.Errorf("sum of %s = %x, want %x", rel, sum, want)
+		}
+	}
+}
+
+// TestReadErrorPrecedence checks that a run failing both to read a file and to
+// walk the tree reports the read error, whichever comes first.
+func TestReadErrorPrecedence(t *testing.T) {
+	readErr := errors.New("read failed")
+	walkErr := errors.New("listing failed")
+
+	for _, delay := range []time.Duration{0, 20 * time.Millisecond} {
+		t.Run(fmt.Sprint("delay=", delay), func(t *testing.T) {
+			// The read fails once the listing of sub has, and
+			// delay later, leaving the walk time to report it.
+			listed := make(chan struct{})
+			h := &Hasher{
+				FS: hookFS{
+					MapFS: fstest.MapFS{"a.txt": mapFile("a"), "sub/b.txt": mapFile("b")},
+					readDir: func(name string) error {
+						if name == "sub" {
+							close(listed)
+							return walkErr
+						}
+						return nil
+					},
+				},
+				OpenFunc: func(path string) (io.ReadCloser, error) {
+					<-listed
+					time.Sleep(delay)
+					return nil, readErr
+				},
+			}
+
+			_, err := h.MD5All(".")
+			if !errors.Is(err, readErr) || errors.Is(err, walkErr) {
+				t.Errorf("MD5All = %v, want the read error", err)
+			}
+		})
+	}
+}
+
+// errAfterReader reads r, but fails with err once n bytes have been read, as
+// the reader an OpenFunc returns might, without naming the file.
+type errAfterReader struct {
+	r   io.ReadCloser
+	n   int64
+	err error
+}
+
+func (e *errAfterReader) Read(p []byte) (int, error) {
+	if e.n <= 0 {
+		return 0, e.err
+	}
+	if int64(len(p)) > e.n {
+		p = p[:e.n]
+	}
+	n, err := e.r.Read(p)
+	e.n -= int64(n)
+	return n, err
+}
+
+func (e *errAfterReader) Close() error { return e.r.Close() }
+
+// TestReadFailsPartWay checks that a file whose read fails part way through is
+// reported with the error and its path, and no digest of the part read.
+func TestReadFailsPartWay(t *testing.T) {
+	content := strings.Repeat("0123456789", 10000)
+	root := writeTree(t, map[string]string{"big.bin": content})
+	path := filepath.Join(root, "big.bin")
+	readErr := errors.New("device lost")
+
+	tests := []struct {
+		name string
+		open func(path string) (io.ReadCloser, error)
+	}{
+		{"faultfs after 0", faultOpen(path, faultfs.Fault{ReadErr: readErr})},
+		{"faultfs after 100", faultOpen(path, faultfs.Fault{ReadErr: readErr, After: 100})},
+		{"faultfs after 99999", faultOpen(path, faultfs.Fault{ReadErr: readErr, After: int64(len(content)) - 1})},
+		{"faultfs short reads", faultOpen(path, faultfs.Fault{ReadErr: readErr, After: 5000, MaxRead: 7})},
+		{"plain error after 4096", func(path string) (io.ReadCloser, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			return &errAfterReader{f, 4096, readErr}, nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Hasher{OpenFunc: tt.open}
+			results, errc := h.Stream(context.Background(), root)
+			var got []Result
+			for r := range results {
+				got = append(got, r)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d results, want 1", len(got))
+			}
+
+			r := got[0]
+			var pe *os.PathError
+			if !errors.As(r.Err, &pe) || pe.Path != path || !errors.Is(r.Err, readErr) {
+				t.Errorf("Err = %#v, want an *os.PathError for %s wrapping %v", r.Err, path, readErr)
+			}
+			if r.Sum != nil {
+				t.Errorf("Sum = %x, want nil", r.Sum)
+			}
+		})
+	}
+}
+
+// TestShortReads checks that a file read a few bytes at a time is digested
+// whole.
+func TestShortReads(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	root := writeTree(t, map[string]string{"a.bin": content})
+	path := filepath.Join(root, "a.bin")
+
+	for _, f := range []faultfs.Fault{{MaxRead: 1}, {MaxRead: 7}} {
+		h := &Hasher{OpenFunc: faultOpen(path, f)}
+		m, err := h.MD5All(root)
+		if err != nil {
+			t.Fatalf("%+v: %v", f, err)
+		}
+		if want := md5Of(content); m[path] != want {
+			t.Errorf("%+v: sum %x, want %x", f, m[path], want)
+		}
+	}
+}
+
+// faultOpen returns the Open method of a faultfs.FS failing path with f.
+func faultOpen(path string, f faultfs.Fault) func(string) (io.ReadCloser, error) {
+	fsys := faultfs.New()
+	fsys.Set(path, f)
+	return fsys.Open
+}
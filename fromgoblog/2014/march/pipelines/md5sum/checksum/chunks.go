@@ -0,0 +1,265 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// chunker is an io.Writer digesting the bytes written to it in consecutive
+// chunks of size bytes, for Hasher.ChunkSize, as BlockHashes does for a file.
+type chunker struct {
+	size    int
+	newHash func() hash.Hash
+
+	strong hash.Hash
+	weak   hash.Hash32
+	off    int64
+	n      int
+	done   []BlockHash
+}
+
+// newChunker returns a chunker of chunks of size bytes, digested with newHash.
+func newChunker(size int, newHash func() hash.Hash) *chunker {
+	return &chunker{size: size, newHash: newHash, strong: newHash(), weak: adler32.New()}
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := c.size - c.n
+		if take > len(p) {
+			take = len(p)
+		}
+		c.strong.Write(p[:take])
+		c.weak.Write(p[:take])
+		c.n += take
+		p = p[take:]
+		if c.n == c.size {
+			c.flush()
+		}
+	}
+	return n, nil
+}
+
+// flush ends the current chunk, if it holds any byte.
+func (c *chunker) flush() {
+	if c.n == 0 {
+		return
+	}
+	c.done = append(c.done, BlockHash{c.off, c.n, c.weak.Sum32(), c.strong.Sum(nil)})
+	c.off += int64(c.n)
+	c.n = 0
+	c.strong, c.weak = c.newHash(), adler32.New()
+}
+
+// reset discards the chunks digested so far.
+func (c *chunker) reset() {
+	if c == nil {
+		return
+	}
+	*c = *newChunker(c.size, c.newHash)
+}
+
+// blocks ends the last chunk and returns the chunks digested, or nil if c is
+// nil.
+func (c *chunker) blocks() []BlockHash {
+	if c == nil {
+		return nil
+	}
+	c.flush()
+	return c.done
+}
+
+// A ChunkManifest maps the paths of large files, as produced by walking a
+// tree, to the digests of their consecutive chunks, as found in Result.Chunks
+// with Hasher.ChunkSize set. Kept as a sidecar to a manifest of whole-file
+// digests, it lets VerifyChunks tell which bytes of a corrupt file changed.
+type ChunkManifest map[string][]BlockHash
+
+// chunkManifestHeader is the first line of a chunk manifest.
+const chunkManifestHeader = "# gosandbox-chunks v1"
+
+// WriteChunkManifest writes m to w as text: a header line, then a line per
+// chunk, sorted by path, then offset, holding its offset and size in decimal,
+// its Adler-32 and strong digests in hexadecimal, and the path, separated by
+// single spaces, such as
+//
+//	0 1048576 1d2b0f35 0cc175b9c0f1b6a831c399e269772661 media/film.mkv
+func WriteChunkManifest(w io.Writer, m ChunkManifest) error {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, chunkManifestHeader)
+	for _, path := range paths {
+		for _, b := range m[path] {
+			fmt.Fprintf(bw, "%d %d %08x %x %s\n", b.Offset, b.Size, b.Weak, []byte(b.Strong), path)
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadChunkManifest parses a chunk manifest in the format written by
+// WriteChunkManifest. Malformed lines are reported as a *ParseError.
+func ReadChunkManifest(r io.Reader) (ChunkManifest, error) {
+	m := make(ChunkManifest)
+
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		line := strings.TrimSuffix(s.Text(), "\r")
+		if n == 1 {
+			if line != chunkManifestHeader {
+				return nil, &ParseError{n, errors.New("not a chunk manifest")}
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		path, b, err := parseChunkLine(line)
+		if err != nil {
+			return nil, &ParseError{n, err}
+		}
+		m[path] = append(m[path], b)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseChunkLine parses a line of a chunk manifest.
+func parseChunkLine(line string) (string, BlockHash, error) {
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) != 5 || fields[4] == "" {
+		return "", BlockHash{}, errors.New("expected offset, size, weak and strong digests, and path")
+	}
+	off, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || off < 0 {
+		return "", BlockHash{}, fmt.Errorf("invalid offset %q", fields[0])
+	}
+	size, err := strconv.Atoi(fields[1])
+	if err != nil || size <= 0 {
+		return "", BlockHash{}, fmt.Errorf("invalid size %q", fields[1])
+	}
+	weak, err := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil {
+		return "", BlockHash{}, fmt.Errorf("invalid weak digest %q", fields[2])
+	}
+	strong, err := hex.DecodeString(fields[3])
+	if err != nil || len(strong) == 0 {
+		return "", BlockHash{}, fmt.Errorf("invalid digest %q", fields[3])
+	}
+	return fields[4], BlockHash{off, size, uint32(weak), strong}, nil
+}
+
+// A ByteRange is a range of Size bytes of a file starting at Offset.
+type ByteRange struct {
+	Offset int64
+	Size   int64
+}
+
+// String returns the range as "bytes X-Y", the offsets of its first and last
+// bytes.
+func (r ByteRange) String() string {
+	return fmt.Sprintf("bytes %d-%d", r.Offset, r.Offset+r.Size-1)
+}
+
+// A ChunkMismatch reports a file of a ChunkManifest that no longer matches it:
+// the ranges of bytes that changed, merged where they touch, or Missing if the
+// file is gone. A file that shrank has its lost bytes among its ranges, and
+// one that grew its new bytes.
+type ChunkMismatch struct {
+	Path    string
+	Ranges  []ByteRange
+	Missing bool
+}
+
+// VerifyChunks reads each file of m again, in chunks of the size it was
+// recorded with, and returns the files whose chunks differ, sorted by path,
+// giving the ranges of bytes that changed rather than a mere mismatch. The
+// paths of m must name the files, as they do unless Hasher.KeyFunc changed
+// them. If a file other than a missing one cannot be read, VerifyChunks
+// returns an error.
+//
+// VerifyChunks uses a zero Hasher; see Hasher.VerifyChunks.
+func VerifyChunks(m ChunkManifest) ([]ChunkMismatch, error) {
+	return new(Hasher).VerifyChunks(m)
+}
+
+// VerifyChunks is like the package-level VerifyChunks, but reads the files
+// through h and computes the strong digests with h.Hash, which must be the
+// hash the chunk manifest was made with.
+func (h *Hasher) VerifyChunks(m ChunkManifest) ([]ChunkMismatch, error) {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var mismatches []ChunkMismatch
+	for _, path := range paths {
+		want := m[path]
+		if len(want) == 0 {
+			continue
+		}
+		got, err := h.BlockHashes(path, want[0].Size)
+		if errors.Is(err, os.ErrNotExist) {
+			mismatches = append(mismatches, ChunkMismatch{Path: path, Missing: true})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ranges := changedRanges(want, got); ranges != nil {
+			mismatches = append(mismatches, ChunkMismatch{Path: path, Ranges: ranges})
+		}
+	}
+	return mismatches, nil
+}
+
+// changedRanges returns the ranges of bytes covered by the chunks that differ
+// between want and got, chunked alike, merged where they touch.
+func changedRanges(want, got []BlockHash) []ByteRange {
+	var ranges []ByteRange
+	add := func(b BlockHash) {
+		if n := len(ranges); n > 0 && ranges[n-1].Offset+ranges[n-1].Size >= b.Offset {
+			if end := b.Offset + int64(b.Size); end > ranges[n-1].Offset+ranges[n-1].Size {
+				ranges[n-1].Size = end - ranges[n-1].Offset
+			}
+			return
+		}
+		ranges = append(ranges, ByteRange{b.Offset, int64(b.Size)})
+	}
+
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			add(want[i])
+		case i >= len(want):
+			add(got[i])
+		case want[i].Offset != got[i].Offset || want[i].Size != got[i].Size || !bytes.Equal(want[i].Strong, got[i].Strong):
+			if want[i].Size > got[i].Size {
+				add(want[i])
+			} else {
+				add(got[i])
+			}
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,119 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// CompareTrees reads all the files in the file trees rooted at a and b, both
+// at once, and compares them by their paths relative to each root, as a
+// check of a backup or of a copy made with rsync. It returns an entry for each
+// file only in a, DiffRemoved, only in b, DiffAdded, or in both but with
+// different contents, DiffChanged, sorted by path. The paths of the entries
+// are relative to the roots, with forward slashes. If either directory walk
+// fails or any read operation fails, CompareTrees returns an error. If ctx is
+// canceled, it returns ctx.Err().
+//
+// CompareTrees uses a zero Hasher; see Hasher.CompareTrees.
+func CompareTrees(ctx context.Context, a, b string) ([]DiffEntry, error) {
+	return new(Hasher).CompareTrees(ctx, a, b)
+}
+
+// CompareTrees is like the package-level CompareTrees, but reads the files of
+// both trees through h, digests them with h.Hash and compares the digests with
+// h.CompareFunc. If h.ErrorPolicy is KeepGoing, a file that could not be read
+// in either tree is left out of the comparison, and CompareTrees returns the
+// entries of the others along with ReadErrors. Since the trees are read at
+// the same time, h.KeyFunc, h.Stats, h.OnProgress, h.RecordOrder and
+// h.ReplayOrder are not used.
+func (h *Hasher) CompareTrees(ctx context.Context, a, b string) ([]DiffEntry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	th := *h
+	th.KeyFunc, th.Stats, th.OnProgress, th.RecordOrder, th.ReplayOrder = nil, nil, nil, nil, nil
+
+	// A side holds the digests of the files of a tree, by relative path,
+	// and the paths of those that could not be read.
+	type side struct {
+		sums   map[string]Digest
+		failed map[string]bool
+		errs   ReadErrors
+		err    error
+	}
+	sides := [2]side{}
+	var wg sync.WaitGroup
+	for i, root := range []string{a, b} {
+		wg.Add(1)
+		go func(s *side, root string) {
+			defer wg.Done()
+			s.sums, s.failed, s.errs, s.err = th.relSums(ctx.Done(), root)
+			if s.err != nil {
+				// The other tree need not be read any further.
+				cancel()
+			}
+		}(&sides[i], root)
+	}
+	wg.Wait()
+
+	// A genuine error of either tree wins over the cancellation it caused.
+	for _, s := range sides {
+		if s.err != nil && !errors.Is(s.err, ErrWalkCanceled) {
+			return nil, s.err
+		}
+	}
+	for _, s := range sides {
+		if s.err != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	sa, sb := sides[0], sides[1]
+	var entries []DiffEntry
+	for path, sum := range sa.sums {
+		if sb.failed[path] {
+			continue
+		}
+		if other, ok := sb.sums[path]; !ok {
+			entries = append(entries, DiffEntry{Path: path, Kind: DiffRemoved, Old: sum})
+		} else if !h.match(sum, other) {
+			entries = append(entries, DiffEntry{Path: path, Kind: DiffChanged, Old: sum, New: other})
+		}
+	}
+	for path, sum := range sb.sums {
+		if _, ok := sa.sums[path]; !ok && !sa.failed[path] {
+			entries = append(entries, DiffEntry{Path: path, Kind: DiffAdded, New: sum})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	errs := append(sa.errs, sb.errs...)
+	return entries, errs.err()
+}
+
+// relSums reads all the files in the file tree rooted at root through h and
+// returns their digests by path, relative to root and with forward slashes,
+// along with the paths of the files that could not be read and their errors,
+// as h.ErrorPolicy allows.
+func (h *Hasher) relSums(done <-chan struct{}, root string) (map[string]Digest, map[string]bool, ReadErrors, error) {
+	sums := make(map[string]Digest)
+	failed := make(map[string]bool)
+	var errs ReadErrors
+	err := h.collect(done, root, func(r Result) error {
+		rel, err := filepath.Rel(root, r.Path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if r.Err != nil {
+			failed[rel] = true
+			return h.readFailed(&errs, r)
+		}
+		sums[rel] = r.Sum
+		return nil
+	})
+	return sums, failed, errs, err
+}
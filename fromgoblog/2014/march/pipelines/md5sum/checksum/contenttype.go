@@ -0,0 +1,38 @@
+package checksum
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errContentSkipped is the error sum reports for a file whose content type is
+// in Hasher.ExcludeContentTypes. It never leaves the digesters.
+var errContentSkipped = errors.New("checksum: content type excluded")
+
+// excludedType reports whether the content type sniffed from head, the first
+// bytes of a file, is one of h.ExcludeContentTypes.
+func (h *Hasher) excludedType(head []byte) bool {
+	return matchType(h.ExcludeContentTypes, http.DetectContentType(head))
+}
+
+// matchType reports whether the content type ct, as returned by
+// http.DetectContentType, matches any of types, which are media types such as
+// "image/png" or wildcards such as "image/*".
+func matchType(types []string, ct string) bool {
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	ct = strings.TrimSpace(ct)
+
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == ct {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(ct, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package checksum
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// coreutilsEscaper escapes the characters GNU coreutils escapes in file
+// names, so that every name fits on one line.
+var coreutilsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// coreutilsUnescaper reverses coreutilsEscaper.
+var coreutilsUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r")
+
+// WriteCoreutils writes m to w in the format printed by GNU coreutils md5sum,
+// sorted by path, so that it can be checked with "md5sum -c". Each line holds
+// the hex MD5 sum, a space, the text mode marker (a second space) and the
+// path. As md5sum does, paths containing a backslash, newline or carriage
+// return are escaped and their line is prefixed with a backslash.
+func WriteCoreutils(w io.Writer, m map[string][md5.Size]byte) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range ResultSet(m).Sorted() {
+		writeCoreutilsLine(bw, r.Path, r.Sum[:], ' ')
+	}
+
+	return bw.Flush()
+}
+
+// WriteCoreutilsDigests is like WriteCoreutils, but writes digests of any
+// hash, so that a manifest of SHA-256 digests can be checked with
+// "sha256sum -c", or one of SHA-1 digests with "sha1sum -c". If binary is
+// true, each line has the binary mode marker ("*") rather than the text mode
+// one, as printed by "sha256sum -b" and by default on Windows; both check the
+// same on Unix.
+func WriteCoreutilsDigests(w io.Writer, m map[string]Digest, binary bool) error {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	marker := byte(' ')
+	if binary {
+		marker = '*'
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, path := range paths {
+		writeCoreutilsLine(bw, path, m[path], marker)
+	}
+
+	return bw.Flush()
+}
+
+// writeCoreutilsLine writes the line of a coreutils checksum file for the
+// file at path, with the given mode marker.
+func writeCoreutilsLine(bw *bufio.Writer, path string, sum []byte, marker byte) {
+	escaped := coreutilsEscaper.Replace(path)
+	if escaped != path {
+		bw.WriteByte('\\')
+	}
+	fmt.Fprintf(bw, "%x %c%s\n", sum, marker, escaped)
+}
+
+// ParseCoreutils reads a checksum file in the format printed by GNU coreutils
+// md5sum and returns a map from path to MD5 sum. Both the text mode marker
+// (" ") and the binary mode marker ("*") are accepted, and escaped lines are
+// unescaped, so ParseCoreutils reads back exactly what WriteCoreutils wrote.
+// Blank lines are skipped; any other malformed line is an error.
+func ParseCoreutils(r io.Reader) (map[string][md5.Size]byte, error) {
+	m := make(map[string][md5.Size]byte)
+
+	err := parseCoreutils(r, func(path string, sum Digest) error {
+		md5Sum, err := sum.md5Sum()
+		if err != nil {
+			return err
+		}
+		m[path] = md5Sum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ParseCoreutilsDigests is like ParseCoreutils, but reads digests of any hash,
+// such as a file printed by sha256sum, and reads back exactly what
+// WriteCoreutilsDigests wrote. All the digests must have the same length, as
+// they do when computed with a single hash.
+func ParseCoreutilsDigests(r io.Reader) (map[string]Digest, error) {
+	m := make(map[string]Digest)
+
+	size := -1
+	err := parseCoreutils(r, func(path string, sum Digest) error {
+		if size >= 0 && len(sum) != size {
+			return fmt.Errorf("checksum of %d bytes after checksums of %d", len(sum), size)
+		}
+		size = len(sum)
+		m[path] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// parseCoreutils calls fn with the path and digest of each line of a coreutils
+// checksum file read from r. An error from fn is reported for its line.
+func parseCoreutils(r io.Reader, fn func(path string, sum Digest) error) error {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+
+		path, sum, err := parseCoreutilsLine(line)
+		if err == nil {
+			err = fn(path, sum)
+		}
+		if err != nil {
+			return &ParseError{n, err}
+		}
+	}
+	return s.Err()
+}
+
+// parseCoreutilsLine parses a single line of a coreutils checksum file.
+func parseCoreutilsLine(line string) (string, Digest, error) {
+	escaped := strings.HasPrefix(line, `\`)
+	if escaped {
+		line = line[1:]
+	}
+
+	hexLen := strings.IndexByte(line, ' ')
+	if hexLen <= 0 || len(line) < hexLen+3 || (line[hexLen+1] != ' ' && line[hexLen+1] != '*') {
+		return "", nil, fmt.Errorf("malformed checksum line %q", line)
+	}
+	sum, err := hex.DecodeString(line[:hexLen])
+	if err != nil {
+		return "", nil, err
+	}
+
+	path := line[hexLen+2:]
+	if escaped {
+		path = coreutilsUnescaper.Replace(path)
+	}
+
+	return path, sum, nil
+}
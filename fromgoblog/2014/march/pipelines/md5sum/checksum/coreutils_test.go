@@ -0,0 +1,185 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// coreutilsTree returns the files the coreutils tests write manifests of, with
+// names that need escaping where the platform allows them.
+func coreutilsTree() map[string]string {
+	tree := map[string]string{
+		"a.txt":          "a",
+		"with space.txt": "space",
+		"sub/b.txt":      "b",
+		"star*.txt":      "star",
+	}
+	if runtime.GOOS != "windows" {
+		tree[`back\slash.txt`] = "backslash"
+		tree["new\nline.txt"] = "newline"
+	}
+	return tree
+}
+
+// coreutilsCheck checks manifest against the files below root as "sha256sum -c"
+// and its kin do, independently of parseCoreutils: each line is an optional
+// backslash marking an escaped name, the hex digest, a space, a mode marker
+// and the name. It returns the names checked.
+func coreutilsCheck(t *testing.T, root, manifest string, newHash func() hash.Hash) []string {
+	t.Helper()
+	width := 2 * newHash().Size()
+	var names []string
+	s := bufio.NewScanner(strings.NewReader(manifest))
+	for s.Scan() {
+		line := s.Text()
+		escaped := strings.HasPrefix(line, `\`)
+		if escaped {
+			line = line[1:]
+		}
+		if len(line) < width+3 || line[width] != ' ' || (line[width+1] != ' ' && line[width+1] != '*') {
+			t.Fatalf("malformed line %q", s.Text())
+		}
+		name := line[width+2:]
+		if escaped {
+			var b strings.Builder
+			for i := 0; i < len(name); i++ {
+				if name[i] == '\\' && i+1 < len(name) {
+					i++
+					switch name[i] {
+					case 'n':
+						b.WriteByte('\n')
+						continue
+					case 'r':
+						b.WriteByte('\r')
+						continue
+					}
+				}
+				b.WriteByte(name[i])
+			}
+			name = b.String()
+		}
+		data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := newHash()
+		d.Write(data)
+		if got := hex.EncodeToString(d.Sum(nil)); got != line[:width] {
+			t.Errorf("%q: FAILED, digest %s, manifest %s", name, got, line[:width])
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestWriteCoreutilsDigestsRoundTrip(t *testing.T) {
+	algos := []struct {
+		tool    string
+		newHash func() hash.Hash
+	}{
+		{"md5sum", nil},
+		{"sha1sum", sha1.New},
+		{"sha256sum", sha256.New},
+	}
+	tree := coreutilsTree()
+	root := writeTree(t, tree)
+	for _, algo := range algos {
+		h := &Hasher{Hash: algo.newHash}
+		results, err := h.SumAll(context.Background(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]Digest)
+		for path, sum := range results {
+			m[relTo(t, root, path)] = sum
+		}
+		newHash := func() hash.Hash { return h.newHash() }
+
+		for _, binary := range []bool{false, true} {
+			var b bytes.Buffer
+			if err := WriteCoreutilsDigests(&b, m, binary); err != nil {
+				t.Fatal(err)
+			}
+
+			if names := coreutilsCheck(t, root, b.String(), newHash); len(names) != len(tree) {
+				t.Errorf("%s: checked %d files, want %d", algo.tool, len(names), len(tree))
+			}
+
+			back, err := ParseCoreutilsDigests(bytes.NewReader(b.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for path, sum := range m {
+				if !bytes.Equal(back[path], sum) {
+					t.Errorf("%s: %q read back as %x, want %x", algo.tool, path, back[path], sum)
+				}
+			}
+
+			tool, err := exec.LookPath(algo.tool)
+			if err != nil {
+				continue
+			}
+			cmd := exec.Command(tool, "--check", "--strict", "-")
+			cmd.Dir = root
+			cmd.Stdin = bytes.NewReader(b.Bytes())
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s -c: %v\n%s\nmanifest:\n%s", algo.tool, err, out, b.Bytes())
+			}
+		}
+	}
+}
+
+// TestBSDEncoderEscapes checks that the BSD encoder escapes names as
+// "md5sum --tag" does, so that a newline in a name does not break its line,
+// and that md5sum reads back what it wrote.
+func TestBSDEncoderEscapes(t *testing.T) {
+	var line strings.Builder
+	e := NewBSDEncoder(&line)
+	if err := e.Encode(Result{Path: "a\nb\\c", Algo: "md5", Sum: sumOf("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("\\MD5 (a\\nb\\\\c) = %x\n", sumOf("x")); line.String() != want {
+		t.Errorf("line = %q, want %q", line.String(), want)
+	}
+
+	tree := coreutilsTree()
+	root := writeTree(t, tree)
+	var b bytes.Buffer
+	e = NewBSDEncoder(&b)
+	err := new(Hasher).collect(make(chan struct{}), root, func(r Result) error {
+		r.Path = relTo(t, root, r.Path)
+		return e.Encode(r)
+	})
+	if err == nil {
+		err = e.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(b.String(), "\n"); n != len(tree) {
+		t.Errorf("wrote %d lines for %d files:\n%s", n, len(tree), b.Bytes())
+	}
+
+	tool, err := exec.LookPath("md5sum")
+	if err != nil {
+		t.Skip("no md5sum to check the lines with")
+	}
+	cmd := exec.Command(tool, "--check", "--strict", "-")
+	cmd.Dir = root
+	cmd.Stdin = bytes.NewReader(b.Bytes())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("md5sum -c: %v\n%s\nmanifest:\n%s", err, out, b.Bytes())
+	}
+}
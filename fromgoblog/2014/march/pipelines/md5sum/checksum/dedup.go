@@ -0,0 +1,431 @@
+package checksum
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// UniqueContent reads all the files in the file tree rooted at root and
+// returns a map from each distinct MD5 sum to one canonical path having that
+// content: the lexically smallest one, so that repeated runs over the same
+// tree pick the same representative. If the directory walk fails or any read
+// operation fails, UniqueContent returns an error.
+//
+// UniqueContent uses a zero Hasher; see Hasher.UniqueContent.
+func UniqueContent(root string) (map[[md5.Size]byte]string, error) {
+	return new(Hasher).UniqueContent(root)
+}
+
+// UniqueContent is like the package-level UniqueContent, but reads the files
+// through h.
+func (h *Hasher) UniqueContent(root string) (map[[md5.Size]byte]string, error) {
+	m, err := h.MD5All(root)
+	if err != nil {
+		return nil, err
+	}
+
+	u := make(map[[md5.Size]byte]string)
+	for path, sum := range m {
+		if p, ok := u[sum]; !ok || path < p {
+			u[sum] = path
+		}
+	}
+
+	return u, nil
+}
+
+// A DuplicateRank says how TopDuplicate ranks groups of duplicate files.
+type DuplicateRank int
+
+const (
+	// ByReclaimable ranks groups by the bytes that removing every copy but
+	// one would reclaim.
+	ByReclaimable DuplicateRank = iota
+
+	// ByCount ranks groups by their number of files.
+	ByCount
+)
+
+// dupGroup is a group of files with the same MD5 sum.
+type dupGroup struct {
+	sum   [md5.Size]byte
+	paths []string
+	size  int64
+}
+
+// reclaimable returns the bytes taken up by every copy in g but one.
+func (g *dupGroup) reclaimable() int64 {
+	return g.size * int64(len(g.paths)-1)
+}
+
+// TopDuplicate reads all the files in the file tree rooted at root and returns
+// the group of files with identical content that ranks highest: its MD5 sum,
+// its paths, sorted, and the bytes that removing every copy but one would
+// reclaim. Ties are broken by the other ranking, then by the smallest path. If
+// no two files have the same content, TopDuplicate returns zero values. If the
+// directory walk fails or any read operation fails, TopDuplicate returns an
+// error.
+//
+// TopDuplicate uses a zero Hasher, which ranks groups ByReclaimable; see
+// Hasher.TopDuplicate.
+func TopDuplicate(root string) (digest [md5.Size]byte, paths []string, bytes int64, err error) {
+	return new(Hasher).TopDuplicate(root)
+}
+
+// TopDuplicate is like the package-level TopDuplicate, but reads the files
+// through h and ranks groups according to h.TopDuplicateBy.
+func (h *Hasher) TopDuplicate(root string) (digest [md5.Size]byte, paths []string, bytes int64, err error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	md5h := h.md5Hasher()
+
+	groups := make(map[[md5.Size]byte]*dupGroup)
+	err = md5h.collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		sum, err := r.Sum.md5Sum()
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.Path, err)
+		}
+		g := groups[sum]
+		if g == nil {
+			g = &dupGroup{sum: sum, size: r.Size}
+			groups[sum] = g
+		}
+		g.paths = append(g.paths, r.Path)
+		return nil
+	})
+	if err != nil {
+		return digest, nil, 0, err
+	}
+
+	var top *dupGroup
+	for _, g := range groups {
+		if len(g.paths) < 2 {
+			continue
+		}
+		sort.Strings(g.paths)
+		if top == nil || h.outranks(g, top) {
+			top = g
+		}
+	}
+	if top == nil {
+		return digest, nil, 0, nil
+	}
+
+	return top.sum, top.paths, top.reclaimable(), nil
+}
+
+// outranks reports whether g ranks above other according to h.TopDuplicateBy.
+// The paths of both groups must be sorted.
+func (h *Hasher) outranks(g, other *dupGroup) bool {
+	keys := func(g *dupGroup) (int64, int64) {
+		if h.TopDuplicateBy == ByCount {
+			return int64(len(g.paths)), g.reclaimable()
+		}
+		return g.reclaimable(), int64(len(g.paths))
+	}
+
+	g1, g2 := keys(g)
+	o1, o2 := keys(other)
+	switch {
+	case g1 != o1:
+		return g1 > o1
+	case g2 != o2:
+		return g2 > o2
+	}
+	return g.paths[0] < other.paths[0]
+}
+
+// A DuplicateEvent is sent by StreamDuplicates. If Err is nil, it reports
+// that the file at NewPath has the same MD5 sum, Digest, as the file at
+// ExistingPath, the first one found with it. Otherwise the scan failed and
+// the event is the last one.
+type DuplicateEvent struct {
+	Digest       [md5.Size]byte
+	ExistingPath string
+	NewPath      string
+	Err          error
+}
+
+// StreamDuplicates reads all the files in the file tree rooted at root and
+// reports each duplicate on the returned channel as soon as it is digested,
+// rather than once the whole tree has been, so that a user interface can show
+// duplicates while a long scan goes on. Every copy of some content after the
+// first one digested, which need not be the first by path, gets an event
+// naming that first copy. Special files recorded by ArchiveMode are not
+// content and never reported. If the directory walk fails or any read
+// operation fails, the last event holds the error. The channel is closed
+// after the last event.
+//
+// The caller must either receive from the channel until it is closed or
+// cancel ctx. If ctx is canceled, StreamDuplicates abandons its work and
+// closes the channel, possibly without sending an event for ctx.Err().
+//
+// StreamDuplicates uses a zero Hasher; see Hasher.StreamDuplicates.
+func StreamDuplicates(ctx context.Context, root string) <-chan DuplicateEvent {
+	return new(Hasher).StreamDuplicates(ctx, root)
+}
+
+// StreamDuplicates is like the package-level StreamDuplicates, but reads the
+// files through h.
+func (h *Hasher) StreamDuplicates(ctx context.Context, root string) <-chan DuplicateEvent {
+	out := make(chan DuplicateEvent)
+
+	md5h := h.md5Hasher()
+
+	go func() {
+		defer close(out)
+
+		send := func(ev DuplicateEvent) error {
+			select {
+			case out <- ev:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		// first is only touched by the function collect calls, from a
+		// single goroutine.
+		first := make(map[[md5.Size]byte]string)
+		err := md5h.collect(ctx.Done(), root, func(r Result) error {
+			if r.Err != nil {
+				return r.Err
+			}
+			if r.Special {
+				return nil
+			}
+			sum, err := r.Sum.md5Sum()
+			if err != nil {
+				return fmt.Errorf("%s: %w", r.Path, err)
+			}
+			existing, ok := first[sum]
+			if !ok {
+				first[sum] = r.Path
+				return nil
+			}
+			return send(DuplicateEvent{Digest: sum, ExistingPath: existing, NewPath: r.Path})
+		})
+		if errors.Is(err, ErrWalkCanceled) || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			send(DuplicateEvent{Err: err})
+		}
+	}()
+
+	return out
+}
+
+// CommonPaths lists the paths holding some content in each of two trees.
+type CommonPaths struct {
+	A, B []string
+}
+
+// CommonContent reads all the files in the file trees rooted at a and b and
+// returns, for each MD5 sum found in both trees, the paths having that content
+// in a and in b, each sorted. Files are matched by content alone, wherever
+// they lie in either tree. If either directory walk fails or any read
+// operation fails, CommonContent returns an error.
+//
+// CommonContent uses a zero Hasher; see Hasher.CommonContent.
+func CommonContent(a, b string) (map[[md5.Size]byte]CommonPaths, error) {
+	return new(Hasher).CommonContent(a, b)
+}
+
+// CommonContent is like the package-level CommonContent, but reads the files
+// through h.
+func (h *Hasher) CommonContent(a, b string) (map[[md5.Size]byte]CommonPaths, error) {
+	ma, err := h.MD5All(a)
+	if err != nil {
+		return nil, err
+	}
+	mb, err := h.MD5All(b)
+	if err != nil {
+		return nil, err
+	}
+
+	inA := make(map[[md5.Size]byte][]string)
+	for path, sum := range ma {
+		inA[sum] = append(inA[sum], path)
+	}
+
+	common := make(map[[md5.Size]byte]CommonPaths)
+	for path, sum := range mb {
+		pa, ok := inA[sum]
+		if !ok {
+			continue
+		}
+		c := common[sum]
+		c.A = pa
+		c.B = append(c.B, path)
+		common[sum] = c
+	}
+
+	for _, c := range common {
+		sort.Strings(c.A)
+		sort.Strings(c.B)
+	}
+
+	return common, nil
+}
+
+// A DuplicateSet is a group of files with identical content, as found by
+// FindDuplicates: their digest, the size of each, and their paths, sorted.
+type DuplicateSet struct {
+	Sum   Digest   `json:"sum"`
+	Size  int64    `json:"size"`
+	Paths []string `json:"paths"`
+}
+
+// Reclaimable returns the bytes that removing every copy in s but one would
+// reclaim.
+func (s DuplicateSet) Reclaimable() int64 {
+	return s.Size * int64(len(s.Paths)-1)
+}
+
+// FindDuplicates finds the groups of files with identical content in the file
+// tree rooted at root. It first walks the tree without reading any file, to
+// learn the size of each, and then only reads the files whose size another
+// file shares, since a file of a size of its own has no duplicate. Empty
+// files, which have nothing to reclaim, and special files recorded by
+// ArchiveMode are left out. With Hasher.Expanders, the first walk also lists
+// the members of each container file, which count along with the files, and
+// the containers are always read. The groups are sorted by the bytes they waste,
+// most first, then by their number of files, then by their first path. If
+// either directory walk fails or any read operation fails, FindDuplicates
+// returns an error. If ctx is canceled, it returns ctx.Err().
+//
+// FindDuplicates uses a zero Hasher; see Hasher.FindDuplicates.
+func FindDuplicates(ctx context.Context, root string) ([]DuplicateSet, error) {
+	return new(Hasher).FindDuplicates(ctx, root)
+}
+
+// FindDuplicates is like the package-level FindDuplicates, but reads the files
+// through h, digests them with h.Hash, and orders the groups according to
+// h.TopDuplicateBy. If h.ErrorPolicy is KeepGoing, FindDuplicates returns the
+// groups among the files that could be read along with ReadErrors. Only the
+// second walk, which reads the files, updates h.Stats or calls h.OnProgress.
+func (h *Hasher) FindDuplicates(ctx context.Context, root string) ([]DuplicateSet, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sizes, err := h.fileSizes(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	dh := *h
+	dh.SkipFunc = func(path string, info os.FileInfo, state ScanState) bool {
+		container := h.expander(file{path: path, info: info, symlink: info.Mode()&os.ModeSymlink != 0}) != nil
+		if sizes[info.Size()] < 2 && !container {
+			return true
+		}
+		return h.SkipFunc != nil && h.SkipFunc(path, info, state)
+	}
+
+	// Files are grouped by size as well as digest, so that a weak hash
+	// colliding for files of different sizes does not group them.
+	type setKey struct {
+		size int64
+		sum  string
+	}
+	sets := make(map[setKey]*DuplicateSet)
+	var errs ReadErrors
+	err = dh.collect(ctx.Done(), root, func(r Result) error {
+		if r.Err != nil {
+			return h.readFailed(&errs, r)
+		}
+		// The walk does not skip the empty members of containers, as
+		// it does empty files.
+		if r.Special || r.Size == 0 {
+			return nil
+		}
+		k := setKey{r.Size, string(r.Sum)}
+		s := sets[k]
+		if s == nil {
+			s = &DuplicateSet{Sum: r.Sum, Size: r.Size}
+			sets[k] = s
+		}
+		s.Paths = append(s.Paths, r.Path)
+		return nil
+	})
+	if errors.Is(err, ErrWalkCanceled) {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var dupes []DuplicateSet
+	for _, s := range sets {
+		if len(s.Paths) > 1 {
+			sort.Strings(s.Paths)
+			dupes = append(dupes, *s)
+		}
+	}
+	sort.Slice(dupes, func(i, j int) bool {
+		return h.outranks(&dupGroup{paths: dupes[i].Paths, size: dupes[i].Size}, &dupGroup{paths: dupes[j].Paths, size: dupes[j].Size})
+	})
+	return dupes, errs.err()
+}
+
+// walkOnly returns a copy of h that walks the tree as h does, but reads no
+// file, and records or reports nothing. The filters it leaves out, such as
+// h.SkipFunc, can only make it find more files than h would.
+func (h *Hasher) walkOnly() *Hasher {
+	sh := *h
+	sh.StructureOnly, sh.MetadataDigest, sh.ArchiveMode = true, false, false
+	sh.HashSelector, sh.Transform, sh.ChunkSize = nil, nil, 0
+	sh.Cache, sh.CheckpointDir, sh.RecordOrder, sh.ReplayOrder = nil, "", nil, nil
+	sh.SkipFunc, sh.Stats, sh.OnProgress, sh.OnFileStart, sh.OnFileDone = nil, nil, nil, nil, nil
+	sh.ProgressFunc, sh.CountFirst = nil, false
+	return &sh
+}
+
+// fileSizes walks the file tree rooted at root the way h does, but without
+// reading any file other than the container files of h.Expanders, and returns
+// how many non-empty files and members of containers it found of each size.
+func (h *Hasher) fileSizes(ctx context.Context, root string) (map[int64]int, error) {
+	// The paths of the results must be those of the files, to open the
+	// containers at.
+	sh := h.walkOnly()
+	sh.KeyFunc = nil
+	if sh.InvalidNames == InvalidNamesEscape {
+		sh.InvalidNames = InvalidNamesKeep
+	}
+
+	sizes := make(map[int64]int)
+	err := sh.collect(ctx.Done(), root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		if e := h.expander(file{path: r.Path, symlink: r.Symlink}); e != nil {
+			// A container that cannot be read fails again when
+			// FindDuplicates reads it, as h.ErrorPolicy has it.
+			h.expandFile(e, r.Path, r.Size, func(m Member, contents io.Reader) error {
+				if m.Size > 0 {
+					sizes[m.Size]++
+				}
+				return nil
+			})
+			return nil
+		}
+		if r.Size > 0 {
+			sizes[r.Size]++
+		}
+		return nil
+	})
+	if errors.Is(err, ErrWalkCanceled) {
+		return nil, ctx.Err()
+	}
+	return sizes, err
+}
@@ -0,0 +1,70 @@
+package checksum
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+)
+
+// zipOf returns a zip archive of members, by name, written in the order given.
+func zipOf(t testing.TB, members ...[2]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		w, err := zw.Create(m[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(m[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// TestFindDuplicatesArchives checks that FindDuplicates with Expanders finds
+// the members two archives share, though the archives differ in size, and a
+// file that is a copy of them, though no other file is as large.
+func TestFindDuplicatesArchives(t *testing.T) {
+	a := zipOf(t, [2]string{"shared.txt", "shared content"}, [2]string{"pad.txt", "a"})
+	b := zipOf(t, [2]string{"shared.txt", "shared content"}, [2]string{"pad.txt", "a longer pad"})
+	if len(a) == len(b) {
+		t.Fatalf("archives of %d bytes each, want them to differ in size", len(a))
+	}
+	root := writeTree(t, map[string]string{
+		"a.zip":    a,
+		"b.zip":    b,
+		"copy.txt": "shared content",
+		"empty":    "",
+	})
+
+	h := &Hasher{Expanders: ArchiveExpanders()}
+	dupes, err := h.FindDuplicates(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dupes) != 1 {
+		t.Fatalf("FindDuplicates found %d sets, want 1: %+v", len(dupes), dupes)
+	}
+	want := []string{"a.zip!shared.txt", "b.zip!shared.txt", "copy.txt"}
+	var got []string
+	for _, path := range dupes[0].Paths {
+		got = append(got, relTo(t, root, path))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("duplicates %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("duplicates %v, want %v", got, want)
+		}
+	}
+	if s := dupes[0]; s.Size != int64(len("shared content")) || !bytes.Equal(s.Sum, sumOf("shared content")) {
+		t.Errorf("set of size %d and sum %x, want %d and %x", s.Size, s.Sum, len("shared content"), sumOf("shared content"))
+	}
+}
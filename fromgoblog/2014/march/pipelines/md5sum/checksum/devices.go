@@ -0,0 +1,129 @@
+package checksum
+
+// maxDevicePending bounds how many files found by the walk a PerDeviceWorkers
+// run holds back while their devices are busy, before the walk is made to
+// wait.
+const maxDevicePending = 1024
+
+// deviceScheduler stands between the walk and the digesters of a
+// PerDeviceWorkers run. It hands a file to the digesters only while fewer than
+// limit files of its device are being read, holding back the others, so that
+// the digesters left idle by a slow device go on reading from the others
+// rather than queue up behind it. Devices with files waiting are served in
+// turn.
+type deviceScheduler struct {
+	limit    int
+	finished chan uint64 // devices of the files the digesters are done with
+
+	active  map[uint64]int    // files being read, by device
+	pending map[uint64][]file // files held back, by device
+	order   []uint64          // devices with files held back, in turn
+	held    int               // number of files held back
+	busy    int               // number of files being read
+}
+
+// scheduleDevices starts a scheduler handing the files received from in to the
+// returned channel, with at most h.PerDeviceWorkers of them per device being
+// read at once, and sets it as the scheduler of j. The digesters must tell it
+// about every file they are done with. The returned channel is closed once in
+// is closed and the digesters are done with every file it sent.
+func (h *Hasher) scheduleDevices(done <-chan struct{}, in <-chan file, j *job) <-chan file {
+	s := &deviceScheduler{
+		limit:    h.PerDeviceWorkers,
+		finished: make(chan uint64),
+		active:   make(map[uint64]int),
+		pending:  make(map[uint64][]file),
+	}
+	j.devices = s
+
+	out := make(chan file)
+	go func() {
+		defer close(out)
+		s.run(done, in, out)
+	}()
+	return out
+}
+
+// run hands the files received from in to out, until in is closed and every
+// file is done with, or done is closed.
+func (s *deviceScheduler) run(done <-chan struct{}, in <-chan file, out chan<- file) {
+	for in != nil || s.held > 0 || s.busy > 0 {
+		// Only take more files from the walk while not too many are
+		// held back, and only offer one to the digesters if a device
+		// has a place for it.
+		recv := in
+		if s.held >= maxDevicePending {
+			recv = nil
+		}
+		var send chan<- file
+		next, i, ok := s.next()
+		if ok {
+			send = out
+		}
+
+		select {
+		case f, ok := <-recv:
+			if !ok {
+				in = nil
+				continue
+			}
+			f.dev = fileDevice(f.info)
+			s.hold(f)
+		case send <- next:
+			s.take(i)
+		case dev := <-s.finished:
+			s.active[dev]--
+			s.busy--
+		case <-done:
+			return
+		}
+	}
+}
+
+// hold holds back the file f until its device has a place for it.
+func (s *deviceScheduler) hold(f file) {
+	if len(s.pending[f.dev]) == 0 {
+		s.order = append(s.order, f.dev)
+	}
+	s.pending[f.dev] = append(s.pending[f.dev], f)
+	s.held++
+}
+
+// next returns the first file held back, taking the devices in the turn of
+// s.order, whose device has a place for it, along with the index of the device
+// in s.order.
+func (s *deviceScheduler) next() (file, int, bool) {
+	for i, dev := range s.order {
+		if s.active[dev] < s.limit {
+			return s.pending[dev][0], i, true
+		}
+	}
+	return file{}, 0, false
+}
+
+// take records that the file returned by next for the device at index i of
+// s.order was handed over, and sends the device to the back of the turn.
+func (s *deviceScheduler) take(i int) {
+	dev := s.order[i]
+	s.active[dev]++
+	s.busy++
+	s.held--
+	s.order = append(s.order[:i], s.order[i+1:]...)
+
+	q := s.pending[dev][1:]
+	if len(q) == 0 {
+		delete(s.pending, dev)
+		return
+	}
+	s.pending[dev] = q
+	s.order = append(s.order, dev)
+}
+
+// finish tells s that a digester is done with a file of device dev. It
+// returns early if done is closed, since s may then have stopped.
+func (s *deviceScheduler) finish(dev uint64, done <-chan struct{}) {
+	select {
+	case s.finished <- dev:
+	case <-done:
+	}
+}
@@ -0,0 +1,17 @@
+package checksum
+
+import (
+	"os"
+	"syscall"
+)
+
+// deviceNumbers returns the major and minor numbers of the device described by
+// info, decoded as the major and minor macros of <sys/types.h> do.
+func deviceNumbers(info os.FileInfo) (major, minor uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	dev := uint32(st.Rdev)
+	return (dev >> 24) & 0xff, dev & 0xffffff, true
+}
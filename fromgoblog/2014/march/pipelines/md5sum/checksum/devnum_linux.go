@@ -0,0 +1,19 @@
+package checksum
+
+import (
+	"os"
+	"syscall"
+)
+
+// deviceNumbers returns the major and minor numbers of the device described by
+// info, decoded as glibc's major and minor macros do.
+func deviceNumbers(info os.FileInfo) (major, minor uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	dev := uint64(st.Rdev)
+	major = uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor = uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor, true
+}
@@ -0,0 +1,130 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"reflect"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// A Digest is the checksum of a file's contents, as computed by the hash of a
+// Hasher. It is marshaled as text in hexadecimal.
+type Digest []byte
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(d)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Digest) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*d = b
+	return nil
+}
+
+// ShortHex returns the first n hexadecimal digits of sum, such as an 8-digit
+// fingerprint for a log line, or all of them if n is not positive or sum has
+// fewer. A truncated digest is far more likely to be shared by two different
+// files than the full one, so it is for display only and must never be used to
+// verify contents.
+func ShortHex(sum []byte, n int) string {
+	if n <= 0 || n >= 2*len(sum) {
+		return hex.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum[:(n+1)/2])[:n]
+}
+
+// Short returns the first n hexadecimal digits of r.Sum, for display only; see
+// ShortHex.
+func (r Result) Short(n int) string {
+	return ShortHex(r.Sum, n)
+}
+
+// md5Sum returns d as an MD5 sum, or an error if d is not as long as one, as
+// when it was computed with another hash.
+func (d Digest) md5Sum() ([md5.Size]byte, error) {
+	var sum [md5.Size]byte
+	if len(d) != md5.Size {
+		return sum, fmt.Errorf("checksum: digest of %d bytes is not an MD5 sum", len(d))
+	}
+	copy(sum[:], d)
+	return sum, nil
+}
+
+// md5Hasher returns a copy of h that digests every file with MD5, for the
+// functions returning MD5 sums whatever h.Hash and h.HashSelector are.
+func (h *Hasher) md5Hasher() *Hasher {
+	md5h := *h
+	md5h.Hash, md5h.HashSelector = md5.New, nil
+	return &md5h
+}
+
+// hashFuncs are the hash algorithms HashFunc knows by name.
+var hashFuncs = map[string]func() hash.Hash{
+	"blake2b": newBLAKE2b,
+	"crc32":   newCRC32,
+	"md5":     md5.New,
+	"sha1":    sha1.New,
+	"sha256":  sha256.New,
+	"sha512":  sha512.New,
+	"xxhash":  newXXHash,
+}
+
+// newBLAKE2b returns a new unkeyed BLAKE2b-512 hash, the one b2sum computes.
+func newBLAKE2b() hash.Hash {
+	// New512 only fails for a key longer than 64 bytes.
+	d, _ := blake2b.New512(nil)
+	return d
+}
+
+// newCRC32 returns a new IEEE CRC-32 hash.
+func newCRC32() hash.Hash {
+	return crc32.NewIEEE()
+}
+
+// newXXHash returns a new XXH64 hash with a zero seed, whose sums are
+// big-endian as xxhsum prints them.
+func newXXHash() hash.Hash {
+	return xxhash.New()
+}
+
+// HashFunc returns the constructor of the hash algorithm with the given name,
+// one of "blake2b", "crc32", "md5", "sha1", "sha256", "sha512" or "xxhash", for
+// use as Hasher.Hash. BLAKE2b is BLAKE2b-512 and xxhash is XXH64. CRC-32 and
+// XXH64 are no cryptographic hashes and only fit to detect changes, for which
+// XXH64 is the fastest. Any other hash can be used by passing its constructor
+// instead.
+func HashFunc(name string) (func() hash.Hash, error) {
+	f, ok := hashFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("checksum: unknown hash algorithm %q", name)
+	}
+	return f, nil
+}
+
+// hashName returns the name HashFunc knows newHash by, "md5" if newHash is nil,
+// or an empty string if it is none of those HashFunc returns.
+func hashName(newHash func() hash.Hash) string {
+	if newHash == nil {
+		return "md5"
+	}
+	p := reflect.ValueOf(newHash).Pointer()
+	for name, f := range hashFuncs {
+		if reflect.ValueOf(f).Pointer() == p {
+			return name
+		}
+	}
+	return ""
+}
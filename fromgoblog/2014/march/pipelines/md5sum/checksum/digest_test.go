@@ -0,0 +1,176 @@
+package checksum
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDigestMD5Sum(t *testing.T) {
+	want := md5Of("hello")
+	tests := []struct {
+		name    string
+		d       Digest
+		wantErr bool
+	}{
+		{"md5", want[:], false},
+		{"sha256", Digest(sha256.New().Sum(nil)), true},
+		{"short", Digest(want[:8]), true},
+		{"nil", nil, true},
+	}
+	for _, tt := range tests {
+		sum, err := tt.d.md5Sum()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: md5Sum error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && sum != want {
+			t.Errorf("%s: md5Sum = %x, want %x", tt.name, sum, want)
+		}
+	}
+}
+
+// isoSHA256 is a HashSelector choosing SHA-256 for .iso files, leaving the
+// rest to Hasher.Hash.
+func isoSHA256(path string, info os.FileInfo) func() hash.Hash {
+	if filepath.Ext(path) == ".iso" {
+		return sha256.New
+	}
+	return nil
+}
+
+// TestMD5IgnoresHashSelector checks that the functions returning MD5 sums
+// compute them for every file, even those a HashSelector picks another hash
+// for.
+func TestMD5IgnoresHashSelector(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.iso":     "hello",
+		"b.iso":     "hello",
+		"sub/c.txt": "hello",
+	})
+	h := &Hasher{HashSelector: isoSHA256}
+	want := md5Of("hello")
+
+	t.Run("MD5All", func(t *testing.T) {
+		m, err := h.MD5All(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path, sum := range m {
+			if sum != want {
+				t.Errorf("sum of %s = %x, want %x", relTo(t, root, path), sum, want)
+			}
+		}
+	})
+
+	t.Run("UniqueContent", func(t *testing.T) {
+		u, err := h.UniqueContent(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(u) != 1 || u[want] == "" {
+			t.Errorf("UniqueContent = %v, want a single group of sum %x", u, want)
+		}
+	})
+
+	t.Run("CommonContent", func(t *testing.T) {
+		c, err := h.CommonContent(root, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c) != 1 || len(c[want].A) != 3 {
+			t.Errorf("CommonContent = %v, want the three files under sum %x", c, want)
+		}
+	})
+
+	t.Run("TopDuplicate", func(t *testing.T) {
+		sum, paths, _, err := h.TopDuplicate(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != want || len(paths) != 3 {
+			t.Errorf("TopDuplicate = %x, %v, want %x and the three files", sum, paths, want)
+		}
+	})
+
+	t.Run("StreamDuplicates", func(t *testing.T) {
+		var n int
+		for ev := range h.StreamDuplicates(context.Background(), root) {
+			if ev.Err != nil {
+				t.Fatal(ev.Err)
+			}
+			if ev.Digest != want {
+				t.Errorf("duplicate %s of %s has sum %x, want %x", ev.NewPath, ev.ExistingPath, ev.Digest, want)
+			}
+			n++
+		}
+		if n != 2 {
+			t.Errorf("StreamDuplicates reported %d duplicates, want 2", n)
+		}
+	})
+}
+
+// TestHashSelectorAlgo checks that a HashSelector is still honored where the
+// hash is not fixed, and recorded in Result.Algo.
+func TestHashSelectorAlgo(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.iso": "hello", "b.txt": "hello"})
+	h := &Hasher{HashSelector: isoSHA256}
+
+	results, errc := h.Stream(context.Background(), root)
+	got := make(map[string]Result)
+	for r := range results {
+		got[relTo(t, root, r.Path)] = r
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	iso := sha256.Sum256([]byte("hello"))
+	txt := md5.Sum([]byte("hello"))
+	if r := got["a.iso"]; !bytes.Equal(r.Sum, iso[:]) || r.Algo != "sha256" {
+		t.Errorf("a.iso: sum %x, algo %q, want %x, \"sha256\"", r.Sum, r.Algo, iso)
+	}
+	if r := got["b.txt"]; !bytes.Equal(r.Sum, txt[:]) || r.Algo != "md5" {
+		t.Errorf("b.txt: sum %x, algo %q, want %x, \"md5\"", r.Sum, r.Algo, txt)
+	}
+}
+
+func TestHashFunc(t *testing.T) {
+	// The sums of "abc", as printed by md5sum, sha1sum, sha256sum,
+	// sha512sum, b2sum and xxhsum.
+	tests := map[string]string{
+		"blake2b": "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+		"crc32":   "352441c2",
+		"md5":     "900150983cd24fb0d6963f7d28e17f72",
+		"sha1":    "a9993e364706816aba3e25717850c26c9cd0d89d",
+		"sha256":  "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"sha512":  "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		"xxhash":  "44bc2cf5ad770999",
+	}
+	for name, want := range tests {
+		newHash, err := HashFunc(name)
+		if err != nil {
+			t.Errorf("HashFunc(%q): %v", name, err)
+			continue
+		}
+		d := newHash()
+		d.Write([]byte("abc"))
+		if got := hex.EncodeToString(d.Sum(nil)); got != want {
+			t.Errorf("%s of abc = %s, want %s", name, got, want)
+		}
+		if got := hashName(newHash); got != name {
+			t.Errorf("hashName of HashFunc(%q) = %q", name, got)
+		}
+	}
+	if len(tests) != len(hashFuncs) {
+		t.Errorf("tested %d of the %d hashes of HashFunc", len(tests), len(hashFuncs))
+	}
+	if _, err := HashFunc("md4"); err == nil {
+		t.Error("HashFunc(md4) succeeded")
+	}
+}
@@ -0,0 +1,262 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A DirResult holds the results of the files of a single directory, as sent by
+// StreamDirs, in the order the walk found them. Dir is the directory as found
+// by the walk, even if Hasher.WalkFunc or Hasher.KeyFunc changed the paths of
+// its files.
+type DirResult struct {
+	Dir   string
+	Files []Result
+}
+
+// StreamDirs is like Stream, but holds back the results of each directory
+// until every one of its files has been digested, and sends them together as
+// a DirResult. Only the files of a directory count, not those of its
+// subdirectories, which get a DirResult of their own. Directories are sent in
+// the order they are completed, and a directory without any file is not sent.
+// If the walk fails, the directories it did not complete are sent with the
+// files they got before the error is.
+//
+// StreamDirs uses a zero Hasher; see Hasher.StreamDirs.
+func StreamDirs(ctx context.Context, root string) (<-chan DirResult, <-chan error) {
+	return new(Hasher).StreamDirs(ctx, root)
+}
+
+// StreamDirs is like the package-level StreamDirs, but reads the files through
+// h.
+func (h *Hasher) StreamDirs(ctx context.Context, root string) (<-chan DirResult, <-chan error) {
+	out := make(chan DirResult)
+	errc := make(chan error, 1)
+
+	go func() {
+		err := h.collectDirs(ctx.Done(), root, func(d DirResult) error {
+			select {
+			case out <- d:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if errors.Is(err, ErrWalkCanceled) {
+			err = ctx.Err()
+		}
+
+		close(out)
+
+		// No select needed here, since errc is buffered.
+		errc <- err
+	}()
+
+	return out, errc
+}
+
+// collectDirs is like collect, but calls fn with the results of a directory at
+// a time, once they are all in.
+func (h *Hasher) collectDirs(done <-chan struct{}, root string, fn func(d DirResult) error) error {
+	t := newDirTracker()
+	j := h.newJob()
+	j.dirs = t
+
+	// fnErr is the error fn returned, which stops the run as it is.
+	var fnErr error
+	emit := func(dirs []DirResult) error {
+		for _, d := range dirs {
+			if fnErr = fn(d); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	}
+
+	err := h.collectJob(done, root, j, func(r Result) error {
+		return emit(t.record(r))
+	})
+	if fnErr != nil || errors.Is(err, ErrWalkCanceled) {
+		return err
+	}
+	if ferr := emit(t.rest()); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// dirGroup gathers the results of the files of a directory.
+type dirGroup struct {
+	dir   string
+	first int64 // sequence number of the first file sent
+
+	// sent is the number of files sent to the digesters, got the number
+	// of their results recorded or dropped so far, and walked is set once
+	// the walk has moved past the directory. emitted is set once files,
+	// the results recorded, have been returned.
+	sent    int
+	got     int
+	walked  bool
+	emitted bool
+	files   []Result
+}
+
+// complete reports whether g has every result it will get.
+func (g *dirGroup) complete() bool {
+	return g.walked && g.got == g.sent
+}
+
+// dirTracker gathers the results of a run per directory, for
+// Hasher.OrderByDirectory and StreamDirs. The walk calls leave, leaveAll and
+// add; collect calls record and drop.
+type dirTracker struct {
+	mu    sync.Mutex
+	files map[int64]*dirGroup // the group of each file sent, by sequence number
+	ready []*dirGroup         // complete groups not yet returned by record
+
+	// open is the stack of directories the walk is currently inside, of
+	// those it sent files of. It is only used by the walk goroutine.
+	open []*dirGroup
+}
+
+func newDirTracker() *dirTracker {
+	return &dirTracker{files: make(map[int64]*dirGroup)}
+}
+
+// leave marks every open directory that path does not lie below as walked,
+// since the walk visits the entries of a directory before moving on.
+func (t *dirTracker) leave(path string) {
+	path = filepath.Clean(path)
+	for len(t.open) > 0 && !inDir(path, t.open[len(t.open)-1].dir) {
+		t.walked(t.open[len(t.open)-1])
+		t.open = t.open[:len(t.open)-1]
+	}
+}
+
+// leaveAll marks every open directory as walked, once the walk has
+// completed.
+func (t *dirTracker) leaveAll() {
+	for len(t.open) > 0 {
+		t.walked(t.open[len(t.open)-1])
+		t.open = t.open[:len(t.open)-1]
+	}
+}
+
+// walked marks g as walked.
+func (t *dirTracker) walked(g *dirGroup) {
+	t.mu.Lock()
+	g.walked = true
+	if g.complete() {
+		t.ready = append(t.ready, g)
+	}
+	t.mu.Unlock()
+}
+
+// add accounts for the file at path, found by the walk, being sent to the
+// digesters as number seq.
+func (t *dirTracker) add(seq int64, path string) {
+	dir := filepath.Dir(filepath.Clean(path))
+	if len(t.open) == 0 || t.open[len(t.open)-1].dir != dir {
+		t.open = append(t.open, &dirGroup{dir: dir, first: seq})
+	}
+	g := t.open[len(t.open)-1]
+
+	t.mu.Lock()
+	g.sent++
+	t.files[seq] = g
+	t.mu.Unlock()
+}
+
+// record adds r to the results of its directory, and returns the directories
+// completed since the last call, that are not to be returned again.
+func (t *dirTracker) record(r Result) []DirResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// The members of an expanded container share its sequence number, and
+	// only the last of them counts as its result.
+	g := t.files[r.seq]
+	if !r.more {
+		g = t.take(r.seq)
+	}
+	if g != nil {
+		g.files = append(g.files, r)
+		if g.complete() {
+			t.ready = append(t.ready, g)
+		}
+	}
+	if len(t.ready) == 0 {
+		return nil
+	}
+	dirs := make([]DirResult, 0, len(t.ready))
+	for _, g := range t.ready {
+		if d, ok := g.result(); ok {
+			dirs = append(dirs, d)
+		}
+	}
+	t.ready = t.ready[:0]
+	return dirs
+}
+
+// drop accounts for the result of file number seq, which is not to be
+// delivered, such as that of a locked file. A nil dirTracker ignores it.
+func (t *dirTracker) drop(seq int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if g := t.take(seq); g != nil && g.complete() {
+		t.ready = append(t.ready, g)
+	}
+	t.mu.Unlock()
+}
+
+// take returns the group of file number seq, having counted its result, or nil
+// if there is none. t.mu must be held.
+func (t *dirTracker) take(seq int64) *dirGroup {
+	g := t.files[seq]
+	if g == nil {
+		return nil
+	}
+	delete(t.files, seq)
+	g.got++
+	return g
+}
+
+// rest returns the directories with results not returned by record yet, such
+// as those the walk did not complete because it failed, in the order the walk
+// found them.
+func (t *dirTracker) rest() []DirResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	groups := append([]*dirGroup(nil), t.ready...)
+	for _, g := range t.files {
+		groups = append(groups, g)
+	}
+	groups = append(groups, t.open...)
+	sort.Slice(groups, func(i, j int) bool { return groups[i].first < groups[j].first })
+
+	var dirs []DirResult
+	for _, g := range groups {
+		if d, ok := g.result(); ok {
+			dirs = append(dirs, d)
+		}
+	}
+	t.ready = t.ready[:0]
+	return dirs
+}
+
+// result returns the DirResult of g, in walk order, unless g has no results or
+// was already returned.
+func (g *dirGroup) result() (DirResult, bool) {
+	if g.emitted || len(g.files) == 0 {
+		return DirResult{}, false
+	}
+	g.emitted = true
+	sort.SliceStable(g.files, func(i, j int) bool { return g.files[i].seq < g.files[j].seq })
+	return DirResult{g.dir, g.files}, true
+}
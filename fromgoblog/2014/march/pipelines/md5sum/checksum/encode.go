@@ -0,0 +1,298 @@
+package checksum
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Encoder writes results in some output format, one at a time, as they
+// are produced by a run, so that they need not be held in memory. Encode
+// writes a single result, and Close writes whatever ends the output, such as
+// the closing bracket of a JSON array, without closing the underlying writer.
+// Each record holds the path, size, modification time and digest of a file.
+// Formats other than those of NewEncoder are added by implementing Encoder.
+type Encoder interface {
+	Encode(r Result) error
+	Close() error
+}
+
+// encoders are the formats NewEncoder knows, by name.
+var encoders = map[string]func(w io.Writer) Encoder{
+	"manifest":  NewManifestEncoder,
+	"coreutils": NewCoreutilsEncoder,
+	"json":      NewJSONEncoder,
+	"ndjson":    NewNDJSONEncoder,
+	"csv":       NewCSVEncoder,
+	"bsd":       NewBSDEncoder,
+}
+
+// NewEncoder returns an Encoder writing to w in the named format: "manifest",
+// "coreutils", "json", "ndjson", "csv" or "bsd".
+func NewEncoder(format string, w io.Writer) (Encoder, error) {
+	f, ok := encoders[format]
+	if !ok {
+		return nil, fmt.Errorf("checksum: unknown output format %q", format)
+	}
+	return f(w), nil
+}
+
+// EncoderFormats returns the names of the formats NewEncoder knows, sorted.
+func EncoderFormats() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A lineEncoder writes a line per result, formatted by line, which reports
+// false for results it has no line for, or an error for those it cannot write,
+// after an optional header.
+type lineEncoder struct {
+	w      io.Writer
+	header string
+	line   func(r Result) (string, bool, error)
+}
+
+func (e *lineEncoder) Encode(r Result) error {
+	if e.header != "" {
+		if _, err := io.WriteString(e.w, e.header); err != nil {
+			return err
+		}
+		e.header = ""
+	}
+	s, ok, err := e.line(r)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = io.WriteString(e.w, s)
+	return err
+}
+
+func (e *lineEncoder) Close() error {
+	if e.header != "" {
+		_, err := io.WriteString(e.w, e.header)
+		e.header = ""
+		return err
+	}
+	return nil
+}
+
+// NewManifestEncoder returns an Encoder writing a manifest to w, as ScanToFile
+// does but in the order the results come: ManifestHeader, then a "sum\tpath"
+// line per file. A failed result has no line, and one whose path cannot be
+// written to a manifest is an error matching ErrManifestPath.
+func NewManifestEncoder(w io.Writer) Encoder {
+	return &lineEncoder{w: w, header: ManifestHeader + "\n", line: func(r Result) (string, bool, error) {
+		if r.Err != nil {
+			return "", false, nil
+		}
+		line, err := manifestLine(r)
+		return line, true, err
+	}}
+}
+
+// NewCoreutilsEncoder returns an Encoder writing a checksum file to w in the
+// format of GNU coreutils, as WriteCoreutilsDigests does in text mode. A failed
+// result has no line.
+func NewCoreutilsEncoder(w io.Writer) Encoder {
+	return &lineEncoder{w: w, line: func(r Result) (string, bool, error) {
+		if r.Err != nil {
+			return "", false, nil
+		}
+		var b strings.Builder
+		bw := bufio.NewWriter(&b)
+		writeCoreutilsLine(bw, r.Path, r.Sum, ' ')
+		bw.Flush()
+		return b.String(), true, nil
+	}}
+}
+
+// NewBSDEncoder returns an Encoder writing a line to w per result in the format
+// of the BSD md5 and sha256 commands and of "shasum --tag", such as
+//
+//	MD5 (a/b.txt) = 0cc175b9c0f1b6a831c399e269772661
+//
+// naming the hash as Result.Algo does, in upper case. A result with Sums has a
+// line per digest, in the order of the hash names. A failed result has no
+// line. Paths are escaped as WriteCoreutils escapes them, with a backslash
+// starting the line, as "md5sum --tag" does, so that a newline cannot end the
+// line early; a path holding ") = " is told apart by reading the line from
+// its end, the digest being of a known length.
+func NewBSDEncoder(w io.Writer) Encoder {
+	return &lineEncoder{w: w, line: func(r Result) (string, bool, error) {
+		if r.Sums == nil {
+			return bsdLine(r.Algo, r.Path, r.Sum), r.Err == nil, nil
+		}
+		var b strings.Builder
+		for _, name := range sumNames(r.Sums) {
+			b.WriteString(bsdLine(name, r.Path, r.Sums[name]))
+		}
+		return b.String(), r.Err == nil, nil
+	}}
+}
+
+// bsdLine returns the line of NewBSDEncoder for the digest sum of path,
+// computed with the hash named algo.
+func bsdLine(algo, path string, sum Digest) string {
+	algo = strings.ToUpper(algo)
+	if algo == "" {
+		algo = "DIGEST"
+	}
+	escaped := coreutilsEscaper.Replace(path)
+	if escaped != path {
+		return fmt.Sprintf("\\%s (%s) = %x\n", algo, escaped, sum)
+	}
+	return fmt.Sprintf("%s (%s) = %x\n", algo, path, sum)
+}
+
+// sumNames returns the hash names of sums, sorted.
+func sumNames(sums map[string]Digest) []string {
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jsonRecord is the JSON object the JSON and NDJSON encoders write for a
+// Result.
+type jsonRecord struct {
+	Path    string            `json:"path"`
+	Size    int64             `json:"size"`
+	ModTime time.Time         `json:"modTime"`
+	Hash    Digest            `json:"hash,omitempty"`
+	Algo    string            `json:"algo,omitempty"`
+	Hashes  map[string]Digest `json:"hashes,omitempty"`
+	LinkOf  string            `json:"linkOf,omitempty"`
+	Removed bool              `json:"removed,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// newJSONRecord returns the record of r, whose hashes are its Sums. A result
+// that failed has an error holding the message of its Err instead of a hash.
+func newJSONRecord(r Result) jsonRecord {
+	rec := jsonRecord{Path: r.Path, Size: r.Size, ModTime: r.ModTime, Hash: r.Sum, Algo: r.Algo, Hashes: r.Sums, LinkOf: r.LinkOf, Removed: r.Removed}
+	if r.Err != nil {
+		rec.Hash, rec.Algo, rec.Hashes, rec.Error = nil, "", nil, r.Err.Error()
+	}
+	return rec
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+// NewNDJSONEncoder returns an Encoder writing each result to w as a JSON object
+// on a line of its own, as WriteNDJSON does.
+func NewNDJSONEncoder(w io.Writer) Encoder {
+	return &ndjsonEncoder{json.NewEncoder(w)}
+}
+
+func (e *ndjsonEncoder) Encode(r Result) error { return e.enc.Encode(newJSONRecord(r)) }
+func (e *ndjsonEncoder) Close() error          { return nil }
+
+type jsonEncoder struct {
+	w io.Writer
+	n int
+}
+
+// NewJSONEncoder returns an Encoder writing the results to w as a single
+// indented JSON array of the objects NewNDJSONEncoder writes. The array is
+// only complete once Close has been called.
+func NewJSONEncoder(w io.Writer) Encoder {
+	return &jsonEncoder{w: w}
+}
+
+func (e *jsonEncoder) Encode(r Result) error {
+	data, err := json.MarshalIndent(newJSONRecord(r), "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if e.n == 0 {
+		sep = "[\n  "
+	}
+	e.n++
+	_, err = io.WriteString(e.w, sep+string(data))
+	return err
+}
+
+func (e *jsonEncoder) Close() error {
+	end := "\n]\n"
+	if e.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVEncoder returns an Encoder writing the results to w as CSV, with a
+// header line naming the columns: path, size, modTime, hash, algo, error and
+// hashes. The modification time is in RFC 3339 format, and the hash in
+// hexadecimal. Hashes lists the Sums of a result, if any, as space-separated
+// name:hash pairs in the order of the names. A result that failed has an error
+// holding the message of its Err instead of a hash.
+func NewCSVEncoder(w io.Writer) Encoder {
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) Encode(r Result) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	rec := newJSONRecord(r)
+	e.w.Write([]string{
+		rec.Path,
+		strconv.FormatInt(rec.Size, 10),
+		rec.ModTime.Format(time.RFC3339Nano),
+		hex.EncodeToString(rec.Hash),
+		rec.Algo,
+		rec.Error,
+		csvHashes(rec.Hashes),
+	})
+	// Each record is flushed as it comes, for output that streams.
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// writeHeader writes the header line, unless it already has.
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.w.Write([]string{"path", "size", "modTime", "hash", "algo", "error", "hashes"})
+}
+
+// csvHashes returns the hashes column of NewCSVEncoder for sums.
+func csvHashes(sums map[string]Digest) string {
+	pairs := make([]string, 0, len(sums))
+	for _, name := range sumNames(sums) {
+		pairs = append(pairs, name+":"+hex.EncodeToString(sums[name]))
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,134 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is the number of leading bytes looked at to decide whether a file
+// holds text.
+const sniffLen = 512
+
+// textExtensions are the file name extensions IsText treats as text without
+// looking at the file's contents.
+var textExtensions = map[string]bool{
+	".c": true, ".css": true, ".csv": true, ".go": true, ".h": true,
+	".html": true, ".js": true, ".json": true, ".md": true, ".py": true,
+	".sh": true, ".txt": true, ".xml": true, ".yaml": true, ".yml": true,
+}
+
+// IsText reports whether the file at path holds text, given head, its first
+// bytes. Files with a well-known text extension are text; any other file is
+// text only if head contains no NUL byte and is valid UTF-8. IsText is the
+// default Hasher.IsText.
+func IsText(path string, head []byte) bool {
+	if textExtensions[strings.ToLower(filepath.Ext(path))] {
+		return true
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+
+	// head may end in the middle of a multi-byte rune.
+	for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	return utf8.Valid(head)
+}
+
+// isText reports whether the file at path holds text, using h.IsText.
+func (h *Hasher) isText(path string, head []byte) bool {
+	if h.IsText != nil {
+		return h.IsText(path, head)
+	}
+	return IsText(path, head)
+}
+
+// copyNormalized copies the file at path, read from r, to d for
+// h.NormalizeEOL: if the file holds text, every "\r\n" is replaced with "\n"
+// on the way; otherwise the bytes are copied as they are.
+func (h *Hasher) copyNormalized(d io.Writer, path string, r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	// A short or failed Peek only means there is less to look at; a read
+	// error shows up again when the file is copied.
+	head, _ := br.Peek(sniffLen)
+	if !h.isText(path, head) {
+		_, err := io.Copy(d, br)
+		return err
+	}
+
+	w := &crlfWriter{w: d}
+	if _, err := io.Copy(w, br); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// crlfWriter is a writer that replaces every "\r\n" with "\n" in the bytes
+// written to it before passing them on to w.
+type crlfWriter struct {
+	w io.Writer
+
+	// cr is set if the last byte written was a '\r' that has been held back
+	// until the next write shows whether a '\n' follows it.
+	cr bool
+}
+
+var cr = []byte{'\r'}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+
+	if c.cr {
+		c.cr = false
+		if p[0] != '\n' {
+			if _, err := c.w.Write(cr); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\r')
+		if i < 0 {
+			if _, err := c.w.Write(p); err != nil {
+				return 0, err
+			}
+			break
+		}
+
+		if _, err := c.w.Write(p[:i]); err != nil {
+			return 0, err
+		}
+		if i+1 == len(p) {
+			c.cr = true
+			break
+		}
+		if p[i+1] != '\n' {
+			if _, err := c.w.Write(cr); err != nil {
+				return 0, err
+			}
+		}
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+// flush writes out a '\r' held back at the end of the input.
+func (c *crlfWriter) flush() error {
+	if !c.cr {
+		return nil
+	}
+	c.cr = false
+	_, err := c.w.Write(cr)
+	return err
+}
@@ -0,0 +1,114 @@
+package checksum
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A WalkError is returned for a failure of the directory walk, such as a
+// directory that cannot be listed or a malformed exclusion pattern. Path is
+// where the walk was when it failed.
+type WalkError struct {
+	Path string
+	Err  error
+}
+
+func (e *WalkError) Error() string { return describe(e.Path, e.Err) }
+func (e *WalkError) Unwrap() error { return e.Err }
+
+// A ReadError is returned for a file that could not be opened or read. Path
+// is the path of the file as found by the walk.
+type ReadError struct {
+	Path string
+	Err  error
+}
+
+func (e *ReadError) Error() string { return describe(e.Path, e.Err) }
+func (e *ReadError) Unwrap() error { return e.Err }
+
+// ReadErrors is the error returned, under KeepGoing, by a run some of whose
+// files could not be read: the *ReadError of each of those files, sorted by
+// path. The results of the other files are returned along with it.
+type ReadErrors []*ReadError
+
+func (e ReadErrors) Error() string {
+	switch len(e) {
+	case 1:
+		return e[0].Error()
+	case 2:
+		return fmt.Sprintf("%v (and 1 more file could not be read)", e[0])
+	}
+	return fmt.Sprintf("%v (and %d more files could not be read)", e[0], len(e)-1)
+}
+
+// Unwrap returns the errors in e, so that errors.Is and errors.As look at
+// each of them.
+func (e ReadErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, re := range e {
+		errs[i] = re
+	}
+	return errs
+}
+
+// An ErrorPolicy says what the functions that return the results of every file
+// at once, MD5All, Run, SumAll and ScanStates, do when a file cannot be read.
+type ErrorPolicy int
+
+const (
+	// FailFast stops the run at the first file that cannot be read, and
+	// returns its error alone. This is the default.
+	FailFast ErrorPolicy = iota
+
+	// KeepGoing leaves out the files that cannot be read, and once every
+	// other file has been read, returns their results along with
+	// ReadErrors listing the files left out. Errors of the walk still stop
+	// the run.
+	KeepGoing
+)
+
+// readFailed deals with r, the result of a file that could not be read, for a
+// function following h.ErrorPolicy: it returns the error stopping the run, or
+// else adds it to errs and returns nil.
+func (h *Hasher) readFailed(errs *ReadErrors, r Result) error {
+	if h.ErrorPolicy != KeepGoing {
+		return r.Err
+	}
+	var re *ReadError
+	if !errors.As(r.Err, &re) {
+		re = &ReadError{r.Path, r.Err}
+	}
+	*errs = append(*errs, re)
+	return nil
+}
+
+// err returns errs, sorted by path, or nil if there are none.
+func (errs ReadErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// A ParseError is returned for a malformed manifest or checksum file. Line is
+// the number of the offending line, starting at 1.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// describe returns the message of err, prefixed with path unless err is an
+// *os.PathError, which already names it.
+func describe(path string, err error) string {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		return err.Error()
+	}
+	return path + ": " + err.Error()
+}
@@ -0,0 +1,159 @@
+package checksum
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// canonical returns path made absolute, with symbolic links in its directory
+// resolved. The last element is left alone, so that path need not exist yet.
+func canonical(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	dir, name := filepath.Split(abs)
+	if dir, err := filepath.EvalSymlinks(dir); err == nil {
+		return filepath.Join(dir, name)
+	}
+	return abs
+}
+
+// excludedPaths returns the set of h.ExcludePaths, canonicalized, and the
+// canonical form of root, which the walk uses to canonicalize the paths it
+// visits without resolving each of them. It returns a nil set if there are no
+// paths to exclude.
+func (h *Hasher) excludedPaths(root string) (map[string]bool, string) {
+	if len(h.ExcludePaths) == 0 {
+		return nil, ""
+	}
+	excl := make(map[string]bool, len(h.ExcludePaths))
+	for _, path := range h.ExcludePaths {
+		excl[canonical(path)] = true
+	}
+
+	return excl, canonicalRoot(root)
+}
+
+// canonicalRoot returns the canonical form of root: absolute, and with every
+// symbolic link resolved.
+func canonicalRoot(root string) string {
+	croot, err := filepath.Abs(root)
+	if err != nil {
+		return filepath.Clean(root)
+	}
+	if r, err := filepath.EvalSymlinks(croot); err == nil {
+		return r
+	}
+	return croot
+}
+
+// canonicalIn returns the canonical form of path, found by walking root, given
+// the canonical form croot of root.
+func canonicalIn(croot, root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return canonical(path)
+	}
+	return filepath.Join(croot, rel)
+}
+
+// absolutizer makes the paths found by walking a root absolute, without a
+// call to os.Getwd per path.
+type absolutizer struct {
+	root, absRoot string
+}
+
+// newAbsolutizer returns the absolutizer for the paths found under root.
+func newAbsolutizer(root string) absolutizer {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = ""
+	}
+	return absolutizer{root, absRoot}
+}
+
+// abs returns the absolute form of path, found by walking a.root.
+func (a absolutizer) abs(path string) string {
+	if a.absRoot != "" {
+		if rel, err := filepath.Rel(a.root, path); err == nil {
+			return filepath.Join(a.absRoot, rel)
+		}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// A pathFilter skips the files and directories of a walk as h.ExcludePaths,
+// h.Exclude, h.MaxDepth and h.GitIgnore have it, for the tree at base. It is
+// only used by one goroutine, as it keeps the .gitignore files of the
+// directories the walk is inside.
+type pathFilter struct {
+	h       *Hasher
+	base    string
+	depth   int
+	excl    map[string]bool
+	cbase   string
+	ignores *gitignores
+}
+
+// newPathFilter returns the filter of the walk of the tree at base.
+func (h *Hasher) newPathFilter(base string) *pathFilter {
+	f := &pathFilter{h: h, base: base, depth: rootDepth(base)}
+	f.excl, f.cbase = h.excludedPaths(base)
+	if h.GitIgnore {
+		f.ignores = &gitignores{fsys: h.FS}
+	}
+	return f
+}
+
+// skip reports whether the walk skips the file or directory at path, and
+// everything below it. The walk must pass every path to skip in its order,
+// and only descend into a directory skip lets through.
+func (f *pathFilter) skip(path string, isDir bool) (bool, error) {
+	if f.excl != nil && f.excl[canonicalIn(f.cbase, f.base, path)] {
+		return true, nil
+	}
+	if len(f.h.Exclude) > 0 {
+		if skip, err := f.h.excluded(path); skip || err != nil {
+			return skip, err
+		}
+	}
+	if f.h.MaxDepth > 0 && isDir && path != f.base &&
+		strings.Count(path, string(filepath.Separator))-f.depth >= f.h.MaxDepth {
+		return true, nil
+	}
+	if f.ignores != nil {
+		f.ignores.leave(path)
+		if f.ignores.ignored(path, isDir) {
+			return true, nil
+		}
+		if isDir {
+			if err := f.ignores.enter(path); err != nil {
+				return false, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// descend passes f the directories from its base down to path, which lies
+// below it, as the walk of the base would on its way there, and reports
+// whether the walk skips any of them, and so never reaches path.
+func (f *pathFilter) descend(path string) (bool, error) {
+	rel, err := filepath.Rel(f.base, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false, nil
+	}
+	dir := f.base
+	elems := strings.Split(rel, string(filepath.Separator))
+	for i := 0; i < len(elems); i++ {
+		if skip, err := f.skip(dir, true); skip || err != nil {
+			return skip, err
+		}
+		dir = filepath.Join(dir, elems[i])
+	}
+	return false, nil
+}
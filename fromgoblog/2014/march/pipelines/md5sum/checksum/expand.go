@@ -0,0 +1,229 @@
+package checksum
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// MemberSep separates the path of a container file from the name of one of
+// its members in the paths of the results of Hasher.Expanders, as in
+// "backup.tar.gz!inner/dir/file.txt".
+const MemberSep = "!"
+
+// ErrMemberPath is matched, with errors.Is, by the error of expanding an
+// archive holding a member whose name is absolute or climbs out of the
+// archive, such as "../../etc/passwd", which would make the path of its result
+// "backup.zip!../../etc/passwd", one that names a file outside the archive to
+// anything that splits it at MemberSep.
+var ErrMemberPath = errors.New("checksum: archive member name escapes the archive")
+
+// A Member is a regular file inside a container file, as listed by an
+// Expander. Name is its path inside the container, with forward slashes.
+type Member struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// An Expander lists the members of container files of some format, such as
+// archives, for Hasher.Expanders. Match reports whether the file at path is
+// such a container, judging by its name. Expand reads the container, of the
+// given size, from r, and calls fn with each of its regular members in turn,
+// along with a reader of its contents that is only valid until fn returns.
+// Expand returns the first error fn returns.
+type Expander interface {
+	Match(path string) bool
+	Expand(r io.ReaderAt, size int64, fn func(m Member, contents io.Reader) error) error
+}
+
+// ArchiveExpanders returns the Expanders of the archive formats the package
+// knows: tar, gzip-compressed tar and zip. They clean the names of members, as
+// path.Clean does, and fail with an error matching ErrMemberPath for a member
+// whose name escapes the archive.
+func ArchiveExpanders() []Expander {
+	return []Expander{TarExpander{}, TarGzExpander{}, ZipExpander{}}
+}
+
+// TarExpander expands tar archives, the files whose name ends in ".tar".
+type TarExpander struct{}
+
+func (TarExpander) Match(path string) bool { return hasSuffixFold(path, ".tar") }
+
+func (TarExpander) Expand(r io.ReaderAt, size int64, fn func(m Member, contents io.Reader) error) error {
+	return expandTar(io.NewSectionReader(r, 0, size), fn)
+}
+
+// TarGzExpander expands gzip-compressed tar archives, the files whose name
+// ends in ".tar.gz" or ".tgz".
+type TarGzExpander struct{}
+
+func (TarGzExpander) Match(path string) bool {
+	return hasSuffixFold(path, ".tar.gz") || hasSuffixFold(path, ".tgz")
+}
+
+func (TarGzExpander) Expand(r io.ReaderAt, size int64, fn func(m Member, contents io.Reader) error) error {
+	zr, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return expandTar(zr, fn)
+}
+
+// expandTar calls fn with each regular member of the tar archive read from r.
+func expandTar(r io.Reader, fn func(m Member, contents io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		name, err := memberName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := fn(Member{name, hdr.Size, hdr.ModTime}, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// ZipExpander expands zip archives, the files whose name ends in ".zip".
+type ZipExpander struct{}
+
+func (ZipExpander) Match(path string) bool { return hasSuffixFold(path, ".zip") }
+
+func (ZipExpander) Expand(r io.ReaderAt, size int64, fn func(m Member, contents io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		name, err := memberName(zf.Name)
+		if err != nil {
+			return err
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(Member{name, int64(zf.UncompressedSize64), zf.Modified}, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memberName returns the name of a member as its archive records it, cleaned,
+// so that names such as "./a.txt", as tar -C dir . writes them, are "a.txt",
+// or an error matching ErrMemberPath if it is absolute or lies outside the
+// archive once cleaned. Backslashes count as separators there, and a leading
+// Windows volume name as absolute, as they would be where the path is used.
+func memberName(name string) (string, error) {
+	clean := path.Clean(name)
+	slashed := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if path.IsAbs(slashed) || slashed == "." || slashed == ".." || strings.HasPrefix(slashed, "../") ||
+		(len(slashed) >= 2 && slashed[1] == ':') {
+		return "", fmt.Errorf("%w: %q", ErrMemberPath, name)
+	}
+	return clean, nil
+}
+
+// hasSuffixFold reports whether path ends in suffix, ignoring case.
+func hasSuffixFold(path, suffix string) bool {
+	return len(path) >= len(suffix) && strings.EqualFold(path[len(path)-len(suffix):], suffix)
+}
+
+// expander returns the first of h.Expanders that matches the file f, or nil if
+// f is to be digested as it is, as are files digested from their metadata and
+// symbolic links digested from their target.
+func (h *Hasher) expander(f file) Expander {
+	if len(h.Expanders) == 0 || f.special || h.MetadataDigest || h.StructureOnly ||
+		(f.symlink && h.SymlinkMode == SymlinkHashTarget) {
+		return nil
+	}
+	for _, e := range h.Expanders {
+		if e.Match(f.path) {
+			return e
+		}
+	}
+	return nil
+}
+
+// expand returns the results of the members of the container file f, as e
+// lists them, each digested with h.Hash for the run j. The results are never
+// nil, so that a container without members is told apart from a file.
+func (h *Hasher) expand(e Expander, f file, j *job, buf []byte) ([]Result, error) {
+	if err := h.waitFile(j); err != nil {
+		return nil, err
+	}
+
+	members := []Result{}
+	err := h.expandFile(e, f.path, f.info.Size(), func(m Member, contents io.Reader) error {
+		d := h.newHash()
+		n, err := io.CopyBuffer(d, h.pace(contents, j), buf)
+		if err != nil {
+			return err
+		}
+		members = append(members, Result{
+			Path:    f.path + MemberSep + m.Name,
+			Size:    n,
+			ModTime: m.ModTime,
+			Sum:     d.Sum(nil),
+			Algo:    hashName(h.Hash),
+			seq:     f.seq,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// expandFile opens the container file at path, of the given size, and calls
+// fn with each of its members, as e lists them.
+func (h *Hasher) expandFile(e Expander, path string, size int64, fn func(m Member, contents io.Reader) error) error {
+	rc, err := h.open(path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// Expanders need random access, which a reader from h.OpenFunc may
+	// not offer, in which case the container is read into memory.
+	ra, ok := rc.(io.ReaderAt)
+	if !ok {
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return pathError("read", path, err)
+		}
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	if err := e.Expand(ra, size, fn); err != nil {
+		return &os.PathError{Op: "expand", Path: path, Err: err}
+	}
+	return nil
+}
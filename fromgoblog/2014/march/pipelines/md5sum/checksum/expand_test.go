@@ -0,0 +1,90 @@
+package checksum
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// tarOf returns a tar archive of members, by name, written in the order given.
+func tarOf(t testing.TB, members ...[2]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m[0], Mode: 0644, Size: int64(len(m[1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(m[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+var memberNameTests = []struct {
+	name string
+	want string // empty if the name escapes the archive
+}{
+	{"a.txt", "a.txt"},
+	{"./a.txt", "a.txt"},
+	{"dir//b.txt", "dir/b.txt"},
+	{"dir/../c.txt", "c.txt"},
+	{"dir/./d", "dir/d"},
+	{"..a.txt", "..a.txt"},
+	{"../x", ""},
+	{"a/../../x", ""},
+	{"/etc/x", ""},
+	{`..\x`, ""},
+	{`\x`, ""},
+	{"C:/x", ""},
+	{"..", ""},
+}
+
+// TestArchiveMemberNames checks that the tar and zip expanders clean the names
+// of members alike, and reject those that escape the archive, and that a run
+// with Expanders fails on such an archive rather than report the member.
+func TestArchiveMemberNames(t *testing.T) {
+	formats := []struct {
+		name    string
+		e       Expander
+		archive func(t testing.TB, members ...[2]string) string
+	}{
+		{"tar", TarExpander{}, tarOf},
+		{"zip", ZipExpander{}, zipOf},
+	}
+	for _, f := range formats {
+		for _, tt := range memberNameTests {
+			a := f.archive(t, [2]string{tt.name, "content"})
+			var got []string
+			err := f.e.Expand(strings.NewReader(a), int64(len(a)), func(m Member, contents io.Reader) error {
+				got = append(got, m.Name)
+				return nil
+			})
+			switch {
+			case tt.want == "" && !errors.Is(err, ErrMemberPath):
+				t.Errorf("%s: Expand of %q = %v, %v, want an error matching ErrMemberPath", f.name, tt.name, got, err)
+			case tt.want != "" && err != nil:
+				t.Errorf("%s: Expand of %q: %v", f.name, tt.name, err)
+			case tt.want != "" && (len(got) != 1 || got[0] != tt.want):
+				t.Errorf("%s: Expand of %q named %q, want %q", f.name, tt.name, got, tt.want)
+			}
+		}
+
+		root := writeTree(t, map[string]string{
+			"ok.txt":         "ok",
+			"evil." + f.name: f.archive(t, [2]string{"a.txt", "a"}, [2]string{"../../etc/x", "x"}),
+		})
+		h := &Hasher{Expanders: ArchiveExpanders()}
+		if m, err := h.MD5All(root); !errors.Is(err, ErrMemberPath) {
+			t.Errorf("%s: MD5All = %v, %v, want an error matching ErrMemberPath", f.name, m, err)
+		}
+	}
+}
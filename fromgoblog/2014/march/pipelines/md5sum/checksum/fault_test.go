@@ -0,0 +1,141 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jo12bar/gosandbox/fromgoblog/2014/march/pipelines/md5sum/checksum/faultfs"
+)
+
+// faultTree is the tree the fault injection tests read.
+var faultTree = map[string]string{
+	"a.txt":     "a",
+	"b.txt":     strings.Repeat("b", 5000),
+	"sub/c.txt": "c",
+}
+
+// TestVanishedFile checks that a file that vanishes between the walk finding
+// it and a digester opening it fails the run with an error naming it, whether
+// it was removed or its open fails as it would have.
+func TestVanishedFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(h *Hasher, path string)
+	}{
+		{"removed", func(h *Hasher, path string) {
+			h.WalkFunc = func(p string, info os.FileInfo) (string, bool) {
+				if p == path {
+					if err := os.Remove(p); err != nil {
+						t.Error(err)
+					}
+				}
+				return "", true
+			}
+		}},
+		{"faultfs", func(h *Hasher, path string) {
+			h.OpenFunc = faultOpen(path, faultfs.Fault{OpenErr: os.ErrNotExist})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeTree(t, faultTree)
+			path := filepath.Join(root, "b.txt")
+			h := new(Hasher)
+			tt.setup(h, path)
+
+			_, err := h.MD5All(root)
+			var pe *os.PathError
+			if !errors.Is(err, os.ErrNotExist) || !errors.As(err, &pe) || pe.Path != path {
+				t.Errorf("MD5All = %v, want a not-exist error for %s", err, path)
+			}
+		})
+	}
+}
+
+// TestSlowReads checks that files read slowly and a few bytes at a time are
+// digested whole, and that a run canceled while one is read still stops.
+func TestSlowReads(t *testing.T) {
+	root := writeTree(t, faultTree)
+	path := filepath.Join(root, "b.txt")
+	fault := faultfs.Fault{Delay: time.Millisecond, MaxRead: 1000}
+
+	h := &Hasher{OpenFunc: faultOpen(path, fault)}
+	m, err := h.MD5All(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := md5Of(faultTree["b.txt"]); m[path] != want {
+		t.Errorf("sum of b.txt = %x, want %x", m[path], want)
+	}
+
+	// The file takes a second to read, but no later file is read once the
+	// run is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	fault = faultfs.Fault{Delay: 200 * time.Millisecond, MaxRead: 1000}
+	fsys := faultfs.New()
+	fsys.Set(path, fault)
+	h = &Hasher{
+		OpenFunc: func(p string) (io.ReadCloser, error) {
+			if p == path {
+				cancel()
+			}
+			return fsys.Open(p)
+		},
+		Workers: 1,
+	}
+	start := time.Now()
+	if _, err := h.Run(ctx, root); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("canceled Run took %v", elapsed)
+	}
+	if n := fsys.Opens(filepath.Join(root, "sub", "c.txt")); n != 0 {
+		t.Errorf("sub/c.txt opened %d times after the cancel", n)
+	}
+}
+
+// TestKeepGoing checks that, under KeepGoing, the files that vanish or fail
+// part way through are left out of MD5All and listed in ReadErrors, sorted by
+// path, while the others are still digested.
+func TestKeepGoing(t *testing.T) {
+	root := writeTree(t, faultTree)
+	vanished := filepath.Join(root, "a.txt")
+	failed := filepath.Join(root, "b.txt")
+	readErr := errors.New("device lost")
+	fsys := faultfs.New()
+	fsys.Set(vanished, faultfs.Fault{OpenErr: os.ErrNotExist})
+	fsys.Set(failed, faultfs.Fault{ReadErr: readErr, After: 1000, MaxRead: 300})
+
+	h := &Hasher{OpenFunc: fsys.Open}
+	if _, err := h.MD5All(root); err == nil {
+		t.Fatal("MD5All succeeded under FailFast")
+	}
+
+	h.ErrorPolicy = KeepGoing
+	m, err := h.MD5All(root)
+	var errs ReadErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("MD5All = %v, want ReadErrors", err)
+	}
+	if len(errs) != 2 || errs[0].Path != vanished || errs[1].Path != failed {
+		t.Fatalf("ReadErrors = %v, want %s then %s", errs, vanished, failed)
+	}
+	if !errors.Is(errs[0], os.ErrNotExist) || !errors.Is(errs[1], readErr) {
+		t.Errorf("ReadErrors = %v, want a not-exist and a read error", errs)
+	}
+	if !errors.Is(err, os.ErrNotExist) || !errors.Is(err, readErr) {
+		t.Errorf("ReadErrors does not unwrap to each error: %v", err)
+	}
+
+	c := filepath.Join(root, "sub", "c.txt")
+	if len(m) != 1 || m[c] != md5Of("c") {
+		t.Errorf("MD5All returned %v, want only the sum of sub/c.txt", m)
+	}
+}
@@ -0,0 +1,136 @@
+// Package faultfs opens files for a checksum.Hasher while injecting the I/O
+// failures that are hard to reproduce with a real filesystem: files that
+// cannot be opened, reads that fail part way through, slow reads and short
+// reads. It is meant for exercising the error handling of programs built on
+// package checksum:
+//
+//	fsys := faultfs.New()
+//	fsys.Set("tree/b.txt", faultfs.Fault{ReadErr: syscall.EIO, After: 100})
+//	h := &checksum.Hasher{OpenFunc: fsys.Open}
+package faultfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Fault describes how opening or reading a file misbehaves. The zero Fault
+// lets the file be read as usual.
+type Fault struct {
+	// OpenErr, if non-nil, is returned by Open instead of the file, such
+	// as os.ErrNotExist for a file that vanished after the walk saw it.
+	OpenErr error
+
+	// ReadErr, if non-nil, is returned by Read once After bytes of the
+	// file have been read.
+	ReadErr error
+	After   int64
+
+	// Delay is how long every Read waits before reading.
+	Delay time.Duration
+
+	// MaxRead, if positive, is the most bytes a single Read returns.
+	MaxRead int
+
+	// Times, if positive, is the number of opens the fault applies to;
+	// later opens of the file succeed as usual, as after a transient
+	// failure. Otherwise the fault applies to every open.
+	Times int
+}
+
+// An FS opens files, applying the faults set for their path. It is safe for
+// concurrent use.
+type FS struct {
+	// OpenFunc opens the files for real. If nil, os.Open is used.
+	OpenFunc func(path string) (io.ReadCloser, error)
+
+	mu     sync.Mutex
+	faults map[string]Fault
+	opens  map[string]int
+}
+
+// New returns an FS without faults.
+func New() *FS {
+	return &FS{faults: make(map[string]Fault), opens: make(map[string]int)}
+}
+
+// Set sets the fault of the file at path, replacing any set before, and
+// resets its count of opens. Paths are compared once cleaned.
+func (fsys *FS) Set(path string, f Fault) {
+	path = filepath.Clean(path)
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.faults[path] = f
+	delete(fsys.opens, path)
+}
+
+// Opens returns the number of times Open was called for the file at path,
+// such as to check how often a failing file was retried.
+func (fsys *FS) Opens(path string) int {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	return fsys.opens[filepath.Clean(path)]
+}
+
+// Open opens the file at path, applying its fault. It has the signature of
+// checksum.Hasher.OpenFunc.
+func (fsys *FS) Open(path string) (io.ReadCloser, error) {
+	clean := filepath.Clean(path)
+	fsys.mu.Lock()
+	fsys.opens[clean]++
+	f, ok := fsys.faults[clean]
+	if ok && f.Times > 0 && fsys.opens[clean] > f.Times {
+		ok = false
+	}
+	fsys.mu.Unlock()
+
+	if ok && f.OpenErr != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: f.OpenErr}
+	}
+
+	open := fsys.OpenFunc
+	if open == nil {
+		open = func(path string) (io.ReadCloser, error) { return os.Open(path) }
+	}
+	rc, err := open(path)
+	if err != nil || !ok {
+		return rc, err
+	}
+	return &reader{rc: rc, path: path, f: f}, nil
+}
+
+// reader reads a file, applying its fault.
+type reader struct {
+	rc   io.ReadCloser
+	path string
+	f    Fault
+	n    int64 // bytes read so far
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.f.Delay > 0 {
+		time.Sleep(r.f.Delay)
+	}
+	if r.f.ReadErr != nil {
+		left := r.f.After - r.n
+		if left <= 0 {
+			return 0, &os.PathError{Op: "read", Path: r.path, Err: r.f.ReadErr}
+		}
+		if int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+	if r.f.MaxRead > 0 && len(p) > r.f.MaxRead {
+		p = p[:r.f.MaxRead]
+	}
+	n, err := r.rc.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *reader) Close() error {
+	return r.rc.Close()
+}
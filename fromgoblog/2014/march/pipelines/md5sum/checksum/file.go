@@ -0,0 +1,61 @@
+package checksum
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash"
+	"os"
+	"path/filepath"
+)
+
+// SumFile returns the digest of the contents of the single file at path,
+// computed with newHash, or with md5.New if newHash is nil.
+func SumFile(path string, newHash func() hash.Hash) ([]byte, error) {
+	if newHash == nil {
+		newHash = md5.New
+	}
+	d := newHash()
+	if _, err := copyFile(d, path); err != nil {
+		return nil, err
+	}
+	return d.Sum(nil), nil
+}
+
+// VerifyFile digests the file at path with newHash, as SumFile does, and
+// reports whether the digest equals expected, such as a published checksum of
+// a download. It also returns the actual digest, so that a mismatch can be
+// reported, and the error reading the file, if any.
+func VerifyFile(path string, expected []byte, newHash func() hash.Hash) (bool, []byte, error) {
+	sum, err := SumFile(path, newHash)
+	if err != nil {
+		return false, nil, err
+	}
+	return bytes.Equal(sum, expected), sum, nil
+}
+
+// HashDir returns the digests, computed with newHash, or with md5.New if
+// newHash is nil, of the regular files directly inside dir, keyed by their
+// path, dir joined with their name, as MD5All would report them. It does not
+// descend into subdirectories, and skips symbolic links and other special
+// files. If dir cannot be listed or any file cannot be read, HashDir returns
+// an error.
+func HashDir(dir string, newHash func() hash.Hash) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, &WalkError{dir, err}
+	}
+
+	m := make(map[string][]byte)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		sum, err := SumFile(path, newHash)
+		if err != nil {
+			return nil, err
+		}
+		m[path] = sum
+	}
+	return m, nil
+}
@@ -0,0 +1,164 @@
+package checksum
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitignoreName is the name of the files Hasher.GitIgnore honors.
+const gitignoreName = ".gitignore"
+
+// An ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	pattern  string // with any leading "/", trailing "/" and "!" removed
+	negate   bool   // the pattern started with "!", and re-includes
+	dirOnly  bool   // the pattern ended with "/", and only matches directories
+	anchored bool   // the pattern holds a "/", and matches the path below dir
+}
+
+// ignoreDir holds the rules of the .gitignore file of a directory.
+type ignoreDir struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// gitignores keeps the rules of the .gitignore files of the directories the
+// walk is inside, for Hasher.GitIgnore. It is only used by the walk goroutine.
+type gitignores struct {
+	open []ignoreDir
+	fsys fs.FS // if non-nil, the file system of Hasher.FS
+}
+
+// parseGitignore returns the rules of the .gitignore file at name, or none if
+// there is no such file. The file is read from fsys, if non-nil.
+func parseGitignore(fsys fs.FS, name string) ([]ignoreRule, error) {
+	var f io.ReadCloser
+	var err error
+	if fsys != nil {
+		f, err = fsys.Open(filepath.ToSlash(name))
+	} else {
+		f, err = os.Open(name)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var r ignoreRule
+		if line[0] == '!' {
+			r.negate, line = true, line[1:]
+		} else if line[0] == '\\' {
+			// An escaped leading "#" or "!" is a literal one.
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		r.anchored = strings.Contains(line, "/")
+		r.pattern = strings.TrimPrefix(line, "/")
+		if r.pattern != "" {
+			rules = append(rules, r)
+		}
+	}
+	return rules, sc.Err()
+}
+
+// enter loads the .gitignore file of the directory at dir, once the walk
+// has decided to descend into it.
+func (g *gitignores) enter(dir string) error {
+	dir = filepath.Clean(dir)
+	rules, err := parseGitignore(g.fsys, filepath.Join(dir, gitignoreName))
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		g.open = append(g.open, ignoreDir{dir, rules})
+	}
+	return nil
+}
+
+// leave forgets the rules of every directory that path does not lie below.
+func (g *gitignores) leave(path string) {
+	path = filepath.Clean(path)
+	for len(g.open) > 0 && !inDir(path, g.open[len(g.open)-1].dir) {
+		g.open = g.open[:len(g.open)-1]
+	}
+}
+
+// ignored reports whether the file or directory at path is ignored by the
+// .gitignore files of the directories it lies below. As with git, the last
+// matching rule decides, and the rules of a deeper directory come after those
+// of the directories above it.
+func (g *gitignores) ignored(p string, isDir bool) bool {
+	p = filepath.Clean(p)
+	if isDir && filepath.Base(p) == ".git" {
+		return true
+	}
+
+	ignored := false
+	for _, d := range g.open {
+		rel, err := filepath.Rel(d.dir, p)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, r := range d.rules {
+			if r.negate == ignored && r.match(rel, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// match reports whether r matches the path rel, relative to the directory of
+// its .gitignore file and separated by slashes.
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments reports whether the elements of a path match those of a
+// pattern, each with path.Match, where a "**" element matches any number of
+// path elements, including none.
+func matchSegments(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(elems); i >= 0; i-- {
+				if matchSegments(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
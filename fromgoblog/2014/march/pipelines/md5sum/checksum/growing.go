@@ -0,0 +1,29 @@
+package checksum
+
+import "io"
+
+// capReader is an io.Reader reading the first o.limit bytes of r, of which n
+// are left, for Hasher.TruncateToWalkSize. Once they have been read, it reads
+// one more byte to find out whether r goes on, and sets o.grown if it does.
+type capReader struct {
+	r io.Reader
+	n int64
+	o *readOpts
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		var b [1]byte
+		if n, _ := io.ReadFull(c.r, b[:]); n > 0 {
+			c.o.grown = true
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,81 @@
+package checksum
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+)
+
+// WriteManifestGz writes a gzip-compressed manifest of the results received
+// from results, such as those of Stream, to w: ManifestHeader, then one
+// "sum\tpath" line per file in the order they are received, until results is
+// closed. Unlike the manifests of ScanToFile, these are not sorted and cannot
+// be compared with DiffManifestReaders. The compressed stream is flushed
+// whenever no result is ready, so that w receives each line soon after it is
+// computed.
+//
+// If a result holds an error, or a path that cannot be written to a manifest
+// (see ErrManifestPath), WriteManifestGz stops reading results and returns
+// that error; the caller must then arrange for the sender to stop,
+// e.g. by canceling the context passed to Stream. Whether it returns early or
+// not, WriteManifestGz closes the gzip stream, so that what was written is a
+// complete gzip file that ParseManifestGz can read.
+func WriteManifestGz(w io.Writer, results <-chan Result) (err error) {
+	zw := gzip.NewWriter(w)
+	bw := bufio.NewWriter(zw)
+	defer func() {
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if _, err := bw.WriteString(ManifestHeader + "\n"); err != nil {
+		return err
+	}
+
+	for {
+		var r Result
+		var ok bool
+		select {
+		case r, ok = <-results:
+		default:
+			// Nothing to write for now: push out what we have
+			// before waiting.
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			if err := zw.Flush(); err != nil {
+				return err
+			}
+			r, ok = <-results
+		}
+		if !ok {
+			return nil
+		}
+		if r.Err != nil {
+			return r.Err
+		}
+		line, err := manifestLine(r)
+		if err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(line); err != nil {
+			return err
+		}
+	}
+}
+
+// ParseManifestGz is like ParseManifest, but reads a gzip-compressed manifest,
+// as written by WriteManifestGz.
+func ParseManifestGz(r io.Reader) (map[string]Digest, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ParseManifest(zr)
+}
@@ -0,0 +1,72 @@
+package checksum
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"hash"
+)
+
+// HMACAll reads all the files in the file tree rooted at root and returns a
+// map from file path to the HMAC of the file's contents under key, computed
+// with newHash, such as sha256.New, or with md5.New if newHash is nil. Unlike
+// a plain checksum, an HMAC can only be computed by someone holding the key,
+// so a manifest of HMACs cannot be forged to match tampered files. Changing
+// the key changes every HMAC, which invalidates all the manifests made with
+// the old one. If the directory walk fails or any read operation fails,
+// HMACAll returns an error.
+//
+// HMACAll uses a zero Hasher; see Hasher.HMACAll.
+func HMACAll(root string, key []byte, newHash func() hash.Hash) (map[string]Digest, error) {
+	return new(Hasher).HMACAll(root, key, newHash)
+}
+
+// HMACAll is like the package-level HMACAll, but reads the files through h.
+// h.Hash is not used.
+func (h *Hasher) HMACAll(root string, key []byte, newHash func() hash.Hash) (map[string]Digest, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	m := make(map[string]Digest)
+	err := h.hmacHasher(key, newHash).collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		m[r.Path] = r.Sum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// VerifyHMAC is like Verify, but checks the tree against manifest, a map of
+// HMACs as returned by HMACAll with the same key and newHash. As Verify does
+// by default, it compares digests in constant time, so that timing reveals
+// nothing about the expected HMACs; a Hasher.CompareFunc replacing it should
+// do the same.
+//
+// VerifyHMAC uses a zero Hasher; see Hasher.VerifyHMAC.
+func VerifyHMAC(root string, key []byte, newHash func() hash.Hash, manifest map[string]Digest) ([]Mismatch, error) {
+	return new(Hasher).VerifyHMAC(root, key, newHash, manifest)
+}
+
+// VerifyHMAC is like the package-level VerifyHMAC, but reads the files through
+// h. h.Hash is not used.
+func (h *Hasher) VerifyHMAC(root string, key []byte, newHash func() hash.Hash, manifest map[string]Digest) ([]Mismatch, error) {
+	return h.hmacHasher(key, newHash).Verify(root, manifest)
+}
+
+// hmacHasher returns a copy of h computing HMACs under key with newHash, or
+// with md5.New if newHash is nil.
+func (h *Hasher) hmacHasher(key []byte, newHash func() hash.Hash) *Hasher {
+	if newHash == nil {
+		newHash = md5.New
+	}
+	key = append([]byte(nil), key...)
+
+	hh := *h
+	hh.Hash = func() hash.Hash { return hmac.New(newHash, key) }
+	return &hh
+}
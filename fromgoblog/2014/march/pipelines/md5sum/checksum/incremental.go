@@ -0,0 +1,189 @@
+package checksum
+
+import (
+	"bytes"
+	"hash"
+	"path/filepath"
+	"sync"
+)
+
+// An IncrementalTreeRoot keeps the tree root of a set of files up to date as
+// they are added, changed and removed, such as by a watcher reporting changes
+// to a tree, without scanning the tree again. Its root is always the one
+// TreeRoot would return for a tree holding those files with those digests.
+// Paths are relative to the root of the tree, as TreeRoot takes them, and are
+// given forward slashes. It holds the treap a tree root is folded along, so
+// adding, updating or removing a file only hashes again the nodes on its way
+// to the top, about log n of them for n files, and Root costs nothing more.
+// An IncrementalTreeRoot is safe for concurrent use.
+type IncrementalTreeRoot struct {
+	h *Hasher
+
+	mu  sync.Mutex
+	top *rootNode
+	n   int // number of files
+}
+
+// NewIncrementalTreeRoot returns an IncrementalTreeRoot of no files that
+// computes its root with newHash, or with md5.New if newHash is nil. The
+// digests given to it must be computed with the same hash for its root to be
+// that of TreeRoot.
+func NewIncrementalTreeRoot(newHash func() hash.Hash) *IncrementalTreeRoot {
+	return &IncrementalTreeRoot{h: &Hasher{Hash: newHash}}
+}
+
+// TrackTreeRoot reads all the files in the file tree rooted at root through h,
+// as TreeRoot does, and returns an IncrementalTreeRoot of them, computing its
+// root with h.Hash, to be kept up to date from then on.
+func (h *Hasher) TrackTreeRoot(root string) (*IncrementalTreeRoot, error) {
+	entries, err := h.treeEntries(root)
+	if err != nil {
+		return nil, err
+	}
+	th := &Hasher{Hash: h.Hash}
+	return &IncrementalTreeRoot{h: th, top: th.buildNodes(entries), n: len(entries)}, nil
+}
+
+// Add adds the file at path with the given digest, or replaces its digest if
+// it was already there.
+func (t *IncrementalTreeRoot) Add(path string, digest []byte) {
+	path = filepath.ToSlash(path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var found bool
+	t.top, found, _ = t.h.setNode(t.top, path, digest, true)
+	if !found {
+		t.n++
+	}
+}
+
+// Update replaces the digest of the file at path, and reports whether it was
+// there. A file that was not there is not added.
+func (t *IncrementalTreeRoot) Update(path string, digest []byte) bool {
+	path = filepath.ToSlash(path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var found bool
+	t.top, found, _ = t.h.setNode(t.top, path, digest, false)
+	return found
+}
+
+// Remove removes the file at path, and reports whether it was there.
+func (t *IncrementalTreeRoot) Remove(path string) bool {
+	path = filepath.ToSlash(path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var found bool
+	t.top, found = t.h.removeNode(t.top, path)
+	if found {
+		t.n--
+	}
+	return found
+}
+
+// Len returns the number of files of t.
+func (t *IncrementalTreeRoot) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.n
+}
+
+// Root returns the tree root of the files of t.
+func (t *IncrementalTreeRoot) Root() Digest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(Digest(nil), t.h.nodesRoot(t.top)...)
+}
+
+// setNode replaces the digest of the file at path in the treap topped by n
+// with digest, or adds the file if add is set and it is not there. It returns
+// the new top of the treap, whether the file was there, and whether anything
+// changed. Only the nodes on the way to the file are hashed again.
+func (h *Hasher) setNode(n *rootNode, path string, digest []byte, add bool) (*rootNode, bool, bool) {
+	if n == nil {
+		if !add {
+			return nil, false, false
+		}
+		return h.newNode(treeEntry{path, append(Digest(nil), digest...)}), false, true
+	}
+
+	var found, changed bool
+	switch {
+	case path == n.path:
+		if bytes.Equal(n.sum, digest) {
+			return n, true, false
+		}
+		n.sum = append(Digest(nil), digest...)
+		h.hashNode(n)
+		return n, true, true
+	case path < n.path:
+		n.left, found, changed = h.setNode(n.left, path, digest, add)
+		if changed && n.left.above(n) {
+			// A file added below n belongs above it.
+			l := n.left
+			n.left = l.right
+			h.hashNode(n)
+			l.right = n
+			h.hashNode(l)
+			return l, found, changed
+		}
+	default:
+		n.right, found, changed = h.setNode(n.right, path, digest, add)
+		if changed && n.right.above(n) {
+			r := n.right
+			n.right = r.left
+			h.hashNode(n)
+			r.left = n
+			h.hashNode(r)
+			return r, found, changed
+		}
+	}
+	if changed {
+		h.hashNode(n)
+	}
+	return n, found, changed
+}
+
+// removeNode removes the file at path from the treap topped by n, and returns
+// the new top of the treap and whether the file was there.
+func (h *Hasher) removeNode(n *rootNode, path string) (*rootNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	var found bool
+	switch {
+	case path == n.path:
+		return h.joinNodes(n.left, n.right), true
+	case path < n.path:
+		n.left, found = h.removeNode(n.left, path)
+	default:
+		n.right, found = h.removeNode(n.right, path)
+	}
+	if found {
+		h.hashNode(n)
+	}
+	return n, found
+}
+
+// joinNodes returns the top of the treap holding the files of the treaps
+// topped by a and b, either of which may be nil, all the paths of a being
+// below those of b.
+func (h *Hasher) joinNodes(a, b *rootNode) *rootNode {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.above(b):
+		a.right = h.joinNodes(a.right, b)
+		h.hashNode(a)
+		return a
+	}
+	b.left = h.joinNodes(a, b.left)
+	h.hashNode(b)
+	return b
+}
@@ -0,0 +1,114 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"math/rand"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+// byteHash is MD5 cut down to its first byte, so that the priorities of the
+// paths of a treap often tie.
+type byteHash struct{ hash.Hash }
+
+func (h byteHash) Size() int           { return 1 }
+func (h byteHash) Sum(b []byte) []byte { return append(b, h.Hash.Sum(nil)[0]) }
+
+func newByteHash() hash.Hash { return byteHash{md5.New()} }
+
+// sortedEntries returns the files of sums, by path, sorted by path.
+func sortedEntries(sums map[string]Digest) []treeEntry {
+	entries := make([]treeEntry, 0, len(sums))
+	for path, sum := range sums {
+		entries = append(entries, treeEntry{path, sum})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries
+}
+
+func TestIncrementalTreeRoot(t *testing.T) {
+	hashes := []struct {
+		name    string
+		newHash func() hash.Hash
+	}{
+		{"md5", nil},
+		{"sha256", sha256.New},
+		{"one byte", newByteHash},
+	}
+	for _, ht := range hashes {
+		t.Run(ht.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			h := &Hasher{Hash: ht.newHash}
+			it := NewIncrementalTreeRoot(ht.newHash)
+			sums := make(map[string]Digest)
+			for i := 0; i < 3000; i++ {
+				path := fmt.Sprintf("d%d/f%d", rng.Intn(8), rng.Intn(40))
+				sum := Digest{byte(rng.Intn(4))}
+				_, had := sums[path]
+				switch op := rng.Intn(3); op {
+				case 0:
+					it.Add(path, sum)
+					sums[path] = sum
+				case 1:
+					if it.Update(path, sum) != had {
+						t.Fatalf("op %d: Update(%s) = %v", i, path, !had)
+					}
+					if had {
+						sums[path] = sum
+					}
+				case 2:
+					if it.Remove(path) != had {
+						t.Fatalf("op %d: Remove(%s) = %v", i, path, !had)
+					}
+					delete(sums, path)
+				}
+
+				if it.Len() != len(sums) {
+					t.Fatalf("op %d: Len = %d, want %d", i, it.Len(), len(sums))
+				}
+				want := h.namesRoot(sortedEntries(sums))
+				if i%250 == 0 {
+					want = foldRoot(h.newHash, sums)
+				}
+				if got := it.Root(); string(got) != string(want) {
+					t.Fatalf("op %d: Root = %x, want %x", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackTreeRoot(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for path, content := range treeFixture {
+		fsys[path] = mapFile(content)
+	}
+	h := &Hasher{FS: fsys}
+	it, err := h.TrackTreeRoot(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the tree, and the IncrementalTreeRoot along with it.
+	fsys["a/b.txt"] = mapFile("changed")
+	it.Update("a/b.txt", sumOf("changed"))
+	delete(fsys, "a0.txt")
+	it.Remove("a0.txt")
+	fsys["c/new.txt"] = mapFile("new")
+	it.Add("c/new.txt", sumOf("new"))
+
+	want, err := h.TreeRoot(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := it.Root(); string(got) != string(want) {
+		t.Errorf("Root = %x, want TreeRoot %x", got, want)
+	}
+	if it.Len() != len(treeFixture) {
+		t.Errorf("Len = %d, want %d", it.Len(), len(treeFixture))
+	}
+}
@@ -0,0 +1,47 @@
+package checksum
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// WriteJSON writes states to w as a single indented JSON array, with each
+// digest in hexadecimal.
+func WriteJSON(w io.Writer, states []FileState) error {
+	if states == nil {
+		states = []FileState{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(states)
+}
+
+// WriteNDJSON writes each result received from results to w as soon as it
+// arrives, as a JSON object on a line of its own, such as
+//
+//	{"path":"a/b.txt","size":42,"modTime":"2014-03-13T10:00:00Z","hash":"0cc175b9c0f1b6a831c399e269772661","algo":"md5"}
+//
+// for piping into jq or a log collector without holding every result in
+// memory. A result that failed has an "error" holding the message of its Err
+// instead of a "hash". WriteNDJSON returns nil once results is closed,
+// ctx.Err() if ctx is canceled first, or the first error writing to w. In the
+// last two cases the sender, such as Stream, must be told to stop, typically
+// by canceling the context it was given.
+func WriteNDJSON(ctx context.Context, w io.Writer, results <-chan Result) error {
+	enc := NewNDJSONEncoder(w)
+	for {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				return enc.Close()
+			}
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package checksum
+
+import (
+	"container/list"
+	"sync"
+)
+
+// linkCacheSize is the number of inodes whose digest a run remembers for
+// Hasher.DedupHardLinks.
+const linkCacheSize = 4096
+
+// fileID identifies a file on disk, whatever the path it is found under.
+type fileID struct {
+	dev, ino uint64
+}
+
+// linkCache is a bounded cache of the digests of files with several hard
+// links, keyed by inode, so that each is only read once per run. The least
+// recently used inode makes room for new ones.
+type linkCache struct {
+	mu  sync.Mutex
+	max int
+	ll  *list.List // of *linkEntry, most recently used first
+	m   map[fileID]*list.Element
+}
+
+// linkEntry is the digest of an inode in a linkCache.
+type linkEntry struct {
+	id  fileID
+	sum Digest
+}
+
+func newLinkCache(max int) *linkCache {
+	return &linkCache{max: max, ll: list.New(), m: make(map[fileID]*list.Element)}
+}
+
+// get returns the digest of the inode id, if it is cached.
+func (c *linkCache) get(id fileID) (Digest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.m[id]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*linkEntry).sum, true
+}
+
+// put caches sum as the digest of the inode id.
+func (c *linkCache) put(id fileID, sum Digest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.m[id]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.m[id] = c.ll.PushFront(&linkEntry{id, sum})
+	if c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.m, oldest.Value.(*linkEntry).id)
+	}
+}
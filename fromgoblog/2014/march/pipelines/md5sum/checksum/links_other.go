@@ -0,0 +1,22 @@
+//go:build !unix
+
+package checksum
+
+import "os"
+
+// hardLinked reports false, so that every file is read, since inodes are only
+// known on Unix.
+func hardLinked(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// inode reports false, since inodes are only known on Unix.
+func inode(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// fileDevice returns 0, putting every file on the same device, since devices
+// are only known on Unix.
+func fileDevice(info os.FileInfo) uint64 {
+	return 0
+}
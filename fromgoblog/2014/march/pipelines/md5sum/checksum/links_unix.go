@@ -0,0 +1,36 @@
+//go:build unix
+
+package checksum
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardLinked returns the inode of the file described by info, and whether
+// the file has more than one hard link.
+func hardLinked(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || uint64(st.Nlink) < 2 {
+		return fileID{}, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+// inode returns the identity of the file described by info, whatever the
+// number of its hard links.
+func inode(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+// fileDevice returns the device holding the file described by info.
+func fileDevice(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev)
+	}
+	return 0
+}
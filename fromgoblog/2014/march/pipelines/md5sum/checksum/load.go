@@ -0,0 +1,121 @@
+package checksum
+
+import (
+	"runtime"
+	"time"
+)
+
+// loadInterval is how often a LoadAware run reads the load average, about as
+// often as the kernel updates it.
+const loadInterval = 5 * time.Second
+
+// loadThrottle limits the number of digesters of a LoadAware run reading files
+// at once. Each of the n digesters takes a place in sem before reading a file,
+// and a controller goroutine takes places of its own, held places, to leave
+// fewer to the digesters while the load is high.
+type loadThrottle struct {
+	sem  chan struct{}
+	held int
+	quit chan struct{}
+}
+
+// startLoadThrottle returns the throttle of n digesters and starts its
+// controller, which runs until done is closed or stop is called. It returns
+// nil if the load average cannot be read, leaving all n digesters to run.
+func startLoadThrottle(done <-chan struct{}, n int) *loadThrottle {
+	load, ok := loadAverage()
+	if !ok {
+		return nil
+	}
+
+	t := &loadThrottle{sem: make(chan struct{}, n), quit: make(chan struct{})}
+	t.adjust(loadLimit(n, load, runtime.NumCPU()))
+	go t.control(done, n)
+	return t
+}
+
+// control reads the load average every loadInterval, and adjusts the number of
+// digesters t lets run to it.
+func (t *loadThrottle) control(done <-chan struct{}, n int) {
+	tick := time.NewTicker(loadInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+		case <-t.quit:
+			return
+		case <-done:
+			return
+		}
+		if load, ok := loadAverage(); ok {
+			t.adjust(loadLimit(n, load, runtime.NumCPU()))
+		}
+	}
+}
+
+// adjust holds places in t so as to leave limit of them to the digesters. It
+// does not wait for busy digesters to finish their file: the places they hold
+// are taken on a later adjustment.
+func (t *loadThrottle) adjust(limit int) {
+	want := cap(t.sem) - limit
+	for t.held < want {
+		select {
+		case t.sem <- struct{}{}:
+			t.held++
+		default:
+			return
+		}
+	}
+	for t.held > want {
+		<-t.sem
+		t.held--
+	}
+}
+
+// tryAcquire takes a place for a digester to read a file, and reports whether
+// one was free.
+func (t *loadThrottle) tryAcquire() bool {
+	select {
+	case t.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire waits for a place for a digester to read a file. It reports false if
+// done was closed first.
+func (t *loadThrottle) acquire(done <-chan struct{}) bool {
+	select {
+	case t.sem <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// release gives back the place of a digester done reading a file.
+func (t *loadThrottle) release() {
+	<-t.sem
+}
+
+// stop stops the controller of t, once the digesters are all done.
+func (t *loadThrottle) stop() {
+	close(t.quit)
+}
+
+// loadLimit returns how many of n digesters may read files at once given a
+// load average of load on cpus CPUs: all of them while load does not exceed
+// cpus, and a share of them inversely proportional to load otherwise, but at
+// least one.
+func loadLimit(n int, load float64, cpus int) int {
+	if load <= float64(cpus) {
+		return n
+	}
+	limit := int(float64(n) * float64(cpus) / load)
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
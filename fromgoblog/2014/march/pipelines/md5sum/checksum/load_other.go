@@ -0,0 +1,8 @@
+//go:build !unix
+
+package checksum
+
+// loadAverage reports false, since the load average is only read on Unix.
+func loadAverage() (float64, bool) {
+	return 0, false
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package checksum
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// loadAverage returns the 1-minute load average, as read from /proc/loadavg,
+// and reports false if it cannot be read.
+func loadAverage() (float64, bool) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return load, err == nil
+}
@@ -0,0 +1,39 @@
+package checksum
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLocked is matched, with errors.Is, by the error reading a file that
+// another process holds locked, when Hasher.SkipLocked is not set.
+var ErrLocked = errors.New("checksum: file locked by another process")
+
+// lockedError is the error reading a locked file. It unwraps to the error of
+// the read, so errors.As still finds the *os.PathError.
+type lockedError struct {
+	err error
+}
+
+func (e *lockedError) Error() string        { return e.err.Error() }
+func (e *lockedError) Unwrap() error        { return e.err }
+func (e *lockedError) Is(target error) bool { return target == ErrLocked }
+
+// retryLocked handles the error err from digesting the file at path, with o,
+// which another process holds locked. It tries once more after h.LockRetry, if
+// set, and otherwise reports the file as locked if h.SkipLocked is set, or
+// returns an error matching ErrLocked.
+func (h *Hasher) retryLocked(path string, o *readOpts, j *job, buf []byte, err error) (Digest, bool, error) {
+	if h.LockRetry > 0 {
+		time.Sleep(h.LockRetry)
+		sum, rerr := h.sum(path, o, j, buf)
+		if !isLocked(rerr) {
+			return sum, false, rerr
+		}
+		err = rerr
+	}
+	if h.SkipLocked {
+		return nil, true, nil
+	}
+	return nil, false, &lockedError{err}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package checksum
+
+// isLocked reports whether err comes from reading a file another process
+// holds locked. Files are never locked against reading outside Windows.
+func isLocked(err error) bool {
+	return false
+}
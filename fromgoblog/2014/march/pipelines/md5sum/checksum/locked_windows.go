@@ -0,0 +1,19 @@
+package checksum
+
+import (
+	"errors"
+	"syscall"
+)
+
+// The Windows errors reported for a file another process holds open without
+// sharing it, or holds a lock on.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLocked reports whether err comes from reading a file another process
+// holds locked.
+func isLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -0,0 +1,62 @@
+package checksum
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jo12bar/gosandbox/fromgoblog/2014/march/pipelines/md5sum/checksum/faultfs"
+)
+
+// TestLockRetry checks that a locked file is tried once more after LockRetry,
+// and then read, skipped or failed as SkipLocked says.
+func TestLockRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		times      int // opens the lock lasts for, or 0 for all
+		lockRetry  time.Duration
+		skipLocked bool
+		wantOpens  int
+		wantErr    bool
+		wantLocked bool
+	}{
+		{name: "no retry", times: 1, wantOpens: 1, wantErr: true},
+		{name: "retry succeeds", times: 1, lockRetry: time.Millisecond, wantOpens: 2},
+		{name: "retry fails", times: 2, lockRetry: time.Millisecond, wantOpens: 2, wantErr: true},
+		{name: "retry fails, skipped", lockRetry: time.Millisecond, skipLocked: true, wantOpens: 2, wantLocked: true},
+		{name: "skipped", skipLocked: true, wantOpens: 1, wantLocked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeTree(t, map[string]string{"a.txt": "a", "locked.txt": "locked"})
+			path := filepath.Join(root, "locked.txt")
+			fsys := faultfs.New()
+			fsys.Set(path, faultfs.Fault{OpenErr: errorSharingViolation, Times: tt.times})
+			var st Stats
+			h := &Hasher{OpenFunc: fsys.Open, LockRetry: tt.lockRetry, SkipLocked: tt.skipLocked, Stats: &st}
+
+			m, err := h.MD5All(root)
+			if tt.wantErr {
+				if !errors.Is(err, ErrLocked) {
+					t.Errorf("MD5All = %v, want ErrLocked", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if _, ok := m[path]; ok == tt.wantLocked {
+				t.Errorf("sum of locked.txt returned: %v, want %v", ok, !tt.wantLocked)
+			}
+			if n := fsys.Opens(path); n != tt.wantOpens {
+				t.Errorf("locked.txt opened %d times, want %d", n, tt.wantOpens)
+			}
+			var wantLocked []string
+			if tt.wantLocked {
+				wantLocked = []string{path}
+			}
+			if !tt.wantErr && !reflect.DeepEqual(st.Locked, wantLocked) {
+				t.Errorf("Stats.Locked = %q, want %q", st.Locked, wantLocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package checksum
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrManifestPath is matched, with errors.Is, by the error a manifest writer
+// reports for a file whose path holds a newline or a carriage return, as its
+// "sum\tpath" line could not be read back. Such a file can be renamed with
+// Hasher.KeyFunc, or written in the format of NewCoreutilsEncoder, which
+// escapes them.
+var ErrManifestPath = errors.New("checksum: path cannot be written to a manifest")
+
+// runSize is the number of manifest lines ScanToFile holds in memory before
+// sorting them and spilling them to a temporary run file.
+const runSize = 1 << 16
+
+// ScanToFile reads all the files in the file tree rooted at root and writes a
+// manifest of their MD5 sums to manifestPath, beginning with ManifestHeader
+// and followed by one "sum\tpath" line per file, sorted by path in byte order,
+// as DiffManifestReaders requires. Unlike MD5All, ScanToFile never holds more
+// than a fixed number of results in memory: completed results are spilled to
+// sorted temporary run files, which are then merged into the final manifest.
+//
+// The manifest and the temporary files are never hashed themselves, even if
+// they lie inside the tree. The manifest is only put in place once it is
+// complete. If the walk fails, any read operation fails, a path holds a line
+// break (see ErrManifestPath), or ctx is canceled, ScanToFile returns an error
+// and leaves any existing file at manifestPath untouched.
+//
+// ScanToFile uses a zero Hasher; see Hasher.ScanToFile.
+func ScanToFile(ctx context.Context, root, manifestPath string) error {
+	return new(Hasher).ScanToFile(ctx, root, manifestPath)
+}
+
+// ScanToFile is like the package-level ScanToFile, but reads the files through
+// h.
+func (h *Hasher) ScanToFile(ctx context.Context, root, manifestPath string) error {
+	tmpDir, err := ioutil.TempDir(filepath.Dir(manifestPath), ".manifest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Keep the scan from reading its own output.
+	sh := *h
+	sh.ExcludePaths = append(append([]string(nil), h.ExcludePaths...), manifestPath, tmpDir)
+
+	runs, err := sh.writeRuns(ctx, root, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	out, err := ioutil.TempFile(tmpDir, "merged-")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.WriteString(out, ManifestHeader+"\n"); err != nil {
+		return err
+	}
+	if err := mergeRuns(out, runs); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), manifestPath)
+}
+
+// manifestLine formats r the way ScanToFile writes it to a manifest, or
+// returns an error matching ErrManifestPath if r.Path cannot be written there.
+// A tab in the path is kept, as the line is split at its first one, but a line
+// break would end the line early.
+func manifestLine(r Result) (string, error) {
+	if strings.ContainsAny(r.Path, "\n\r") {
+		return "", fmt.Errorf("%w: %q", ErrManifestPath, r.Path)
+	}
+	return fmt.Sprintf("%x\t%s\n", r.Sum, r.Path), nil
+}
+
+// linePath returns the path part of a manifest line.
+func linePath(line string) string {
+	if i := strings.IndexByte(line, '\t'); i >= 0 {
+		return line[i+1:]
+	}
+	return line
+}
+
+// byPath sorts manifest lines by their path.
+type byPath []string
+
+func (s byPath) Len() int           { return len(s) }
+func (s byPath) Less(i, j int) bool { return linePath(s[i]) < linePath(s[j]) }
+func (s byPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// writeRuns digests the tree rooted at root and writes the results to sorted
+// run files of at most runSize lines in dir. It returns the names of the run
+// files in the order they were written.
+func (h *Hasher) writeRuns(ctx context.Context, root, dir string) ([]string, error) {
+	// Canceling ctx on return stops the pipeline if writeRuns gives up
+	// before receiving all the values from it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var runs []string
+	lines := make([]string, 0, runSize)
+
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		sort.Sort(byPath(lines))
+
+		f, err := ioutil.TempFile(dir, "run-")
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		for _, line := range lines {
+			w.WriteString(line)
+		}
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		runs = append(runs, f.Name())
+		lines = lines[:0]
+		return nil
+	}
+
+	err := h.collect(ctx.Done(), root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		line, err := manifestLine(r)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+		if len(lines) == runSize {
+			return flush()
+		}
+		return nil
+	})
+	if errors.Is(err, ErrWalkCanceled) {
+		// Report why the walk was canceled, but keep any genuine
+		// error the walk hit before that.
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// run is a sorted run file being merged, along with its current line.
+type run struct {
+	r    *bufio.Reader
+	line string
+}
+
+// runHeap is a min-heap of runs ordered by the path of their current line.
+type runHeap []*run
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return linePath(h[i].line) < linePath(h[j].line) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// next advances r to its next line. It returns io.EOF once r is exhausted.
+func (r *run) next() error {
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	r.line = line
+	return nil
+}
+
+// mergeRuns merges the sorted run files named by runs into w, keeping only one
+// line per run in memory.
+func mergeRuns(w io.Writer, runs []string) error {
+	h := make(runHeap, 0, len(runs))
+	for _, name := range runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r := &run{r: bufio.NewReader(f)}
+		if err := r.next(); err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+
+	bw := bufio.NewWriter(w)
+	for h.Len() > 0 {
+		r := h[0]
+		if _, err := bw.WriteString(r.line); err != nil {
+			return err
+		}
+
+		if err := r.next(); err == io.EOF {
+			heap.Pop(&h)
+		} else if err != nil {
+			return err
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+
+	return bw.Flush()
+}
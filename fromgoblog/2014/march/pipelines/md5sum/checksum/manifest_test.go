@@ -0,0 +1,225 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestScanToFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"b.txt":     mapFile("b"),
+		"a\tb.txt":  mapFile("tab"),
+		"sub/c.txt": mapFile("c"),
+	}
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	h := &Hasher{FS: fsys}
+	if err := h.ScanToFile(context.Background(), ".", manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ManifestHeader + "\n" +
+		fmt.Sprintf("%x\ta\tb.txt\n", md5Of("tab")) +
+		fmt.Sprintf("%x\tb.txt\n", md5Of("b")) +
+		fmt.Sprintf("%x\tsub/c.txt\n", md5Of("c"))
+	if string(data) != want {
+		t.Errorf("manifest:\n%s\nwant:\n%s", data, want)
+	}
+
+	m, err := ParseManifest(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, f := range fsys {
+		if want := md5Of(string(f.Data)); string(m[path]) != string(want[:]) {
+			t.Errorf("sum of %q read back as %x, want %x", path, m[path], want)
+		}
+	}
+}
+
+func TestScanToFileLineBreak(t *testing.T) {
+	for _, name := range []string{"a\nb.txt", "a\rb.txt", "a.txt\r"} {
+		manifest := filepath.Join(t.TempDir(), "manifest")
+		h := &Hasher{FS: fstest.MapFS{"ok.txt": mapFile("ok"), name: mapFile("bad")}}
+		err := h.ScanToFile(context.Background(), ".", manifest)
+		if !errors.Is(err, ErrManifestPath) {
+			t.Errorf("ScanToFile with %q = %v, want ErrManifestPath", name, err)
+		}
+		if _, err := os.Stat(manifest); !os.IsNotExist(err) {
+			t.Errorf("ScanToFile with %q left a manifest: %v", name, err)
+		}
+	}
+}
+
+func TestManifestEncoderLineBreak(t *testing.T) {
+	var b strings.Builder
+	e := NewManifestEncoder(&b)
+	if err := e.Encode(Result{Path: "a\nb", Sum: Digest{1}}); !errors.Is(err, ErrManifestPath) {
+		t.Errorf("Encode = %v, want ErrManifestPath", err)
+	}
+	if err := e.Encode(Result{Path: "a\nb", Err: errors.New("failed")}); err != nil {
+		t.Errorf("Encode of failed result = %v, want nil", err)
+	}
+}
+
+// cancelOrders are the two orders in which a walk can fail and be canceled:
+// the walk's error must survive a cancel that follows it, and a cancel must
+// keep the walk from meeting further errors. Each sets up h to fail its walk
+// and cancel the scan, and returns the error the scan must match.
+var cancelOrders = []struct {
+	name  string
+	setup func(h *Hasher, cancel func()) (want func(error) bool)
+}{
+	{
+		// The listing of the root fails, then the scan is canceled,
+		// before the walk reports the failure.
+		name: "error then cancel",
+		setup: func(h *Hasher, cancel func()) func(error) bool {
+			bad := errors.New("listing failed")
+			h.FS = hookFS{
+				MapFS: fstest.MapFS{"a.txt": mapFile("a")},
+				readDir: func(name string) error {
+					if name == "." {
+						cancel()
+						return bad
+					}
+					return nil
+				},
+			}
+			return func(err error) bool {
+				var we *WalkError
+				return errors.As(err, &we) && errors.Is(err, bad)
+			}
+		},
+	},
+	{
+		// The scan is canceled while the walk is at the second file,
+		// and the third one is more than MaxFiles allows.
+		name: "cancel then error",
+		setup: func(h *Hasher, cancel func()) func(error) bool {
+			h.FS = fstest.MapFS{"a.txt": mapFile("a"), "b.txt": mapFile("b"), "c.txt": mapFile("c")}
+			h.MaxFiles = 2
+			h.WalkFunc = func(path string, info os.FileInfo) (string, bool) {
+				if path == "b.txt" {
+					cancel()
+				}
+				return "", true
+			}
+			return func(err error) bool {
+				return errors.Is(err, context.Canceled) && !errors.Is(err, ErrTooManyFiles)
+			}
+		},
+	},
+	{
+		// Likewise, but with the files queued for the digesters, so
+		// that the second one is sent even once the scan is canceled.
+		name: "cancel then error, queued",
+		setup: func(h *Hasher, cancel func()) func(error) bool {
+			h.FS = fstest.MapFS{"a.txt": mapFile("a"), "b.txt": mapFile("b"), "c.txt": mapFile("c")}
+			h.MaxFiles = 2
+			h.AdaptiveWorkers = true
+			h.WalkFunc = func(path string, info os.FileInfo) (string, bool) {
+				if path == "b.txt" {
+					cancel()
+				}
+				return "", true
+			}
+			return func(err error) bool {
+				return errors.Is(err, context.Canceled) && !errors.Is(err, ErrTooManyFiles)
+			}
+		},
+	},
+}
+
+func TestScanToFileCancelOrder(t *testing.T) {
+	for _, tt := range cancelOrders {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				h := new(Hasher)
+				want := tt.setup(h, cancel)
+				manifest := filepath.Join(t.TempDir(), "manifest")
+				err := h.ScanToFile(ctx, ".", manifest)
+				cancel()
+				if !want(err) {
+					t.Fatalf("ScanToFile = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunCancelOrder(t *testing.T) {
+	for _, tt := range cancelOrders {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				h := new(Hasher)
+				want := tt.setup(h, cancel)
+				_, err := h.Run(ctx, ".")
+				cancel()
+				if !want(err) {
+					t.Fatalf("Run = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	// The files take a second to read, one at a time, but the run stops
+	// at its deadline.
+	fsys := fstest.MapFS{}
+	for i := 0; i < 100; i++ {
+		fsys[fmt.Sprintf("f%03d", i)] = mapFile("x")
+	}
+	h := &Hasher{
+		FS:      fsys,
+		Workers: 1,
+		OpenFunc: func(path string) (io.ReadCloser, error) {
+			time.Sleep(10 * time.Millisecond)
+			return ioutil.NopCloser(strings.NewReader("x")), nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := h.Run(ctx, "."); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Run took %v past a deadline of 50ms", elapsed)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	// A run canceled before it starts opens no file.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opened := make(chan string, 2)
+	h := &Hasher{
+		FS: fstest.MapFS{"a.txt": mapFile("a"), "b.txt": mapFile("b")},
+		OpenFunc: func(path string) (io.ReadCloser, error) {
+			opened <- path
+			return ioutil.NopCloser(strings.NewReader(path)), nil
+		},
+	}
+	if _, err := h.Run(ctx, "."); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	if len(opened) > 0 {
+		t.Errorf("a canceled Run opened %s", <-opened)
+	}
+}
@@ -0,0 +1,132 @@
+package checksum
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"io"
+)
+
+// A DiffKind says how an entry differs between two manifests.
+type DiffKind int
+
+const (
+	// DiffAdded means the path is only in the second manifest.
+	DiffAdded DiffKind = iota + 1
+
+	// DiffRemoved means the path is only in the first manifest.
+	DiffRemoved
+
+	// DiffChanged means the path is in both manifests, with different
+	// digests.
+	DiffChanged
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("DiffKind(%d)", int(k))
+}
+
+// A DiffEntry is sent by DiffManifestReaders for each path that differs
+// between the two manifests, with its digest in the first manifest, Old, and
+// in the second, New, where it has one. If Err is non-nil, the comparison
+// failed and the entry is the last one.
+type DiffEntry struct {
+	Path     string
+	Kind     DiffKind
+	Old, New Digest
+	Err      error
+}
+
+// DiffManifestReaders compares the manifests read from a and b, each sorted by
+// path in byte order as ScanToFile writes them, and sends an entry for each
+// path added, removed or changed from a to b on the returned channel, in path
+// order. Both manifests are read in step, one entry at a time, so manifests
+// of any size can be compared in constant memory.
+//
+// Malformed first entries are reported right away. A malformed line found
+// later, a read error, or a manifest that turns out not to be sorted ends the
+// comparison with an entry holding the error. The caller must receive from
+// the channel until it is closed.
+func DiffManifestReaders(a, b io.Reader) (<-chan DiffEntry, error) {
+	ra := &sortedManifest{mr: newManifestReader(a), name: "first manifest"}
+	rb := &sortedManifest{mr: newManifestReader(b), name: "second manifest"}
+	if err := ra.next(); err != nil {
+		return nil, err
+	}
+	if err := rb.next(); err != nil {
+		return nil, err
+	}
+
+	out := make(chan DiffEntry)
+	go func() {
+		defer close(out)
+
+		for !ra.done || !rb.done {
+			var e DiffEntry
+			var err error
+			switch {
+			case rb.done || !ra.done && ra.path < rb.path:
+				e = DiffEntry{Path: ra.path, Kind: DiffRemoved, Old: ra.sum}
+				err = ra.next()
+			case ra.done || rb.path < ra.path:
+				e = DiffEntry{Path: rb.path, Kind: DiffAdded, New: rb.sum}
+				err = rb.next()
+			default:
+				if !hmac.Equal(ra.sum, rb.sum) {
+					e = DiffEntry{Path: ra.path, Kind: DiffChanged, Old: ra.sum, New: rb.sum}
+				}
+				if err = ra.next(); err == nil {
+					err = rb.next()
+				}
+			}
+
+			if e.Kind != 0 {
+				out <- e
+			}
+			if err != nil {
+				out <- DiffEntry{Err: err}
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// sortedManifest reads the entries of a manifest one at a time, checking that
+// they are sorted by path.
+type sortedManifest struct {
+	mr   *manifestReader
+	name string // name of the manifest in errors
+
+	// path and sum are the current entry, once started is set and unless
+	// done is set once the manifest is exhausted.
+	path    string
+	sum     Digest
+	started bool
+	done    bool
+}
+
+// next advances m to its next entry.
+func (m *sortedManifest) next() error {
+	path, sum, err := m.mr.next()
+	if err == io.EOF {
+		m.done = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.name, err)
+	}
+	if m.started && path <= m.path {
+		return fmt.Errorf("%s: %w", m.name, &ParseError{m.mr.n, fmt.Errorf("path %q is not sorted after %q", path, m.path)})
+	}
+	m.path, m.sum, m.started = path, sum, true
+	return nil
+}
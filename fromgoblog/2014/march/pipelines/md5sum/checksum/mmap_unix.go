@@ -0,0 +1,60 @@
+//go:build unix
+
+package checksum
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// mmapMinSize is the size from which Hasher.UseMmap maps files rather than
+// reading them; below it, setting up the mapping costs more than it saves.
+const mmapMinSize = 4 << 20
+
+// sumMapped returns the digest of the contents of the file at path, hashed
+// from a memory mapping of the file, doing what o asks besides, as sum does.
+// It reports false, leaving the file to be read as usual, if the file is too
+// small or cannot be mapped.
+func (h *Hasher) sumMapped(path string, o *readOpts, j *job) (Digest, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		// Let the usual read report the error.
+		return nil, false, nil
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, nil
+	}
+	size := info.Size()
+	grown := o != nil && o.capped && size > o.limit
+	if grown {
+		size = o.limit
+	}
+	if size < mmapMinSize || int64(int(size)) != size {
+		return nil, false, nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer syscall.Munmap(data)
+	if grown {
+		o.grown = true
+	}
+
+	if len(h.ExcludeContentTypes) > 0 && h.excludedType(data[:sniffLen]) {
+		return nil, true, errContentSkipped
+	}
+
+	d := h.newHash()
+	var dst io.Writer = d
+	if j.profile {
+		dst = &timedWriter{d, &j.hashTime}
+	}
+	o.tee(dst).Write(data)
+
+	return d.Sum(nil), true, nil
+}
@@ -0,0 +1,72 @@
+//go:build unix
+
+package checksum
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchFile writes a file of size bytes below a new temporary directory, and
+// returns that directory.
+func benchFile(b *testing.B, size int64) string {
+	b.Helper()
+	root := b.TempDir()
+	f, err := os.Create(filepath.Join(root, "big.bin"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := io.Copy(f, io.LimitReader(&synthFile{info: synthInfo{size: size}}, size)); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return root
+}
+
+// mmapSizes are the file sizes the UseMmap benchmarks are run for: one just
+// above mmapMinSize and one far above it.
+var mmapSizes = []int64{mmapMinSize, 256 << 20}
+
+func benchmarkDigestFile(b *testing.B, useMmap bool) {
+	for _, size := range mmapSizes {
+		b.Run(fmt.Sprintf("size=%dMiB", size>>20), func(b *testing.B) {
+			root := benchFile(b, size)
+			benchCollect(b, &Hasher{UseMmap: useMmap}, root)
+		})
+	}
+}
+
+// BenchmarkDigestMmap and BenchmarkDigestStream compare digesting a file that
+// is in the page cache from a memory mapping and by reading it.
+func BenchmarkDigestMmap(b *testing.B)   { benchmarkDigestFile(b, true) }
+func BenchmarkDigestStream(b *testing.B) { benchmarkDigestFile(b, false) }
+
+func TestUseMmap(t *testing.T) {
+	// A file mapped has the digest of the same file read.
+	root := t.TempDir()
+	content := make([]byte, mmapMinSize+12345)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	path := filepath.Join(root, "big.bin")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := (&Hasher{UseMmap: true}).MD5All(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := new(Hasher).MD5All(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped[path] != read[path] {
+		t.Errorf("mapped sum %x, read sum %x", mapped[path], read[path])
+	}
+}
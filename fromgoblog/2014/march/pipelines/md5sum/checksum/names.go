@@ -0,0 +1,79 @@
+package checksum
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// NameMode says how a Hasher treats the files and directories whose name is
+// not valid UTF-8.
+type NameMode int
+
+const (
+	// InvalidNamesKeep digests files with invalid names like any other,
+	// reporting their paths byte for byte. This is the default.
+	InvalidNamesKeep NameMode = iota
+
+	// InvalidNamesSkip skips files with invalid names, and does not descend
+	// into directories with invalid names. Their paths are listed in
+	// Stats.InvalidNames.
+	InvalidNamesSkip
+
+	// InvalidNamesEscape digests files with invalid names, but reports every
+	// path escaped with EscapePath, so that manifests only ever hold valid
+	// UTF-8. The invalid names are listed in Stats.InvalidNames.
+	InvalidNamesEscape
+)
+
+// EscapePath returns path in valid UTF-8: each byte that is not part of a
+// valid UTF-8 sequence becomes %XX, XX being its value in upper-case
+// hexadecimal, and each '%' becomes %25, so that the escaping can be undone
+// with UnescapePath. Paths that are valid UTF-8 and hold no '%' are left as
+// they are.
+func EscapePath(path string) string {
+	if utf8.ValidString(path) && !strings.Contains(path, "%") {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); {
+		r, size := utf8.DecodeRuneInString(path[i:])
+		switch {
+		case r == utf8.RuneError && size == 1, r == '%':
+			fmt.Fprintf(&b, "%%%02X", path[i])
+		default:
+			b.WriteString(path[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// UnescapePath undoes EscapePath, turning each %XX back into the byte with
+// the hexadecimal value XX. It returns an error if a '%' is not followed by
+// two hexadecimal digits.
+func UnescapePath(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("checksum: truncated escape in path %q", s)
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("checksum: invalid escape %q in path %q", s[i:i+3], s)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,57 @@
+package checksum
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// orderLog writes the path of each file digested by a run to
+// Hasher.RecordOrder, one per line, escaped as coreutils escapes file names
+// so that every path fits on its line. Reading a log back with readOrder
+// gives the paths in the order they were written.
+type orderLog struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error // first error writing to w
+}
+
+// record writes path to the log. Once a write has failed, record does
+// nothing.
+func (l *orderLog) record(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		_, l.err = io.WriteString(l.w, coreutilsEscaper.Replace(path)+"\n")
+	}
+}
+
+// error returns the first error writing to the log, if any.
+func (l *orderLog) error() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// readOrder reads the paths of a log written by an orderLog, in order.
+func readOrder(r io.Reader) ([]string, error) {
+	paths := []string{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if line := s.Text(); line != "" {
+			paths = append(paths, coreutilsUnescaper.Replace(line))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// serial reports whether a run of h digests its files one at a time, in the
+// order the walk sends them.
+func (h *Hasher) serial() bool {
+	return h.SequentialMode || h.ReplayOrder != nil
+}
@@ -0,0 +1,46 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+)
+
+// HashOrdered returns a single digest, computed with newHash, over the
+// contents of the files at paths, in that order. Each file's contents are
+// followed by their length as a big-endian uint64, so that no two different
+// lists of contents hash the same input; in particular, reordering the files
+// changes the digest. If any file cannot be read, HashOrdered returns an
+// error.
+func HashOrdered(paths []string, newHash func() hash.Hash) ([]byte, error) {
+	d := newHash()
+
+	var n [8]byte
+	for _, path := range paths {
+		size, err := copyFile(d, path)
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(n[:], uint64(size))
+		d.Write(n[:])
+	}
+
+	return d.Sum(nil), nil
+}
+
+// copyFile copies the contents of the file at path to w and returns the number
+// of bytes copied. A failure to open or read the file is a *ReadError.
+func copyFile(w io.Writer, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, &ReadError{path, err}
+	}
+	defer f.Close()
+
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return n, &ReadError{path, err}
+	}
+	return n, nil
+}
@@ -0,0 +1,182 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// manifestChunkSize is about the size of the chunks ParseManifestConcurrent
+// splits a manifest into; each chunk runs on to the end of its last line.
+const manifestChunkSize = 1 << 20
+
+// manifestChunk is a run of whole lines of a manifest, along with its index
+// among the chunks and the number of its first line.
+type manifestChunk struct {
+	index int
+	line  int
+	data  []byte
+}
+
+// parsedChunk holds the entries parsed from a manifestChunk, in order, or the
+// first error parsing it.
+type parsedChunk struct {
+	index int
+	paths []string
+	sums  []Digest
+	err   error
+}
+
+// ParseManifestConcurrent is like ParseManifest, but splits the manifest into
+// chunks of whole lines and parses them on workers goroutines at once, or on
+// GOMAXPROCS of them if workers is not positive, for manifests so large that
+// hex-decoding them on one goroutine holds up verification. It returns the
+// same map as ParseManifest, and the same error for a malformed manifest:
+// that of its first malformed line, with the number of that line.
+func ParseManifestConcurrent(r io.Reader, workers int) (map[string]Digest, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// Closing done on return stops the goroutines if a malformed line
+	// ends the parse early.
+	done := make(chan struct{})
+	defer close(done)
+
+	chunks := make(chan manifestChunk)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		readErr <- splitManifest(done, r, chunks)
+	}()
+
+	parsed := make(chan parsedChunk)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				select {
+				case parsed <- parseManifestChunk(c):
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(parsed)
+	}()
+
+	// Merge the chunks in order, so that the first error found is that of
+	// the first malformed line, and a path listed twice keeps its last
+	// digest, as with ParseManifest.
+	m := make(map[string]Digest)
+	pending := make(map[int]parsedChunk)
+	next := 0
+	for p := range parsed {
+		pending[p.index] = p
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if p.err != nil {
+				return nil, p.err
+			}
+			for i, path := range p.paths {
+				m[path] = p.sums[i]
+			}
+		}
+	}
+	if err := <-readErr; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// splitManifest reads the manifest from r and sends it on chunks, cut at line
+// boundaries, until r is exhausted or done is closed.
+func splitManifest(done <-chan struct{}, r io.Reader, chunks chan<- manifestChunk) error {
+	br := bufio.NewReader(r)
+	line := 1
+	for index := 0; ; {
+		buf := make([]byte, manifestChunkSize)
+		n, err := io.ReadFull(br, buf)
+		data := buf[:n]
+		eof := false
+		switch err {
+		case nil:
+			rest, err := br.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			data = append(data, rest...)
+			eof = err == io.EOF
+		case io.EOF, io.ErrUnexpectedEOF:
+			eof = true
+		default:
+			return err
+		}
+
+		if len(data) > 0 {
+			select {
+			case chunks <- manifestChunk{index, line, data}:
+			case <-done:
+				return nil
+			}
+			index++
+			line += bytes.Count(data, []byte{'\n'})
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+// parseManifestChunk parses the lines of c as ParseManifest does, taking the
+// first line of the first chunk for the header it may be.
+func parseManifestChunk(c manifestChunk) parsedChunk {
+	p := parsedChunk{index: c.index}
+
+	lines := bytes.Split(c.data, []byte{'\n'})
+	if len(lines[len(lines)-1]) == 0 {
+		// The chunk ends with a newline.
+		lines = lines[:len(lines)-1]
+	}
+	for i, b := range lines {
+		n := c.line + i
+		line := string(bytes.TrimSuffix(b, []byte{'\r'}))
+		if c.index == 0 && i == 0 {
+			_, ok, err := parseManifestHeader(line)
+			if err != nil {
+				p.err = &ParseError{n, err}
+				return p
+			}
+			if ok {
+				continue
+			}
+		}
+		if line == "" {
+			continue
+		}
+
+		path, sum, err := parseManifestLine(line)
+		if err != nil {
+			p.err = &ParseError{n, err}
+			return p
+		}
+		p.paths = append(p.paths, path)
+		p.sums = append(p.sums, sum)
+	}
+
+	return p
+}
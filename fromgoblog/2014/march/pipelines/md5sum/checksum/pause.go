@@ -0,0 +1,66 @@
+package checksum
+
+import "sync"
+
+// A Pauser pauses and resumes the runs of any Hasher whose Pauser field points
+// at it. While it is paused, digesters finish the file they are reading, send
+// what they have, and start no new read until it is resumed; the walk stops
+// too once the digesters stop taking files. A paused run can still be
+// canceled. The zero value is ready to use and not paused.
+type Pauser struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{} // closed by Resume
+}
+
+// Pause pauses the runs using p. It does nothing if p is already paused.
+func (p *Pauser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		p.paused = true
+		p.resume = make(chan struct{})
+	}
+}
+
+// Resume resumes the runs using p where they left off. It does nothing if p
+// is not paused.
+func (p *Pauser) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		p.paused = false
+		close(p.resume)
+	}
+}
+
+// Paused reports whether p is paused. A nil Pauser is never paused.
+func (p *Pauser) Paused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// wait waits until p is resumed, if it is paused. It reports false if done
+// was closed first.
+func (p *Pauser) wait(done <-chan struct{}) bool {
+	if p == nil {
+		return true
+	}
+	p.mu.Lock()
+	paused, resume := p.paused, p.resume
+	p.mu.Unlock()
+	if !paused {
+		return true
+	}
+
+	select {
+	case <-resume:
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,129 @@
+package checksum
+
+import "sync"
+
+// A Pool is a set of long-lived goroutines that digest files for the runs of
+// any Hasher whose Pool field points at it, so that a service scanning trees
+// over and over does not start new goroutines and allocate new buffers for
+// every scan. Several runs may share a Pool at once. A Pool must be created
+// with NewPool, and stopped with Close once it is no longer needed.
+type Pool struct {
+	tasks chan task
+
+	// workers tracks the digesters, and pending the runs being fed to them
+	// and the tasks they have not dealt with yet.
+	workers sync.WaitGroup
+	pending sync.WaitGroup
+}
+
+// task is a file to digest for one run.
+type task struct {
+	h    *Hasher
+	f    file
+	j    *job
+	done <-chan struct{}
+	c    chan<- []Result
+	wg   *sync.WaitGroup
+}
+
+// NewPool starts a pool of n digesters. If n is zero, as many are started as
+// a Hasher uses by default; see Hasher.Workers.
+func NewPool(n int) *Pool {
+	if n <= 0 {
+		n = defaultWorkers()
+	}
+	p := &Pool{tasks: make(chan task)}
+
+	p.workers.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			p.digester()
+			p.workers.Done()
+		}()
+	}
+
+	return p
+}
+
+// digester digests the files of the tasks sent to p until p is closed, using
+// the same buffer for all of them, unless their Hashers ask for buffers of
+// different sizes. Tasks whose run has been abandoned are
+// skipped without reading the file.
+func (p *Pool) digester() {
+	buf := make([]byte, copyBufSize)
+	for t := range p.tasks {
+		select {
+		case <-t.done:
+		default:
+			if !t.h.Pauser.wait(t.done) {
+				break
+			}
+			if t.j.tokens != nil && t.j.tokens.Acquire(t.j.ctx) != nil {
+				break
+			}
+			if len(buf) != t.h.bufferSize() {
+				buf = make([]byte, t.h.bufferSize())
+			}
+			r := t.h.timedDigest(t.f, t.j, buf)
+			if t.j.tokens != nil {
+				t.j.tokens.Release()
+			}
+			select {
+			case t.c <- []Result{r}:
+			case <-t.done:
+			}
+		}
+		t.wg.Done()
+		p.pending.Done()
+	}
+}
+
+// feed hands the files of a run of h to the digesters of p, and returns the
+// channel their results are sent on, which is closed once every file has been
+// dealt with. If done is closed, feed stops handing over files.
+func (p *Pool) feed(h *Hasher, done <-chan struct{}, files <-chan file, j *job) <-chan []Result {
+	c := make(chan []Result)
+
+	// The feeding goroutine counts as pending until it returns, so that
+	// Reset also waits for a run that was abandoned to stop feeding.
+	p.pending.Add(1)
+
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(c)
+			p.pending.Done()
+		}()
+
+		for f := range files {
+			wg.Add(1)
+			p.pending.Add(1)
+			select {
+			case p.tasks <- task{h, f, j, done, c, &wg}:
+			case <-done:
+				wg.Done()
+				p.pending.Done()
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// Reset waits until the digesters of p have dealt with every file handed to
+// them, including the files of runs that were abandoned, which are discarded
+// without being read. Once Reset returns, no leftover work of an earlier run
+// competes with the next one. Reset must not be called while a run using p
+// is in progress.
+func (p *Pool) Reset() {
+	p.pending.Wait()
+}
+
+// Close stops the digesters of p, once they have dealt with the files handed
+// to them, and waits for them to return. No run may use p after Close.
+func (p *Pool) Close() {
+	close(p.tasks)
+	p.workers.Wait()
+}
@@ -0,0 +1,35 @@
+package checksum
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// timedReader is an io.Reader adding the time spent in each Read of r to the
+// nanosecond counter d, atomically.
+type timedReader struct {
+	r io.Reader
+	d *int64
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	atomic.AddInt64(t.d, int64(time.Since(start)))
+	return n, err
+}
+
+// timedWriter is an io.Writer adding the time spent in each Write to w to the
+// nanosecond counter d, atomically.
+type timedWriter struct {
+	w io.Writer
+	d *int64
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	atomic.AddInt64(t.d, int64(time.Since(start)))
+	return n, err
+}
@@ -0,0 +1,181 @@
+package checksum
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Progress describes a run while it goes on, as passed to
+// Hasher.ProgressFunc. Its Stats are Files, Bytes and Failed, counted as the
+// digesters go, and the timings if Hasher.Profile is set, as Scan.Stats
+// reports them.
+type Progress struct {
+	Stats
+
+	// Found is the number of files found by the walk so far, and
+	// FoundBytes the sum of their sizes. Counted is set once they are the
+	// totals of the run: once the walk is over, or from the start if the
+	// tree was counted first because of Hasher.CountFirst.
+	Found      int
+	FoundBytes int64
+	Counted    bool
+
+	// Elapsed is the time since the run started.
+	Elapsed time.Duration
+}
+
+// Throughput returns the number of bytes digested per second so far.
+func (p Progress) Throughput() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// ETA returns an estimate of the time left until the run completes, from the
+// bytes left to digest and the throughput so far. It reports false until the
+// totals of the run are Counted and some bytes have been digested.
+func (p Progress) ETA() (time.Duration, bool) {
+	rate := p.Throughput()
+	if !p.Counted || rate == 0 {
+		return 0, false
+	}
+	left := p.FoundBytes - p.Bytes
+	if left < 0 {
+		left = 0
+	}
+	return time.Duration(float64(left) / rate * float64(time.Second)), true
+}
+
+// stats returns the statistics of j so far, as the digesters count them.
+func (j *job) stats() Stats {
+	failed := atomic.LoadInt64(&j.failed)
+	st := Stats{
+		Files:  int(atomic.LoadInt64(&j.digested) - failed),
+		Bytes:  atomic.LoadInt64(&j.digestedBytes) - atomic.LoadInt64(&j.failedBytes),
+		Failed: int(failed),
+	}
+	if j.profile {
+		st.setProfile(j)
+	}
+	return st
+}
+
+// progress returns the progress of j, which started at start.
+func (j *job) progress(start time.Time) Progress {
+	p := Progress{
+		Stats:      j.stats(),
+		Found:      int(atomic.LoadInt64(&j.found)),
+		FoundBytes: atomic.LoadInt64(&j.foundBytes),
+		Counted:    atomic.LoadInt32(&j.counted) != 0,
+		Elapsed:    time.Since(start),
+	}
+	if j.total >= 0 {
+		p.Found, p.FoundBytes, p.Counted = int(j.total), j.totalBytes, true
+	}
+	return p
+}
+
+// reportProgress calls h.ProgressFunc with the progress of j every
+// progressInterval until the returned function is called, which waits for
+// the last of those calls to return and makes a final one.
+func (h *Hasher) reportProgress(j *job) func() {
+	start := time.Now()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				h.ProgressFunc(j.progress(start))
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+		h.ProgressFunc(j.progress(start))
+	}
+}
+
+// countFiles walks the file tree rooted at root the way h does, but without
+// reading any file, and returns the number of files it found and the sum of
+// their sizes, for h.CountFirst.
+func (h *Hasher) countFiles(done <-chan struct{}, root string) (int64, int64, error) {
+	var n, size int64
+	err := h.walkOnly().collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		n++
+		size += r.Size
+		return nil
+	})
+	return n, size, err
+}
+
+// A ProgressVar holds the latest Progress of a run, for monitoring it while it
+// goes on: its Update method is meant to be a Hasher.ProgressFunc. It is an
+// expvar.Var, to be published with expvar.Publish, and an http.Handler
+// serving the progress in the Prometheus text exposition format. A
+// ProgressVar is safe for concurrent use, and its zero value is ready to use.
+type ProgressVar struct {
+	mu sync.Mutex
+	p  Progress
+}
+
+// Update records p as the latest progress.
+func (v *ProgressVar) Update(p Progress) {
+	v.mu.Lock()
+	v.p = p
+	v.mu.Unlock()
+}
+
+// Progress returns the latest progress recorded.
+func (v *ProgressVar) Progress() Progress {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.p
+}
+
+// String returns the latest progress as a JSON object, as expvar requires.
+func (v *ProgressVar) String() string {
+	p := v.Progress()
+	eta, _ := p.ETA()
+	data, _ := json.Marshal(map[string]interface{}{
+		"files":      p.Files,
+		"bytes":      p.Bytes,
+		"failed":     p.Failed,
+		"found":      p.Found,
+		"foundBytes": p.FoundBytes,
+		"counted":    p.Counted,
+		"elapsed":    p.Elapsed.Seconds(),
+		"throughput": p.Throughput(),
+		"etaSeconds": eta.Seconds(),
+	})
+	return string(data)
+}
+
+// ServeHTTP writes the latest progress as Prometheus metrics.
+func (v *ProgressVar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := v.Progress()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("checksum_files_total", "counter", "Files digested.", p.Files)
+	metric("checksum_bytes_total", "counter", "Bytes of the files digested.", p.Bytes)
+	metric("checksum_failed_total", "counter", "Files that could not be read.", p.Failed)
+	metric("checksum_found_files", "gauge", "Files found by the walk.", p.Found)
+	metric("checksum_found_bytes", "gauge", "Bytes of the files found by the walk.", p.FoundBytes)
+	metric("checksum_elapsed_seconds", "gauge", "Time since the run started.", p.Elapsed.Seconds())
+}
@@ -0,0 +1,90 @@
+package checksum
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// progressEvent is a call of Hasher.OnProgress.
+type progressEvent struct {
+	files int
+	bytes int64
+}
+
+// TestOnProgressFinal checks that the last OnProgress call of a run, whether
+// it completes or is canceled, has the totals of the results it delivered,
+// and that no call follows the run's return.
+func TestOnProgressFinal(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i < 100; i++ {
+		fsys[fmt.Sprintf("f%03d", i)] = mapFile(fmt.Sprint(i))
+	}
+
+	tests := []struct {
+		name        string
+		cancelAfter int // results, or 0 to let the run complete
+	}{
+		{"complete", 0},
+		{"cancel after 1", 1},
+		{"cancel after 40", 40},
+		{"cancel after last", len(fsys)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var events []progressEvent
+			h := &Hasher{
+				FS: fsys,
+				OnProgress: func(files int, bytes int64) {
+					mu.Lock()
+					events = append(events, progressEvent{files, bytes})
+					mu.Unlock()
+				},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			results, errc := h.Stream(ctx, ".")
+			var got progressEvent
+			for r := range results {
+				got.files++
+				got.bytes += r.Size
+				if got.files == tt.cancelAfter {
+					cancel()
+				}
+				// Leave time for the calls made while
+				// the run goes on.
+				time.Sleep(2 * time.Millisecond)
+			}
+			if err := <-errc; tt.cancelAfter == 0 && err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			n := len(events)
+			mu.Unlock()
+			if n == 0 {
+				t.Fatal("OnProgress was never called")
+			}
+			if last := events[n-1]; last != got {
+				t.Errorf("last OnProgress(%d, %d), want (%d, %d) as delivered", last.files, last.bytes, got.files, got.bytes)
+			}
+			for i := 1; i < n; i++ {
+				if events[i].files < events[i-1].files {
+					t.Errorf("OnProgress went from %d files back to %d", events[i-1].files, events[i].files)
+				}
+			}
+
+			time.Sleep(3 * progressInterval)
+			mu.Lock()
+			defer mu.Unlock()
+			if len(events) != n {
+				t.Errorf("OnProgress called %d times after the run returned", len(events)-n)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package checksum
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A RateLimiter paces the reads of a Hasher, for Hasher.ByteLimiter and
+// Hasher.FileLimiter. WaitN blocks until n events are allowed, or returns a
+// non-nil error if ctx is done first or n is more than Burst, the most events
+// allowed at once. A *rate.Limiter from golang.org/x/time/rate is a
+// RateLimiter, so that a limit can be shared with the rest of a program;
+// NewRateLimiter returns one for programs without it. A RateLimiter must be
+// safe for use by several goroutines at once.
+type RateLimiter interface {
+	WaitN(ctx context.Context, n int) error
+	Burst() int
+}
+
+// NewRateLimiter returns a token bucket RateLimiter allowing r events per
+// second on average, and up to burst of them at once. If burst is not
+// positive, it is r, so that a second's worth of events can come at once, and
+// no less than 1. r must be positive.
+func NewRateLimiter(r float64, burst int) RateLimiter {
+	if burst <= 0 {
+		burst = int(r)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &tokenBucket{rate: r, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// tokenBucket is the RateLimiter NewRateLimiter returns. It holds up to burst
+// tokens, refilled at rate per second, and each event takes one. Waiters take
+// their tokens ahead, leaving the bucket in debt, and give them back if they
+// stop waiting.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) Burst() int { return b.burst }
+
+func (b *tokenBucket) WaitN(ctx context.Context, n int) error {
+	if n > b.burst {
+		return fmt.Errorf("checksum: %d events exceed the burst of %d of the rate limiter", n, b.burst)
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// limitedReader is an io.Reader pacing the reads of r with l, a byte per
+// event, each read no larger than the burst of l.
+type limitedReader struct {
+	r   io.Reader
+	l   RateLimiter
+	ctx context.Context
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if b := lr.l.Burst(); b > 0 && len(p) > b {
+		p = p[:b]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.l.WaitN(lr.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// pace returns src paced by h.ByteLimiter for the run j, or src itself if
+// h.ByteLimiter is nil.
+func (h *Hasher) pace(src io.Reader, j *job) io.Reader {
+	if h.ByteLimiter == nil {
+		return src
+	}
+	return &limitedReader{src, h.ByteLimiter, j.ctx}
+}
+
+// waitFile waits until h.FileLimiter allows the run j to read another file.
+func (h *Hasher) waitFile(j *job) error {
+	if h.FileLimiter == nil {
+		return nil
+	}
+	return h.FileLimiter.WaitN(j.ctx, 1)
+}
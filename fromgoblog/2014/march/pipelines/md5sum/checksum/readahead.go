@@ -0,0 +1,131 @@
+package checksum
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	// readaheadSize is the size of the chunks a readahead reads, large
+	// enough that a spinning disk spends its time transferring rather than
+	// seeking.
+	readaheadSize = 1 << 20
+
+	// readaheadChunks is the number of chunks a readahead holds, one being
+	// read while the other is consumed.
+	readaheadChunks = 2
+)
+
+// readaheadBufs holds the chunks of finished readaheads for reuse.
+var readaheadBufs = sync.Pool{
+	New: func() interface{} { return make([]byte, readaheadSize) },
+}
+
+// A readahead is an io.Reader that reads ahead of its consumer from the
+// underlying reader, in chunks of readaheadSize bytes read by a goroutine of
+// its own, so that reading the next chunk overlaps with the consumer
+// processing the last one. Reads of the underlying reader are sequential. The
+// consumer must call stop once done with the readahead.
+type readahead struct {
+	r    io.Reader
+	full chan []byte
+	free chan []byte
+	done chan struct{}
+
+	// err is the error the underlying reader failed with, other than
+	// io.EOF. It is set before full is closed.
+	err error
+
+	// chunk is the chunk being consumed and left the part of it not read
+	// yet.
+	chunk []byte
+	left  []byte
+}
+
+// newReadahead returns a readahead reading from r.
+func newReadahead(r io.Reader) *readahead {
+	ra := &readahead{
+		r:    r,
+		full: make(chan []byte, readaheadChunks),
+		free: make(chan []byte, readaheadChunks),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < readaheadChunks; i++ {
+		ra.free <- readaheadBufs.Get().([]byte)
+	}
+	go ra.fill()
+	return ra
+}
+
+// fill reads chunks from the underlying reader into free buffers until it
+// fails, reaches the end, or stop is called, and closes full then.
+func (ra *readahead) fill() {
+	defer close(ra.full)
+
+	for {
+		var buf []byte
+		select {
+		case <-ra.done:
+			return
+		default:
+		}
+		select {
+		case buf = <-ra.free:
+		case <-ra.done:
+			return
+		}
+
+		n, err := io.ReadFull(ra.r, buf)
+		if n > 0 {
+			// full has room for every chunk, so this never blocks.
+			ra.full <- buf[:n]
+		} else {
+			ra.free <- buf
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			ra.err = err
+			return
+		}
+	}
+}
+
+// Read implements io.Reader.
+func (ra *readahead) Read(p []byte) (int, error) {
+	for len(ra.left) == 0 {
+		if ra.chunk != nil {
+			ra.free <- ra.chunk[:readaheadSize]
+			ra.chunk = nil
+		}
+		chunk, ok := <-ra.full
+		if !ok {
+			if ra.err != nil {
+				return 0, ra.err
+			}
+			return 0, io.EOF
+		}
+		ra.chunk, ra.left = chunk, chunk
+	}
+
+	n := copy(p, ra.left)
+	ra.left = ra.left[n:]
+	return n, nil
+}
+
+// stop stops the reading goroutine and waits for it to return, after which
+// the underlying reader may be closed, and recycles the chunks.
+func (ra *readahead) stop() {
+	close(ra.done)
+	for chunk := range ra.full {
+		readaheadBufs.Put(chunk[:readaheadSize])
+	}
+	if ra.chunk != nil {
+		readaheadBufs.Put(ra.chunk[:readaheadSize])
+	}
+	close(ra.free)
+	for buf := range ra.free {
+		readaheadBufs.Put(buf)
+	}
+}
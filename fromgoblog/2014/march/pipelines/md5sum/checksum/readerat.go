@@ -0,0 +1,197 @@
+package checksum
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+// A MemoryBudget bounds, in bytes, the memory taken up by the buffers of the
+// ranges HashReaderAt reads at once, across every Hasher whose ChunkBudget
+// points at it, so that concurrent ranged hashes of many large blobs cannot
+// exhaust memory between them. It must be created with NewMemoryBudget.
+type MemoryBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	size int64
+	used int64
+}
+
+// NewMemoryBudget returns a MemoryBudget of size bytes. A range larger than
+// size is read alone, taking up the whole budget.
+func NewMemoryBudget(size int64) *MemoryBudget {
+	b := &MemoryBudget{size: size}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire waits until n bytes of b are free and takes them, and returns the
+// number of bytes taken, to be given back to release. A nil MemoryBudget is
+// unlimited.
+func (b *MemoryBudget) acquire(n int64) int64 {
+	if b == nil {
+		return 0
+	}
+	if n > b.size {
+		n = b.size
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.used+n > b.size {
+		b.cond.Wait()
+	}
+	b.used += n
+	return n
+}
+
+// release gives back n bytes taken by acquire.
+func (b *MemoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// Domain separation bytes of the Merkle tree of HashReaderAt, so that a leaf
+// can never be mistaken for a node.
+const (
+	merkleLeaf = 0x00
+	merkleNode = 0x01
+)
+
+// HashReaderAt returns the Merkle root, computed with newHash, or with md5.New
+// if newHash is nil, of the first size bytes of ra, read as consecutive ranges
+// of chunk bytes, the last of which may be shorter, by several goroutines at
+// once. It lets a large blob, such as a disk image or an object in a store
+// serving ranged reads, be hashed faster than by a single reader.
+//
+// Each range is a leaf of the tree, hashed as a 0x00 byte followed by its
+// bytes, and each node is the hash of a 0x01 byte followed by its two
+// children; from the bottom up, leaves and then nodes are paired in order,
+// and one left over at the end of a level is carried up to the next as it is.
+// The root is thus the same however the ranges were scheduled, but depends on
+// chunk, and bears no relation to the digest of the blob as a whole. A blob of
+// no bytes has a single, empty leaf. If any range cannot be read in full,
+// HashReaderAt returns an error.
+//
+// HashReaderAt uses a Hasher with only Hash set to newHash; see
+// Hasher.HashReaderAt.
+func HashReaderAt(ra io.ReaderAt, size int64, chunk int64, newHash func() hash.Hash) ([]byte, error) {
+	return (&Hasher{Hash: newHash}).HashReaderAt(ra, size, chunk)
+}
+
+// HashReaderAt is like the package-level HashReaderAt, but hashes with h.Hash,
+// reads with h.Workers goroutines, and holds the buffers of the ranges within
+// h.ChunkBudget.
+func (h *Hasher) HashReaderAt(ra io.ReaderAt, size int64, chunk int64) ([]byte, error) {
+	if chunk <= 0 {
+		return nil, errors.New("checksum: chunk size must be positive")
+	}
+	if size < 0 {
+		return nil, errors.New("checksum: size must not be negative")
+	}
+
+	n := int((size + chunk - 1) / chunk)
+	if n == 0 {
+		return h.merkleRoot([][]byte{h.merkleHash(merkleLeaf)}), nil
+	}
+	leaves := make([][]byte, n)
+
+	done := make(chan struct{})
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
+
+	ranges := make(chan int)
+	go func() {
+		defer close(ranges)
+		for i := 0; i < n; i++ {
+			select {
+			case ranges <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	workers := h.workers()
+	if workers > n {
+		workers = n
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range ranges {
+				r := ByteRange{int64(i) * chunk, chunk}
+				if r.Offset+r.Size > size {
+					r.Size = size - r.Offset
+				}
+
+				held := h.ChunkBudget.acquire(r.Size)
+				buf := make([]byte, r.Size)
+				m, err := ra.ReadAt(buf, r.Offset)
+				if m == len(buf) {
+					// ReadAt may report io.EOF along with the last
+					// bytes of ra.
+					err = nil
+				} else if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				if err == nil {
+					leaves[i] = h.merkleHash(merkleLeaf, buf)
+				}
+				h.ChunkBudget.release(held)
+
+				if err != nil {
+					fail(fmt.Errorf("checksum: reading %v: %w", r, err))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return h.merkleRoot(leaves), nil
+}
+
+// merkleHash returns the hash, with h.Hash, of the kind byte of a leaf or
+// node followed by parts.
+func (h *Hasher) merkleHash(kind byte, parts ...[]byte) []byte {
+	d := h.newHash()
+	d.Write([]byte{kind})
+	for _, p := range parts {
+		d.Write(p)
+	}
+	return d.Sum(nil)
+}
+
+// merkleRoot folds leaves, of which there must be at least one, into the root
+// of their Merkle tree, as described for HashReaderAt.
+func (h *Hasher) merkleRoot(level [][]byte) []byte {
+	for len(level) > 1 {
+		next := level[:0:0]
+		for i := 0; i+1 < len(level); i += 2 {
+			next = append(next, h.merkleHash(merkleNode, level[i], level[i+1]))
+		}
+		if len(level)%2 == 1 {
+			next = append(next, level[len(level)-1])
+		}
+		level = next
+	}
+	return level[0]
+}
@@ -0,0 +1,112 @@
+package checksum
+
+import (
+	"bytes"
+	"hash"
+	"io"
+)
+
+// readOpts holds what a digester does while reading a file besides digesting
+// it, and what came of it. A nil *readOpts does nothing besides.
+type readOpts struct {
+	// capped is set for Hasher.TruncateToWalkSize, in which case only the
+	// first limit bytes of the file are read, and grown is set if the file
+	// had more.
+	capped bool
+	limit  int64
+	grown  bool
+
+	// keep, if non-nil, receives the bytes hashed, for Hasher.Transform.
+	keep *bytes.Buffer
+
+	// chunks, if non-nil, digests the bytes hashed in chunks, for
+	// Hasher.ChunkSize.
+	chunks *chunker
+
+	// hashes, if non-nil, are the hashes of Hasher.ExtraHashes the bytes
+	// hashed are also written to, by name.
+	hashes map[string]hash.Hash
+}
+
+// readOpts returns the options for reading the file f, or nil if there is
+// nothing to do besides digesting it.
+func (h *Hasher) readOpts(f file) *readOpts {
+	var o readOpts
+	if h.TruncateToWalkSize {
+		o.capped, o.limit = true, f.info.Size()
+	}
+	if h.Transform != nil && f.info.Size() <= h.transformBuffer() {
+		o.keep = transformBufs.Get().(*bytes.Buffer)
+	}
+	if h.ChunkSize > 0 && !h.NormalizeEOL && f.info.Size() >= h.ChunkThreshold {
+		o.chunks = newChunker(h.ChunkSize, h.newHash)
+	}
+	if len(h.ExtraHashes) > 0 {
+		o.hashes = make(map[string]hash.Hash, len(h.ExtraHashes))
+		for name, newHash := range h.ExtraHashes {
+			// The hash of the Hasher is not computed twice.
+			if name != hashName(h.Hash) {
+				o.hashes[name] = newHash()
+			}
+		}
+	}
+	if !o.capped && o.keep == nil && o.chunks == nil && o.hashes == nil {
+		return nil
+	}
+	return &o
+}
+
+// reset readies o for a new read of the file.
+func (o *readOpts) reset() {
+	if o == nil {
+		return
+	}
+	o.grown = false
+	if o.keep != nil {
+		o.keep.Reset()
+	}
+	o.chunks.reset()
+	for _, d := range o.hashes {
+		d.Reset()
+	}
+}
+
+// tee returns a writer writing to dst, and to whatever o has receive the bytes
+// hashed.
+func (o *readOpts) tee(dst io.Writer) io.Writer {
+	if o == nil {
+		return dst
+	}
+	if len(o.hashes) > 0 {
+		ws := []io.Writer{dst}
+		for _, d := range o.hashes {
+			ws = append(ws, d)
+		}
+		dst = io.MultiWriter(ws...)
+	}
+	switch {
+	case o.keep != nil && o.chunks != nil:
+		return io.MultiWriter(dst, o.keep, o.chunks)
+	case o.keep != nil:
+		return io.MultiWriter(dst, o.keep)
+	case o.chunks != nil:
+		return io.MultiWriter(dst, o.chunks)
+	}
+	return dst
+}
+
+// sums returns the digests of o.hashes, along with sum, the digest of the
+// Hasher, named algo, or nil if o has no hashes.
+func (o *readOpts) sums(algo string, sum Digest) map[string]Digest {
+	if o == nil || o.hashes == nil {
+		return nil
+	}
+	sums := make(map[string]Digest, len(o.hashes)+1)
+	for name, d := range o.hashes {
+		sums[name] = d.Sum(nil)
+	}
+	if algo != "" {
+		sums[algo] = sum
+	}
+	return sums
+}
@@ -0,0 +1,47 @@
+package checksum
+
+import "crypto/md5"
+
+// ReconcilePlan compares the file tree rooted at root with manifest, a map from
+// path to MD5 sum such as MD5All returns, and lists what a sync tool must do to
+// make the tree match it: the files to fetch again because their content
+// changed, the files of the tree to delete because the manifest does not list
+// them, and the files of the manifest to fetch because the tree lacks them.
+// Each list is sorted. If the directory walk fails or any read operation
+// fails, ReconcilePlan returns an error.
+//
+// ReconcilePlan uses a zero Hasher; see Hasher.ReconcilePlan.
+func ReconcilePlan(root string, manifest map[string][md5.Size]byte) (toFetch, toDelete, missing []string, err error) {
+	return new(Hasher).ReconcilePlan(root, manifest)
+}
+
+// ReconcilePlan is like the package-level ReconcilePlan, but reads the files
+// through h. It always computes MD5 sums, whatever h.Hash and h.HashSelector
+// are, and lists every file missing from the manifest in toDelete, whatever
+// h.StrictExtra is.
+func (h *Hasher) ReconcilePlan(root string, manifest map[string][md5.Size]byte) (toFetch, toDelete, missing []string, err error) {
+	md5h := h.md5Hasher()
+
+	m := make(map[string]Digest, len(manifest))
+	for path, sum := range manifest {
+		m[path] = append(Digest(nil), sum[:]...)
+	}
+
+	mismatches, err := md5h.Verify(root, m)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, mm := range mismatches {
+		switch mm.Kind {
+		case Changed:
+			toFetch = append(toFetch, mm.Path)
+		case Extra:
+			toDelete = append(toDelete, mm.Path)
+		case Missing:
+			missing = append(missing, mm.Path)
+		}
+	}
+
+	return toFetch, toDelete, missing, nil
+}
@@ -0,0 +1,40 @@
+package checksum
+
+// A Scan is a run of MD5All in progress, started by Hasher.Scan, whose
+// progress can be sampled while it runs.
+type Scan struct {
+	j    *job
+	done chan struct{} // closed once the run has returned
+
+	m   ResultSet
+	err error
+}
+
+// Scan starts MD5All on the tree rooted at root in the background and returns
+// a handle to it. The run's results are only available from Wait, but its
+// progress can be followed with Stats in the meantime.
+func (h *Hasher) Scan(root string) *Scan {
+	s := &Scan{j: h.newJob(), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		done := make(chan struct{})
+		defer close(done)
+		s.m, s.err = h.md5All(done, root, s.j)
+	}()
+	return s
+}
+
+// Stats returns statistics about the scan so far: Files, Bytes and Failed,
+// counted as the digesters go, and the timings if Hasher.Profile is set. The
+// other fields are only filled in, in Hasher.Stats, once the scan is over.
+// Stats may be called from any goroutine, while the scan runs or after it
+// has returned.
+func (s *Scan) Stats() Stats {
+	return s.j.stats()
+}
+
+// Wait waits for the scan to return, and returns what MD5All would have.
+func (s *Scan) Wait() (ResultSet, error) {
+	<-s.done
+	return s.m, s.err
+}
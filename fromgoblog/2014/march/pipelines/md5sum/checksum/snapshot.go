@@ -0,0 +1,141 @@
+package checksum
+
+import (
+	"os"
+	"sort"
+)
+
+// A Snapshot records the state of the files of a tree at the time it was
+// scanned, keyed by path.
+type Snapshot map[string]FileState
+
+// NewSnapshot returns the snapshot holding states, as returned by ScanStates.
+func NewSnapshot(states []FileState) Snapshot {
+	s := make(Snapshot, len(states))
+	for _, st := range states {
+		s[st.Path] = st
+	}
+	return s
+}
+
+// lookup returns the digest s records for the file at path, if the file still
+// has the size and modification time it had when s was taken.
+func (s Snapshot) lookup(path string, info os.FileInfo) (Digest, bool) {
+	st, ok := s[path]
+	if !ok || st.Size != info.Size() || !st.ModTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	return st.Sum, true
+}
+
+// ScanDelta scans the file tree rooted at root and reports how it changed since
+// previous was taken. Only files that are new, or whose size or modification
+// time differs from previous, are read; the digests of the others are taken
+// from previous. It returns the results for the new files, those for the files
+// whose size, modification time or digest changed, and the paths of the files
+// in previous that are gone, each sorted by path. A file in changed may have
+// been touched without being modified, which FileState.Touched tells apart. If
+// the directory walk fails or any read operation fails, ScanDelta returns an
+// error.
+//
+// ScanDelta uses a zero Hasher; see Hasher.ScanDelta.
+func ScanDelta(root string, previous Snapshot) (added, changed []Result, removed []string, err error) {
+	return new(Hasher).ScanDelta(root, previous)
+}
+
+// ScanDelta is like the package-level ScanDelta, but reads the files through h,
+// which must digest them the same way as the Hasher that took previous.
+func (h *Hasher) ScanDelta(root string, previous Snapshot) (added, changed []Result, removed []string, err error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	j := h.newJob()
+	j.prev = previous
+
+	seen := make(map[string]bool)
+	err = h.collectJob(done, root, j, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+
+		seen[r.Path] = true
+		prev, ok := previous[r.Path]
+		if st := r.State(); !ok {
+			added = append(added, r)
+		} else if st.ContentChanged(prev) || st.Touched(prev) {
+			changed = append(changed, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for path := range previous {
+		if !seen[path] {
+			removed = append(removed, path)
+		}
+	}
+
+	sort.Slice(added, func(i, j int) bool { return added[i].Path < added[j].Path })
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Path < changed[j].Path })
+	sort.Strings(removed)
+
+	return added, changed, removed, nil
+}
+
+// A SnapshotDiff lists how the files of a tree changed between two snapshots,
+// by path, each list sorted.
+type SnapshotDiff struct {
+	// Added and Removed are the files only in the newer and only in the
+	// older snapshot.
+	Added   []string
+	Removed []string
+
+	// Changed are the files whose content changed, along with those whose
+	// modification time alone changed unless Hasher.ContentOnlyDiff is
+	// set. MetadataOnly are the files whose modification time changed but
+	// whose content did not, whether or not they are in Changed.
+	Changed      []string
+	MetadataOnly []string
+}
+
+// DiffSnapshots compares the snapshots prev and cur of a tree and returns how
+// its files changed from prev to cur.
+//
+// DiffSnapshots uses a zero Hasher; see Hasher.DiffSnapshots.
+func DiffSnapshots(prev, cur Snapshot) SnapshotDiff {
+	return new(Hasher).DiffSnapshots(prev, cur)
+}
+
+// DiffSnapshots is like the package-level DiffSnapshots, but follows
+// h.ContentOnlyDiff.
+func (h *Hasher) DiffSnapshots(prev, cur Snapshot) SnapshotDiff {
+	var d SnapshotDiff
+	for path, st := range cur {
+		old, ok := prev[path]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, path)
+		case st.ContentChanged(old):
+			d.Changed = append(d.Changed, path)
+		case st.Touched(old):
+			d.MetadataOnly = append(d.MetadataOnly, path)
+			if !h.ContentOnlyDiff {
+				d.Changed = append(d.Changed, path)
+			}
+		}
+	}
+	for path := range prev {
+		if _, ok := cur[path]; !ok {
+			d.Removed = append(d.Removed, path)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	sort.Strings(d.MetadataOnly)
+
+	return d
+}
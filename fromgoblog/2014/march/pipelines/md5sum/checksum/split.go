@@ -0,0 +1,92 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// splitPart is a part of a split file, along with the number of its suffix.
+type splitPart struct {
+	path string
+	n    int
+}
+
+// HashSplit returns the digest, computed with newHash, or with md5.New if
+// newHash is nil, of the file split into the parts matching the
+// filepath.Glob pattern, such as "backup.tar.*" for "backup.tar.001",
+// "backup.tar.002" and so on. The parts are read in the numeric order of
+// their suffix, so the digest is that of the reassembled file, which need
+// not exist on disk. The numbering may start at 0 or 1, and its width does
+// not matter, but it must have no gaps. If no file matches, a suffix is not
+// a number, a part is missing, or a part cannot be read, HashSplit returns an
+// error.
+func HashSplit(pattern string, newHash func() hash.Hash) ([]byte, error) {
+	if newHash == nil {
+		newHash = md5.New
+	}
+
+	parts, err := splitParts(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	readers := make([]io.Reader, len(parts))
+	for i, p := range parts {
+		f, err := os.Open(p.path)
+		if err != nil {
+			return nil, &ReadError{p.path, err}
+		}
+		defer f.Close()
+		readers[i] = f
+	}
+
+	d := newHash()
+	if _, err := io.Copy(d, io.MultiReader(readers...)); err != nil {
+		return nil, &ReadError{pattern, err}
+	}
+	return d.Sum(nil), nil
+}
+
+// splitParts returns the parts matching pattern sorted by the number of their
+// suffix, checking that the numbers follow each other.
+func splitParts(pattern string) ([]splitPart, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("checksum: no part matches %q", pattern)
+	}
+
+	parts := make([]splitPart, len(paths))
+	for i, path := range paths {
+		n, err := strconv.Atoi(strings.TrimPrefix(filepath.Ext(path), "."))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("checksum: split part %s has no numeric suffix", path)
+		}
+		parts[i] = splitPart{path, n}
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].n < parts[j].n })
+
+	if first := parts[0].n; first > 1 {
+		return nil, fmt.Errorf("checksum: split parts of %q start at %d, parts before it are missing", pattern, first)
+	}
+	for i := 1; i < len(parts); i++ {
+		switch prev, n := parts[i-1].n, parts[i].n; {
+		case n == prev:
+			return nil, fmt.Errorf("checksum: split parts %s and %s have the same number", parts[i-1].path, parts[i].path)
+		case n != prev+1:
+			return nil, fmt.Errorf("checksum: split part %d of %q is missing", prev+1, pattern)
+		}
+	}
+
+	return parts, nil
+}
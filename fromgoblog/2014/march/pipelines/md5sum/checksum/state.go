@@ -0,0 +1,79 @@
+package checksum
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"time"
+)
+
+// FileState is the state of a single regular file at the time it was scanned:
+// its path, size and modification time as seen by the walk, and the digest of
+// its contents. Symlink is set if the path is a symbolic link digested
+// according to Hasher.SymlinkMode.
+type FileState struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Sum     Digest    `json:"sum"`
+	Symlink bool      `json:"symlink,omitempty"`
+	Algo    string    `json:"algo,omitempty"`
+}
+
+// State returns the state of the file described by r, which must not hold an
+// error.
+func (r Result) State() FileState {
+	return FileState{r.Path, r.Size, r.ModTime, r.Sum, r.Symlink, r.Algo}
+}
+
+// ContentChanged reports whether the contents of the file differ between prev
+// and s, judging by size and digest.
+func (s FileState) ContentChanged(prev FileState) bool {
+	return s.Size != prev.Size || !bytes.Equal(s.Sum, prev.Sum)
+}
+
+// Touched reports whether the file's modification time changed between prev
+// and s while its contents stayed the same, as happens when a file is touched
+// without being modified.
+func (s FileState) Touched(prev FileState) bool {
+	return !s.ContentChanged(prev) && !s.ModTime.Equal(prev.ModTime)
+}
+
+// ScanStates reads all the files in the file tree rooted at root and returns
+// the state of each one, sorted by path. If the directory walk fails or any
+// read operation fails, ScanStates returns an error. If Hasher.Deadline
+// passes, ScanStates returns the states computed so far along with
+// ErrDeadlineExceeded.
+//
+// ScanStates uses a zero Hasher; see Hasher.ScanStates.
+func ScanStates(root string) ([]FileState, error) {
+	return new(Hasher).ScanStates(root)
+}
+
+// ScanStates is like the package-level ScanStates, but reads the files through
+// h. If h.ErrorPolicy is KeepGoing, ScanStates returns the states of the files
+// that could be read along with ReadErrors.
+func (h *Hasher) ScanStates(root string) ([]FileState, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	var states []FileState
+	var errs ReadErrors
+	err := h.collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return h.readFailed(&errs, r)
+		}
+		states = append(states, r.State())
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrDeadlineExceeded) {
+		return nil, err
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Path < states[j].Path })
+
+	if err == nil {
+		err = errs.err()
+	}
+	return states, err
+}
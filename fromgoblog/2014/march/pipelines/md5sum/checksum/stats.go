@@ -0,0 +1,236 @@
+package checksum
+
+import (
+	"container/heap"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds statistics about a single run of a Hasher.
+type Stats struct {
+	// Files is the number of files digested, and Bytes the sum of their
+	// sizes as seen by the walk. Failed is the number of files that could
+	// not be read.
+	Files  int
+	Bytes  int64
+	Failed int
+
+	// Reverified is the number of files read a second time because of
+	// Hasher.VerifyReadsSample, and Unstable lists the paths of those whose
+	// second read failed or produced a different digest.
+	Reverified int
+	Unstable   []string
+
+	// Locked lists the paths of the files skipped because another process
+	// held them locked, as Hasher.SkipLocked allows.
+	Locked []string
+
+	// Modified lists the paths of the files that changed while they were
+	// being read, as detected with Hasher.DetectModified.
+	Modified []string
+
+	// InvalidNames lists, when Hasher.InvalidNames is set, the paths of
+	// the files and directories whose name is not valid UTF-8, as found by
+	// the walk and before any escaping, in the order they were found.
+	// Printing them with %q shows the offending bytes.
+	InvalidNames []string
+
+	// OutsideRoot lists the paths of the symbolic links skipped because
+	// their target lies outside the root, as Hasher.ConfineToRoot asks.
+	OutsideRoot []string
+
+	// SymlinkLoops lists the paths of the symbolic links skipped because
+	// they point to a directory the walk was inside, with
+	// SymlinkFollowAll.
+	SymlinkLoops []string
+
+	// SkippedSpecial lists the paths of the sockets, named pipes and
+	// devices the walk skipped, which Hasher.ArchiveMode records instead,
+	// except for sockets.
+	SkippedSpecial []string
+
+	// Slowest lists the Hasher.TopSlowest files that took longest to read
+	// and digest, slowest first.
+	Slowest []SlowFile
+
+	// Collisions lists the keys Hasher.KeyFunc produced for more than one
+	// path, sorted by key.
+	Collisions []KeyCollision
+
+	// DigestCollisions lists the digests ByDigest found for files of
+	// different sizes, which must therefore differ in content, sorted by
+	// digest. It is only filled in by ByDigest.
+	DigestCollisions []DigestCollision
+
+	// WalkTime, ReadTime and HashTime are only filled in when
+	// Hasher.Profile is set. WalkTime is the time the walk spent listing
+	// directories and inspecting files, leaving out the time it waited for
+	// the digesters to keep up. ReadTime and HashTime are the time the
+	// digesters spent reading files and hashing what they read, added up
+	// over all of them, so they can exceed the duration of the run.
+	WalkTime time.Duration
+	ReadTime time.Duration
+	HashTime time.Duration
+}
+
+// add accounts for r in s.
+func (s *Stats) add(r Result) {
+	if r.locked {
+		s.Locked = append(s.Locked, r.Path)
+		return
+	}
+	if r.Err != nil {
+		s.Failed++
+		return
+	}
+	s.Files++
+	s.Bytes += r.Size
+
+	if r.reverified {
+		s.Reverified++
+	}
+	if r.unstable {
+		s.Unstable = append(s.Unstable, r.Path)
+	}
+	if r.Modified {
+		s.Modified = append(s.Modified, r.Path)
+	}
+}
+
+// setProfile fills in the timings of s from the profiled run j.
+func (s *Stats) setProfile(j *job) {
+	s.WalkTime = time.Duration(atomic.LoadInt64(&j.walkTime))
+	s.ReadTime = time.Duration(atomic.LoadInt64(&j.readTime))
+	s.HashTime = time.Duration(atomic.LoadInt64(&j.hashTime))
+}
+
+// A SlowFile is a file listed in Stats.Slowest, along with the time it took to
+// read and digest.
+type SlowFile struct {
+	Path     string
+	Duration time.Duration
+}
+
+// slowHeap keeps the n slowest files seen so far in a min-heap, so that the
+// fastest of them is the one to make room.
+type slowHeap struct {
+	n     int
+	files []SlowFile
+}
+
+func (h *slowHeap) Len() int           { return len(h.files) }
+func (h *slowHeap) Less(i, j int) bool { return h.files[i].Duration < h.files[j].Duration }
+func (h *slowHeap) Swap(i, j int)      { h.files[i], h.files[j] = h.files[j], h.files[i] }
+func (h *slowHeap) Push(x interface{}) { h.files = append(h.files, x.(SlowFile)) }
+func (h *slowHeap) Pop() interface{} {
+	x := h.files[len(h.files)-1]
+	h.files = h.files[:len(h.files)-1]
+	return x
+}
+
+// add accounts for f, keeping it if it is among the n slowest files so far.
+func (h *slowHeap) add(f SlowFile) {
+	if len(h.files) < h.n {
+		heap.Push(h, f)
+	} else if f.Duration > h.files[0].Duration {
+		h.files[0] = f
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the files in h, slowest first.
+func (h *slowHeap) sorted() []SlowFile {
+	if len(h.files) == 0 {
+		return nil
+	}
+	files := append([]SlowFile(nil), h.files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Duration > files[j].Duration })
+	return files
+}
+
+// A DigestCollision is a digest shared by files that cannot have the same
+// content, since their sizes differ: a true collision of the hash, which
+// happens with short checksums such as CRC-32 on large trees, but is not
+// expected of a cryptographic hash. Paths are sorted.
+type DigestCollision struct {
+	Digest string
+	Paths  []string
+}
+
+// A KeyCollision is a key Hasher.KeyFunc produced for several paths, such as
+// an NFC-normalized name found in both NFC and NFD forms. Paths are sorted.
+type KeyCollision struct {
+	Key   string
+	Paths []string
+}
+
+// collisions tracks the paths behind each key of a run, to find the keys that
+// collide.
+type collisions struct {
+	first map[string]string   // first path seen for each key
+	more  map[string][]string // all the paths of colliding keys
+}
+
+func newCollisions() *collisions {
+	return &collisions{first: make(map[string]string), more: make(map[string][]string)}
+}
+
+// add records that path was reported as key.
+func (c *collisions) add(key, path string) {
+	first, ok := c.first[key]
+	switch {
+	case !ok:
+		c.first[key] = path
+	case first == path:
+	case c.more[key] == nil:
+		c.more[key] = []string{first, path}
+	default:
+		c.more[key] = append(c.more[key], path)
+	}
+}
+
+// sorted returns the colliding keys, sorted.
+func (c *collisions) sorted() []KeyCollision {
+	var kc []KeyCollision
+	for key, paths := range c.more {
+		sort.Strings(paths)
+		kc = append(kc, KeyCollision{key, paths})
+	}
+	sort.Slice(kc, func(i, j int) bool { return kc[i].Key < kc[j].Key })
+	return kc
+}
+
+// ScanState describes the progress of a scan while it is running, as passed
+// to Hasher.SkipFunc.
+type ScanState struct {
+	// Walked is the number of files the walk has sent to be digested so
+	// far, and WalkedBytes the sum of their sizes. Skipped is the number of
+	// files skipped by SkipFunc.
+	Walked      int
+	WalkedBytes int64
+	Skipped     int
+
+	// Digested is the number of files digested so far, and DigestedBytes
+	// the sum of their sizes. Digesting lags behind the walk.
+	Digested      int
+	DigestedBytes int64
+}
+
+// note appends path to the list l of j, under j.mu.
+func (j *job) note(l *[]string, path string) {
+	j.mu.Lock()
+	*l = append(*l, path)
+	j.mu.Unlock()
+}
+
+// noted returns a copy of the list l of j, read under j.mu, or nil if it is
+// empty.
+func (j *job) noted(l *[]string) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(*l) == 0 {
+		return nil
+	}
+	return append([]string(nil), *l...)
+}
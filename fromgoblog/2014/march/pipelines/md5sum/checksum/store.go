@@ -0,0 +1,193 @@
+package checksum
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A ManifestStore holds the expected digests of a manifest, for VerifyStore.
+// Lookup returns the digest of path, if the manifest has one, and Range calls
+// fn for every entry of the manifest, in no particular order, until fn
+// returns false. A store that can fail, such as one reading a file, should
+// also have an Err method returning the first error it hit, which VerifyStore
+// then reports.
+type ManifestStore interface {
+	Lookup(path string) ([]byte, bool)
+	Range(fn func(path string, sum []byte) bool)
+}
+
+// ManifestMap is a ManifestStore held in memory, as returned by
+// ParseManifest.
+type ManifestMap map[string]Digest
+
+// Lookup implements ManifestStore.
+func (m ManifestMap) Lookup(path string) ([]byte, bool) {
+	sum, ok := m[path]
+	return sum, ok
+}
+
+// Range implements ManifestStore.
+func (m ManifestMap) Range(fn func(path string, sum []byte) bool) {
+	for path, sum := range m {
+		if !fn(path, sum) {
+			return
+		}
+	}
+}
+
+// A ManifestFile is a ManifestStore reading a manifest file sorted by path in
+// byte order, as ScanToFile writes them, without loading it: Lookup finds an
+// entry by binary search over the file, and Range reads it through. Only a
+// few lines are held in memory at a time, so a ManifestFile lets trees be
+// verified against manifests too large to parse into a map. Its methods may
+// be called concurrently.
+type ManifestFile struct {
+	f     *os.File
+	start int64 // offset of the first entry, past any header
+	size  int64
+
+	mu  sync.Mutex
+	err error // first error reading the file
+}
+
+// OpenManifestFile opens the sorted manifest file at path as a ManifestStore.
+// The caller must close it once done.
+func OpenManifestFile(path string) (*ManifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	m := &ManifestFile{f: f, size: info.Size()}
+	line, next, err := m.lineAt(0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, ok, err := parseManifestHeader(line); err != nil {
+		f.Close()
+		return nil, &ParseError{1, err}
+	} else if ok {
+		m.start = next
+	}
+	return m, nil
+}
+
+// Close closes the manifest file.
+func (m *ManifestFile) Close() error {
+	return m.f.Close()
+}
+
+// Err returns the first error reading or parsing the manifest file, if any.
+func (m *ManifestFile) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// fail records err as the error of m, unless it already has one.
+func (m *ManifestFile) fail(err error) {
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.mu.Unlock()
+}
+
+// Lookup implements ManifestStore.
+func (m *ManifestFile) Lookup(path string) ([]byte, bool) {
+	// lo is always the start of a line; the entry for path, if any,
+	// starts in [lo, hi).
+	lo, hi := m.start, m.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := m.lineStart(mid)
+		if err != nil {
+			m.fail(err)
+			return nil, false
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		line, next, err := m.lineAt(start)
+		if err != nil {
+			m.fail(err)
+			return nil, false
+		}
+		p, sum, err := parseManifestLine(line)
+		if err != nil {
+			m.fail(err)
+			return nil, false
+		}
+		switch {
+		case p < path:
+			lo = next
+		case p > path:
+			hi = start
+		default:
+			return sum, true
+		}
+	}
+	return nil, false
+}
+
+// Range implements ManifestStore.
+func (m *ManifestFile) Range(fn func(path string, sum []byte) bool) {
+	mr := newManifestReader(io.NewSectionReader(m.f, 0, m.size))
+	for {
+		path, sum, err := mr.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		if !fn(path, sum) {
+			return
+		}
+	}
+}
+
+// lineStart returns the offset of the first line starting at or after off.
+func (m *ManifestFile) lineStart(off int64) (int64, error) {
+	if off <= m.start {
+		return m.start, nil
+	}
+	// The line starts at off if the byte before it ends a line.
+	_, next, err := m.lineAt(off - 1)
+	return next, err
+}
+
+// lineAt returns the line starting at off, without its newline, and the offset
+// of the line after it.
+func (m *ManifestFile) lineAt(off int64) (string, int64, error) {
+	var line []byte
+	buf := make([]byte, 512)
+	for pos := off; pos < m.size; {
+		n, err := m.f.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			line = append(line, buf[:i]...)
+			return strings.TrimSuffix(string(line), "\r"), pos + int64(i) + 1, nil
+		}
+		line = append(line, buf[:n]...)
+		pos += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), m.size, nil
+}
@@ -0,0 +1,130 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"hash"
+)
+
+// Stream starts digesting all the files in the file tree rooted at root and
+// returns a channel on which the results are sent as soon as they are
+// computed, in no particular order. A file that cannot be read does not stop
+// the scan: its result is sent with Err set. The result channel is closed once
+// every file has been digested, after which the result of the walk is sent on
+// the error channel.
+//
+// The caller must either receive from the result channel until it is closed
+// or cancel ctx. If ctx is canceled, Stream abandons its work and the error
+// channel reports ctx.Err().
+//
+// Stream uses a zero Hasher; see Hasher.Stream.
+func Stream(ctx context.Context, root string) (<-chan Result, <-chan error) {
+	return new(Hasher).Stream(ctx, root)
+}
+
+// Stream is like the package-level Stream, but reads the files through h.
+func (h *Hasher) Stream(ctx context.Context, root string) (<-chan Result, <-chan error) {
+	out := make(chan Result)
+	errc := make(chan error, 1)
+
+	go func() {
+		err := h.StreamInto(ctx, root, out)
+
+		close(out)
+
+		// No select needed here, since errc is buffered.
+		errc <- err
+	}()
+
+	return out, errc
+}
+
+// StreamInto is like Stream, but sends the results on out, a channel owned by
+// the caller, such as one shared with other stages of a larger pipeline, and
+// returns the result of the walk once every file has been digested, or
+// ctx.Err() if ctx is canceled first. StreamInto never closes out: the caller
+// closes it, if it needs to, once StreamInto has returned, and no other sender
+// is left. Whoever receives from out must keep doing so until StreamInto
+// returns, or the caller must cancel ctx.
+//
+// StreamInto uses a zero Hasher; see Hasher.StreamInto.
+func StreamInto(ctx context.Context, root string, out chan<- Result) error {
+	return new(Hasher).StreamInto(ctx, root, out)
+}
+
+// StreamInto is like the package-level StreamInto, but reads the files through
+// h.
+func (h *Hasher) StreamInto(ctx context.Context, root string, out chan<- Result) error {
+	err := h.collect(ctx.Done(), root, func(r Result) error {
+		select {
+		case out <- r:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if errors.Is(err, ErrWalkCanceled) {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// Run is MD5All with a context, for the common case that needs neither the
+// results as they come nor a channel to drain: it digests all the files in the
+// file tree rooted at root and returns their MD5 sums, or a single error, that
+// of the first file that could not be read, or else that of the walk. If ctx
+// is canceled, Run abandons its work and returns ctx.Err(). Stream and
+// StreamInto remain for callers who want each result as it is ready.
+//
+// Run uses a zero Hasher; see Hasher.Run.
+func Run(ctx context.Context, root string) (ResultSet, error) {
+	return new(Hasher).Run(ctx, root)
+}
+
+// Run is like the package-level Run, but reads the files through h, as
+// Hasher.MD5All does, following h.ErrorPolicy.
+func (h *Hasher) Run(ctx context.Context, root string) (ResultSet, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m, err := h.md5All(ctx.Done(), root, h.newJob())
+	if errors.Is(err, ErrWalkCanceled) {
+		return nil, ctx.Err()
+	}
+	return m, err
+}
+
+// SumAll is Run for any hash: it digests all the files in the file tree rooted
+// at root with newHash and returns a map from file path to digest, or the
+// error of the first file that could not be read, or else that of the walk. If
+// ctx is canceled, SumAll abandons its work and returns ctx.Err().
+//
+// SumAll uses a zero Hasher; see Hasher.SumAll.
+func SumAll(ctx context.Context, root string, newHash func() hash.Hash) (map[string]Digest, error) {
+	return (&Hasher{Hash: newHash}).SumAll(ctx, root)
+}
+
+// SumAll is like the package-level SumAll, but reads the files through h and
+// digests them with h.Hash. If h.ErrorPolicy is KeepGoing, SumAll returns the
+// digests of the files that could be read along with ReadErrors.
+func (h *Hasher) SumAll(ctx context.Context, root string) (map[string]Digest, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m := make(map[string]Digest)
+	var errs ReadErrors
+	err := h.collect(ctx.Done(), root, func(r Result) error {
+		if r.Err != nil {
+			return h.readFailed(&errs, r)
+		}
+		m[r.Path] = r.Sum
+		return nil
+	})
+	if errors.Is(err, ErrWalkCanceled) {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, errs.err()
+}
@@ -0,0 +1,275 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StreamToFile reads all the files in the file tree rooted at root and appends
+// a manifest of their MD5 sums to manifestPath as it goes, in the format
+// ScanToFile writes, but with the files in the order of the walk rather than
+// sorted. Unlike ScanToFile, it makes the manifest durable while the scan goes
+// on: the manifest is flushed and synced to disk every Hasher.FsyncEvery
+// results and every Hasher.FsyncInterval, so that a crash only loses the
+// results of the last window.
+//
+// If manifestPath already holds results, such as those of a run that crashed,
+// StreamToFile drops any partial line at its end and resumes the scan after
+// the last file it lists, as Hasher.ResumeAfter would, appending the results
+// of the files left. This only resumes correctly if the tree has not changed
+// before that file since. A new manifest starts with ManifestHeader.
+//
+// The results are written in the order of the walk, so the digesters may run
+// ahead of the file being written, keeping the results of the files after it
+// in memory until it is done. If the walk fails, any read operation fails, a
+// path holds a line break (see ErrManifestPath), or ctx is canceled,
+// StreamToFile syncs the results written so far, so that a later call can
+// resume from them, and returns an error.
+//
+// StreamToFile uses a zero Hasher; see Hasher.StreamToFile.
+func StreamToFile(ctx context.Context, root, manifestPath string) error {
+	return new(Hasher).StreamToFile(ctx, root, manifestPath)
+}
+
+// StreamToFile is like the package-level StreamToFile, but reads the files
+// through h. If h.ResumeAfter is set, the scan resumes after it rather than
+// after the last file of the manifest.
+func (h *Hasher) StreamToFile(ctx context.Context, root, manifestPath string) (err error) {
+	f, err := os.OpenFile(manifestPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	last, err := resumeManifest(f)
+	if err != nil {
+		return err
+	}
+
+	// Keep the scan from reading its own output.
+	sh := *h
+	sh.ExcludePaths = append(append([]string(nil), h.ExcludePaths...), manifestPath)
+	if sh.ResumeAfter == "" {
+		sh.ResumeAfter = last
+	}
+
+	w := newSyncWriter(f, h.FsyncEvery)
+	if h.FsyncInterval > 0 {
+		stop := w.syncEvery(h.FsyncInterval)
+		defer stop()
+	}
+	defer func() {
+		if serr := w.sync(); err == nil {
+			err = serr
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	j := sh.newJob()
+	j.ordered = true
+	err = sh.collectJob(ctx.Done(), root, j, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		line, err := manifestLine(r)
+		if err != nil {
+			return err
+		}
+		return w.write(line)
+	})
+	if errors.Is(err, ErrWalkCanceled) {
+		return ctx.Err()
+	}
+	return err
+}
+
+// resumeManifest prepares the manifest open as f for appending and returns the
+// path of the last file it lists, if any. A new manifest is given its header,
+// and a partial line at the end of an existing one is dropped.
+func resumeManifest(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	end, line, err := lastLine(f, info.Size())
+	if err != nil {
+		return "", err
+	}
+	if end < info.Size() {
+		if err := f.Truncate(end); err != nil {
+			return "", err
+		}
+	}
+	if end == 0 {
+		_, err := io.WriteString(f, ManifestHeader+"\n")
+		return "", err
+	}
+
+	if _, ok, err := parseManifestHeader(line); err != nil {
+		return "", err
+	} else if ok {
+		return "", nil
+	}
+	path, _, err := parseManifestLine(line)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// lastLine returns the offset just past the last newline of the first size
+// bytes of f, and the line that newline ends, without it. If there is no
+// newline, it returns 0 and an empty line.
+func lastLine(f *os.File, size int64) (int64, string, error) {
+	const block = 4096
+
+	var tail []byte
+	end := int64(-1)
+	for off := size; off > 0; {
+		n := int64(block)
+		if n > off {
+			n = off
+		}
+		off -= n
+
+		buf := make([]byte, n)
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return 0, "", err
+		}
+		tail = append(buf, tail...)
+
+		if end < 0 {
+			i := bytes.LastIndexByte(tail, '\n')
+			if i < 0 {
+				continue
+			}
+			end = off + int64(i) + 1
+			tail = tail[:i]
+		}
+		if i := bytes.LastIndexByte(tail, '\n'); i >= 0 || off == 0 {
+			return end, strings.TrimSuffix(string(tail[i+1:]), "\r"), nil
+		}
+	}
+	return 0, "", nil
+}
+
+// syncWriter writes manifest lines to a file through a buffer, and flushes
+// the buffer and syncs the file every n lines, and whenever sync is called.
+// It is safe for concurrent use.
+type syncWriter struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	n     int
+	dirty int   // lines written since the last sync
+	err   error // first error writing or syncing
+}
+
+func newSyncWriter(f *os.File, n int) *syncWriter {
+	return &syncWriter{f: f, w: bufio.NewWriter(f), n: n}
+}
+
+// write writes line, and syncs if it is the nth since the last sync.
+func (s *syncWriter) write(line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if _, s.err = s.w.WriteString(line); s.err != nil {
+		return s.err
+	}
+	s.dirty++
+	if s.n > 0 && s.dirty >= s.n {
+		return s.syncLocked()
+	}
+	return nil
+}
+
+// sync flushes the lines written so far and syncs the file.
+func (s *syncWriter) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncLocked()
+}
+
+func (s *syncWriter) syncLocked() error {
+	if s.err != nil || s.dirty == 0 {
+		return s.err
+	}
+	if s.err = s.w.Flush(); s.err == nil {
+		s.err = s.f.Sync()
+	}
+	s.dirty = 0
+	return s.err
+}
+
+// syncEvery starts a goroutine syncing s every d, so that the lines written
+// are made durable even while no new one comes, and returns a function
+// stopping it. An error syncing is reported by the next write.
+func (s *syncWriter) syncEvery(d time.Duration) (stop func()) {
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tick := time.NewTicker(d)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				s.sync()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		wg.Wait()
+	}
+}
+
+// reorderer puts back the results of a run in the order the walk sent the
+// files, for runs whose job is ordered.
+type reorderer struct {
+	next    int64
+	pending map[int64]Result
+}
+
+func newReorderer() *reorderer {
+	return &reorderer{pending: make(map[int64]Result)}
+}
+
+// add takes the results of batch, and returns those that are now next in the
+// order of the walk, in that order, holding back the others.
+func (o *reorderer) add(batch []Result) []Result {
+	for _, r := range batch {
+		o.pending[r.seq] = r
+	}
+
+	var ready []Result
+	for {
+		r, ok := o.pending[o.next]
+		if !ok {
+			return ready
+		}
+		delete(o.pending, o.next)
+		o.next++
+		ready = append(ready, r)
+	}
+}
@@ -0,0 +1,85 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// VerifySubset is like Verify, but only reads and checks the files at paths,
+// such as the files a change touched, rather than walking the whole tree.
+// Paths are given in the same form as the keys of manifest, a map from path to
+// MD5 sum such as MD5All returns. Listed files missing from the manifest are
+// reported as Extra, and listed paths that no longer exist as Missing; the
+// rest of the manifest is not looked at. Listed directories, and files the
+// Hasher's filters skip, are left out. If any read operation fails,
+// VerifySubset returns an error.
+//
+// VerifySubset uses a zero Hasher; see Hasher.VerifySubset.
+func VerifySubset(root string, manifest map[string][md5.Size]byte, paths []string) ([]Mismatch, error) {
+	return new(Hasher).VerifySubset(root, manifest, paths)
+}
+
+// VerifySubset is like the package-level VerifySubset, but reads the files
+// through h. It always computes MD5 sums, whatever h.Hash and h.HashSelector
+// are.
+func (h *Hasher) VerifySubset(root string, manifest map[string][md5.Size]byte, paths []string) ([]Mismatch, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	md5h := h.md5Hasher()
+
+	j := md5h.newJob()
+	j.paths = append([]string{}, paths...)
+
+	var mismatches []Mismatch
+	err := md5h.collectJob(done, root, j, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+
+		want, ok := manifest[r.Path]
+		if !ok {
+			mismatches = append(mismatches, h.mismatch(r.Path, Extra, nil, r.Sum))
+		} else if !h.match(want[:], r.Sum) {
+			mismatches = append(mismatches, h.mismatch(r.Path, Changed, want[:], r.Sum))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range j.missing {
+		key := h.key(path)
+		var want []byte
+		if sum, ok := manifest[key]; ok {
+			want = sum[:]
+		}
+		mismatches = append(mismatches, h.mismatch(key, Missing, want, nil))
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+
+	return mismatches, nil
+}
+
+// walkPaths calls fn for each of paths, as filepath.Walk would for a tree
+// holding nothing else, and returns the paths that do not exist, which fn is
+// not called for. It stops at the first error fn returns other than
+// filepath.SkipDir.
+func walkPaths(paths []string, fsys fs.FS, fn filepath.WalkFunc) (missing []string, err error) {
+	for _, path := range paths {
+		info, err := lstatIn(fsys, path)
+		if os.IsNotExist(err) {
+			missing = append(missing, path)
+			continue
+		}
+		if err := fn(path, info, err); err != nil && err != filepath.SkipDir {
+			return missing, err
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,102 @@
+package checksum
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkMode says how a Hasher treats the symbolic links found by the walk.
+type SymlinkMode int
+
+const (
+	// SymlinkIgnore skips symbolic links. This is the default.
+	SymlinkIgnore SymlinkMode = iota
+
+	// SymlinkFollow digests the contents of the regular file a symbolic link
+	// points to, as if it were found at the link's path. Links to
+	// directories are not descended into.
+	SymlinkFollow
+
+	// SymlinkHashTarget digests the target path of a symbolic link, as
+	// returned by os.Readlink, without following the link. This records what
+	// a link points to, so that a changed link target changes its digest.
+	SymlinkHashTarget
+
+	// SymlinkFollowAll is like SymlinkFollow, but also descends into the
+	// directories links point to, as if they were found at the link's
+	// path, as find -L does. A link to a directory the walk is already
+	// inside, which would make it loop forever, is skipped and listed in
+	// Stats.SymlinkLoops. Directories are told apart by device and inode,
+	// which are only known on Unix; elsewhere such a loop ends the walk
+	// with ErrPathTooDeep.
+	SymlinkFollowAll
+)
+
+// linkFile returns the file to send for the symbolic link at path, whose
+// Lstat information is info. It reports false if the link is skipped.
+func (h *Hasher) linkFile(path string, info os.FileInfo) (file, bool) {
+	switch h.SymlinkMode {
+	case SymlinkFollow, SymlinkFollowAll:
+		target, err := os.Stat(path)
+		if err != nil {
+			// Let the read fail, so that a dangling link is reported
+			// as an error for its path.
+			return file{path: path, info: info, symlink: true}, true
+		}
+		if !target.Mode().IsRegular() {
+			return file{}, false
+		}
+		return file{path: path, info: target, symlink: true}, true
+
+	case SymlinkHashTarget:
+		return file{path: path, info: info, symlink: true}, true
+	}
+
+	return file{}, false
+}
+
+// followDir returns the information of the directory the symbolic link at
+// path points to, for SymlinkFollowAll, or false if it points to anything
+// else, cannot be resolved, or lies outside the canonical root confine, if it
+// is set. Those the walk then treats as any other link.
+func followDir(confine, path string) (os.FileInfo, bool) {
+	target, err := os.Stat(path)
+	if err != nil || !target.IsDir() {
+		return nil, false
+	}
+	if confine != "" && !inside(confine, path) {
+		return nil, false
+	}
+	return target, true
+}
+
+// inside reports whether the target of the symbolic link at path, with every
+// link resolved, lies within the directory croot, given in canonical form. A
+// link that cannot be resolved counts as inside, so that following it fails.
+func inside(croot, path string) bool {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return true
+	}
+	if !filepath.IsAbs(target) {
+		if target, err = filepath.Abs(target); err != nil {
+			return true
+		}
+	}
+	rel, err := filepath.Rel(croot, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sumLink returns the digest of the target path of the symbolic link at path.
+func (h *Hasher) sumLink(path string) (Digest, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := h.newHash()
+	io.WriteString(d, target)
+	return d.Sum(nil), nil
+}
@@ -0,0 +1,55 @@
+package checksum
+
+import "context"
+
+// A TokenSource hands out the tokens of a concurrency budget shared with other
+// parts of a program, such as a semaphore bounding the I/O of a whole process.
+// A Hasher whose Tokens field is set takes a token before reading each file
+// and gives it back once the file is digested. A TokenSource must be safe for
+// use by several goroutines at once.
+type TokenSource interface {
+	// Acquire blocks until a token is available and takes it, or returns
+	// a non-nil error, without taking one, if ctx is done first.
+	Acquire(ctx context.Context) error
+
+	// Release gives back a token taken by Acquire.
+	Release()
+}
+
+// NewTokenSource returns a TokenSource of n tokens, for budgets that only
+// span the Hashers of a program. If n is not positive, it only has one.
+func NewTokenSource(n int) TokenSource {
+	if n <= 0 {
+		n = 1
+	}
+	return make(semaphore, n)
+}
+
+// semaphore is the TokenSource NewTokenSource returns. Each token taken is an
+// element of the channel.
+type semaphore chan struct{}
+
+func (s semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) Release() { <-s }
+
+// doneContext returns a context that is canceled once done is closed, or the
+// returned function is called, which releases its resources.
+func doneContext(done <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,41 @@
+package checksum
+
+import (
+	"bytes"
+	"sync"
+)
+
+// defaultTransformBuffer is the default value of Hasher.TransformBuffer.
+const defaultTransformBuffer = 1 << 20
+
+// transformBufs holds the buffers of the bytes kept for Hasher.Transform,
+// for reuse once Transform has returned.
+var transformBufs = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// transformBuffer returns the size of the largest file kept for h.Transform.
+func (h *Hasher) transformBuffer() int64 {
+	if h.TransformBuffer > 0 {
+		return h.TransformBuffer
+	}
+	return defaultTransformBuffer
+}
+
+// transform calls h.Transform with the result r, and the bytes of the file if
+// they were kept, unless reading the file failed or its result is dropped, and
+// recycles the bytes.
+func (h *Hasher) transform(r *Result) {
+	var data []byte
+	keep := r.data
+	if keep != nil {
+		data = keep.Bytes()
+		r.data = nil
+	}
+	if r.Err == nil && !r.skipped && !r.locked {
+		h.Transform(r, data)
+	}
+	if keep != nil {
+		transformBufs.Put(keep)
+	}
+}
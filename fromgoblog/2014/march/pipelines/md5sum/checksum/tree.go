@@ -0,0 +1,420 @@
+package checksum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// structureSum returns the digest StructureOnly computes for a file described
+// by info: the hash of its size and mode, each as a big-endian integer.
+func (h *Hasher) structureSum(info os.FileInfo) Digest {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(info.Size()))
+	binary.BigEndian.PutUint32(b[8:], uint32(info.Mode()))
+
+	d := h.newHash()
+	d.Write(b[:])
+	return d.Sum(nil)
+}
+
+// metadataSum returns the digest MetadataDigest computes for a file described
+// by info: the hash of its size, modification time in nanoseconds since the
+// Unix epoch, and mode, each as a big-endian integer.
+func (h *Hasher) metadataSum(info os.FileInfo) Digest {
+	var b [20]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(info.Size()))
+	binary.BigEndian.PutUint64(b[8:16], uint64(info.ModTime().UnixNano()))
+	binary.BigEndian.PutUint32(b[16:], uint32(info.Mode()))
+
+	d := h.newHash()
+	d.Write(b[:])
+	return d.Sum(nil)
+}
+
+// treeEntry is a file folded into a tree root.
+type treeEntry struct {
+	path string
+	sum  Digest
+}
+
+// TreeRoot reads all the files in the file tree rooted at root and returns a
+// single digest of the whole tree, folded from every file's path, relative to
+// root and with forward slashes, and digest. Two trees have the same root if
+// and only if they hold the same files with the same contents, up to hash
+// collisions. Paths are compared byte by byte once their separators are
+// forward slashes, and their case is kept, so the same tree has the same root
+// on every platform and the root can serve as a portable identifier of its
+// content. If the directory walk fails or any read operation fails, TreeRoot
+// returns an error.
+//
+// The files are folded along a binary search tree of their paths, each node
+// of which is the hash of the digest of its left subtree, its own path and
+// digest, and the digest of its right subtree, and the root is the digest of
+// the top node, or the hash of nothing for an empty tree. The tree is the
+// treap whose priorities are the first 8 bytes of the hash of each path, so
+// that its shape only depends on which paths there are, and a change to one
+// file only changes the nodes above it, as IncrementalTreeRoot relies on.
+//
+// TreeRoot uses a zero Hasher; see Hasher.TreeRoot.
+func TreeRoot(root string) (Digest, error) {
+	return new(Hasher).TreeRoot(root)
+}
+
+// TreeRoot is like the package-level TreeRoot, but reads the files through h
+// and digests both them and the tree with h.Hash. With h.StructureOnly set, no
+// file is read, and the root only reflects the paths, sizes and modes of the
+// files. h.KeyFunc is not used.
+func (h *Hasher) TreeRoot(root string) (Digest, error) {
+	entries, err := h.treeEntries(root)
+	if err != nil {
+		return nil, err
+	}
+	return h.namesRoot(entries), nil
+}
+
+// treeEntries reads all the files in the file tree rooted at root through h,
+// with h.KeyFunc disabled, and returns their paths, relative to root and with
+// forward slashes, and digests, sorted by path.
+func (h *Hasher) treeEntries(root string) ([]treeEntry, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	th := *h
+	th.KeyFunc = nil
+
+	var entries []treeEntry
+	err := th.collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		rel, err := filepath.Rel(root, r.Path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, treeEntry{filepath.ToSlash(rel), r.Sum})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort on the slash-separated paths rather than the native ones: a
+	// backslash sorts after most other characters, a slash before them, so
+	// sorting native paths would order the same tree differently on
+	// Windows.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	return entries, nil
+}
+
+// namesRoot returns the tree root of entries, sorted by path.
+func (h *Hasher) namesRoot(entries []treeEntry) Digest {
+	return h.nodesRoot(h.buildNodes(entries))
+}
+
+// A rootNode is a node of the treap a tree root is folded along, as described
+// for TreeRoot. Its digest covers its subtree.
+type rootNode struct {
+	treeEntry
+	prio        uint64
+	left, right *rootNode
+	digest      Digest
+}
+
+// priority returns the priority of the file at path in the treap of a tree
+// root: the first 8 bytes of the hash of its path, as a big-endian integer,
+// padded with zeros for a shorter hash.
+func (h *Hasher) priority(path string) uint64 {
+	d := h.newHash()
+	d.Write([]byte(path))
+	var b [8]byte
+	copy(b[:], d.Sum(nil))
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// above reports whether n belongs above m in the treap: whether it has the
+// higher priority or, if they tie, the lower path.
+func (n *rootNode) above(m *rootNode) bool {
+	return n.prio > m.prio || n.prio == m.prio && n.path < m.path
+}
+
+// newNode returns the node of the file e, with no subtrees.
+func (h *Hasher) newNode(e treeEntry) *rootNode {
+	n := &rootNode{treeEntry: e, prio: h.priority(e.path)}
+	h.hashNode(n)
+	return n
+}
+
+// hashNode computes the digest of n again from its entry and the digests of
+// its subtrees.
+func (h *Hasher) hashNode(n *rootNode) {
+	d := h.newHash()
+	var left, right Digest
+	if n.left != nil {
+		left = n.left.digest
+	}
+	if n.right != nil {
+		right = n.right.digest
+	}
+	writeField(d, left)
+	writeField(d, []byte(n.path))
+	writeField(d, n.sum)
+	writeField(d, right)
+	n.digest = d.Sum(nil)
+}
+
+// nodesRoot returns the tree root of the treap topped by n, which may be nil.
+func (h *Hasher) nodesRoot(n *rootNode) Digest {
+	if n == nil {
+		return h.newHash().Sum(nil)
+	}
+	return n.digest
+}
+
+// buildNodes returns the top of the treap of entries, sorted by path, built in
+// linear time by keeping the nodes along its right edge on a stack.
+func (h *Hasher) buildNodes(entries []treeEntry) *rootNode {
+	var edge []*rootNode
+	for i := range entries {
+		n := &rootNode{treeEntry: entries[i], prio: h.priority(entries[i].path)}
+		var below *rootNode
+		for len(edge) > 0 && n.above(edge[len(edge)-1]) {
+			below = edge[len(edge)-1]
+			edge = edge[:len(edge)-1]
+		}
+		n.left = below
+		if len(edge) > 0 {
+			edge[len(edge)-1].right = n
+		}
+		edge = append(edge, n)
+	}
+	if len(edge) == 0 {
+		return nil
+	}
+	h.hashNodes(edge[0])
+	return edge[0]
+}
+
+// hashNodes computes the digests of every node of the treap topped by n.
+func (h *Hasher) hashNodes(n *rootNode) {
+	if n == nil {
+		return
+	}
+	h.hashNodes(n.left)
+	h.hashNodes(n.right)
+	h.hashNode(n)
+}
+
+// contentRoot returns the hash of the digests of entries, in byte order,
+// leaving out their paths.
+func (h *Hasher) contentRoot(entries []treeEntry) Digest {
+	sums := make([]Digest, len(entries))
+	for i, e := range entries {
+		sums[i] = e.sum
+	}
+	sort.Slice(sums, func(i, j int) bool { return bytes.Compare(sums[i], sums[j]) < 0 })
+
+	d := h.newHash()
+	for _, sum := range sums {
+		writeField(d, sum)
+	}
+	return d.Sum(nil)
+}
+
+// A TreeID identifies a file tree in two parts, as returned by TreeIdentity.
+// Content is the digest of the multiset of the contents of its files,
+// whatever their names, and Names is the digest of which path holds which
+// content, the same as TreeRoot.
+type TreeID struct {
+	Content Digest
+	Names   Digest
+}
+
+// A TreeChange classifies how a tree changed between two TreeIDs.
+type TreeChange int
+
+const (
+	// TreeSame means the trees hold the same files with the same
+	// contents.
+	TreeSame TreeChange = iota
+
+	// TreeRenamed means the trees hold the same contents under different
+	// paths: files were only renamed or moved.
+	TreeRenamed
+
+	// TreeContentChanged means the contents of the trees differ.
+	TreeContentChanged
+)
+
+func (c TreeChange) String() string {
+	switch c {
+	case TreeSame:
+		return "same"
+	case TreeRenamed:
+		return "renamed"
+	case TreeContentChanged:
+		return "content changed"
+	}
+	return fmt.Sprintf("TreeChange(%d)", int(c))
+}
+
+// Compare classifies the change from the tree identified by id to the one
+// identified by other. Both must have been computed with the same hash.
+func (id TreeID) Compare(other TreeID) TreeChange {
+	switch {
+	case !bytes.Equal(id.Content, other.Content):
+		return TreeContentChanged
+	case !bytes.Equal(id.Names, other.Names):
+		return TreeRenamed
+	}
+	return TreeSame
+}
+
+// TreeIdentity reads all the files in the file tree rooted at root and
+// returns its TreeID, which, unlike TreeRoot alone, tells a tree whose files
+// were merely renamed or moved from one whose contents changed. A file
+// duplicated, or a copy of it removed, counts as a content change. If the
+// directory walk fails or any read operation fails, TreeIdentity returns an
+// error.
+//
+// TreeIdentity uses a zero Hasher; see Hasher.TreeIdentity.
+func TreeIdentity(root string) (TreeID, error) {
+	return new(Hasher).TreeIdentity(root)
+}
+
+// TreeIdentity is like the package-level TreeIdentity, but reads the files
+// through h and digests them and the tree with h.Hash, as Hasher.TreeRoot
+// does.
+func (h *Hasher) TreeIdentity(root string) (TreeID, error) {
+	entries, err := h.treeEntries(root)
+	if err != nil {
+		return TreeID{}, err
+	}
+	return TreeID{Content: h.contentRoot(entries), Names: h.namesRoot(entries)}, nil
+}
+
+// boundSum returns the digest binding sum to path for Hasher.BindPath.
+func (h *Hasher) boundSum(path string, sum Digest) Digest {
+	d := h.newHash()
+	writeField(d, []byte(path))
+	writeField(d, sum)
+	return d.Sum(nil)
+}
+
+// writeField writes b to d prefixed with its length as a big-endian uint64, so
+// that consecutive fields cannot run into each other.
+func writeField(d hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	d.Write(n[:])
+	d.Write(b)
+}
+
+// DirDigests reads all the files in the file tree rooted at root and returns a
+// rollup digest for every directory holding files, keyed by the directory's
+// path as found by the walk, starting with filepath.Clean(root). The digest of
+// a directory is the hash of the name, kind and digest of each of its files
+// and subdirectories, in name order, so that changing a file changes the
+// digest of all the directories above it, and two subtrees with the same
+// digest hold the same files. Directories without any file below them are
+// left out. If the directory walk fails or any read operation fails,
+// DirDigests returns an error.
+//
+// DirDigests uses a zero Hasher; see Hasher.DirDigests.
+func DirDigests(root string) (map[string][]byte, error) {
+	return new(Hasher).DirDigests(root)
+}
+
+// dirEntry is a file or subdirectory folded into the digest of a directory.
+type dirEntry struct {
+	name string
+	dir  bool
+	sum  []byte
+}
+
+// DirDigests is like the package-level DirDigests, but reads the files through
+// h and digests both them and the directories with h.Hash. h.KeyFunc is not
+// used.
+func (h *Hasher) DirDigests(root string) (map[string][]byte, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	th := *h
+	th.KeyFunc = nil
+
+	root = filepath.Clean(root)
+	entries := make(map[string][]dirEntry)
+
+	err := th.collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		if path := filepath.Clean(r.Path); path != root {
+			dir := filepath.Dir(path)
+			entries[dir] = append(entries[dir], dirEntry{filepath.Base(path), false, r.Sum})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// List every directory up to root, even those holding nothing but
+	// subdirectories.
+	subdirs := make(map[string]map[string]bool)
+	for dir := range entries {
+		for dir != root {
+			parent := filepath.Dir(dir)
+			if subdirs[parent] == nil {
+				subdirs[parent] = make(map[string]bool)
+			}
+			subdirs[parent][filepath.Base(dir)] = true
+			dir = parent
+		}
+	}
+	dirs := make([]string, 0, len(entries)+len(subdirs))
+	seen := make(map[string]bool)
+	for dir := range entries {
+		dirs = append(dirs, dir)
+		seen[dir] = true
+	}
+	for dir := range subdirs {
+		if !seen[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	// Fold the deepest directories first, so that the digest of each
+	// subdirectory is known by the time its parent is folded.
+	depth := func(dir string) int { return strings.Count(dir, string(filepath.Separator)) }
+	sort.Slice(dirs, func(i, j int) bool { return depth(dirs[i]) > depth(dirs[j]) })
+
+	sums := make(map[string][]byte, len(dirs))
+	for _, dir := range dirs {
+		es := entries[dir]
+		for name := range subdirs[dir] {
+			es = append(es, dirEntry{name, true, sums[filepath.Join(dir, name)]})
+		}
+		sort.Slice(es, func(i, j int) bool { return es[i].name < es[j].name })
+
+		d := h.newHash()
+		for _, e := range es {
+			writeField(d, []byte(e.name))
+			if e.dir {
+				writeField(d, []byte{'d'})
+			} else {
+				writeField(d, []byte{'f'})
+			}
+			writeField(d, e.sum)
+		}
+		sums[dir] = d.Sum(nil)
+	}
+
+	return sums, nil
+}
@@ -0,0 +1,108 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+// treeFixture is a tree whose files sort differently by native path on
+// Windows, where "a\b.txt" sorts after "a0.txt" rather than before it, and
+// by case-folded path, where "B" sorts after "a". Its names differ in more
+// than case, so that it can be written to a case-insensitive filesystem too.
+var treeFixture = map[string]string{
+	"a/b.txt": "b",
+	"a0.txt":  "a0",
+	"a b.txt": "space",
+	"B/c.txt": "c",
+	"a/d/e":   "",
+}
+
+// treeFixtureRoot is the MD5 tree root of treeFixture. It must never change,
+// as roots are compared across machines and releases.
+const treeFixtureRoot = "93a312d73faecc53dba866bf6fa994ab"
+
+// foldRoot returns the tree root of the files of sums, by path, folded as
+// TreeRoot documents it: the node of the file of highest priority, its path
+// hashed, tops the nodes of the files below and above it.
+func foldRoot(newHash func() hash.Hash, sums map[string]Digest) Digest {
+	paths := make([]string, 0, len(sums))
+	for path := range sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	priority := func(path string) uint64 {
+		d := newHash()
+		d.Write([]byte(path))
+		var b [8]byte
+		copy(b[:], d.Sum(nil))
+		return binary.BigEndian.Uint64(b[:])
+	}
+	field := func(d hash.Hash, b []byte) {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+		d.Write(n[:])
+		d.Write(b)
+	}
+	var fold func(paths []string) Digest
+	fold = func(paths []string) Digest {
+		if len(paths) == 0 {
+			return nil
+		}
+		top := 0
+		for i, path := range paths {
+			// Ties go to the lower path, which comes first.
+			if priority(path) > priority(paths[top]) {
+				top = i
+			}
+		}
+		d := newHash()
+		field(d, fold(paths[:top]))
+		field(d, []byte(paths[top]))
+		field(d, sums[paths[top]])
+		field(d, fold(paths[top+1:]))
+		return d.Sum(nil)
+	}
+	if len(paths) == 0 {
+		return newHash().Sum(nil)
+	}
+	return fold(paths)
+}
+
+func TestTreeRootFixture(t *testing.T) {
+	sums := make(map[string]Digest)
+	for path, content := range treeFixture {
+		sums[path] = sumOf(content)
+	}
+	if got := hex.EncodeToString(foldRoot(md5.New, sums)); got != treeFixtureRoot {
+		t.Fatalf("folded root of treeFixture = %s, want %s", got, treeFixtureRoot)
+	}
+
+	fsys := fstest.MapFS{}
+	for path, content := range treeFixture {
+		fsys[path] = mapFile(content)
+	}
+	trees := []struct {
+		name string
+		h    *Hasher
+		root string
+	}{
+		{"fs.FS", &Hasher{FS: fsys}, "."},
+		{"fs.FS with workers", &Hasher{FS: fsys, Workers: 1, WalkWorkers: 1}, "."},
+		{"disk", new(Hasher), writeTree(t, treeFixture)},
+	}
+	for _, tt := range trees {
+		got, err := tt.h.TreeRoot(tt.root)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if hex.EncodeToString(got) != treeFixtureRoot {
+			t.Errorf("%s: TreeRoot = %x, want %s", tt.name, got, treeFixtureRoot)
+		}
+	}
+}
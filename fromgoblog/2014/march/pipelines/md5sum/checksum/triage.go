@@ -0,0 +1,120 @@
+package checksum
+
+import (
+	"os"
+	"sort"
+)
+
+// SmallFiles counts the files HashLargest leaves out, and the sum of their
+// sizes.
+type SmallFiles struct {
+	Files int
+	Bytes int64
+}
+
+// HashLargest digests only the largest files in the file tree rooted at root,
+// for a quick look at where the bytes of a tree go. A first pass walks the
+// tree to learn the size of every file without reading any; HashLargest then
+// digests the largest files that together make up at least the fraction
+// coverage of all the bytes, and skips the long tail of smaller files. Files
+// the same size as the smallest one needed are all digested. A coverage of 1
+// or more digests every file.
+//
+// HashLargest returns the results of the files digested, largest first, then
+// by path, along with the number and total size of the files it skipped. If
+// the directory walk fails or any read operation fails, HashLargest returns
+// an error.
+//
+// HashLargest uses a zero Hasher; see Hasher.HashLargest.
+func HashLargest(root string, coverage float64) ([]Result, SmallFiles, error) {
+	return new(Hasher).HashLargest(root, coverage)
+}
+
+// HashLargest is like the package-level HashLargest, but reads the files
+// through h. Only the second pass, which reads the files, fills in h.Stats,
+// writes checkpoints and calls the OnFileStart and OnFileDone hooks.
+func (h *Hasher) HashLargest(root string, coverage float64) ([]Result, SmallFiles, error) {
+	min, err := h.sizeThreshold(root, coverage)
+	if err != nil {
+		return nil, SmallFiles{}, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	var small SmallFiles
+	th := *h
+	th.SkipFunc = func(path string, info os.FileInfo, state ScanState) bool {
+		if info.Size() < min {
+			small.Files++
+			small.Bytes += info.Size()
+			return true
+		}
+		return h.SkipFunc != nil && h.SkipFunc(path, info, state)
+	}
+
+	var results []Result
+	err = th.collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, SmallFiles{}, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Size != results[j].Size {
+			return results[i].Size > results[j].Size
+		}
+		return results[i].Path < results[j].Path
+	})
+
+	return results, small, nil
+}
+
+// sizeThreshold walks the tree rooted at root without reading any file and
+// returns the smallest size a file must have to be among the largest files
+// making up the fraction coverage of all the bytes in the tree.
+func (h *Hasher) sizeThreshold(root string, coverage float64) (int64, error) {
+	if coverage >= 1 {
+		return 0, nil
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	sh := *h
+	sh.StructureOnly = true
+	sh.CheckpointDir = ""
+	sh.Stats = nil
+	sh.OnFileStart = nil
+	sh.OnFileDone = nil
+
+	var sizes []int64
+	var total int64
+	err := sh.collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		sizes = append(sizes, r.Size)
+		total += r.Size
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+
+	var covered int64
+	for _, size := range sizes {
+		covered += size
+		if float64(covered) >= coverage*float64(total) {
+			return size, nil
+		}
+	}
+	return 0, nil
+}
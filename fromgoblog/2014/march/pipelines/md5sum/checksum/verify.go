@@ -0,0 +1,299 @@
+package checksum
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestVersion is the version of the manifest format written by
+// ScanToFile, WriteManifestGz and the md5sum binary. ManifestHeader is the
+// line they start each manifest with to announce it.
+const (
+	ManifestVersion = 2
+	ManifestHeader  = "# gosandbox-manifest v2"
+)
+
+// manifestHeaderPrefix starts the header line of every versioned manifest.
+const manifestHeaderPrefix = "# gosandbox-manifest v"
+
+// ParseManifest reads a manifest of "sum\tpath" lines, as written by
+// ScanToFile and printed by the md5sum binary, and returns a map from path to
+// digest. A manifest that starts with a version header is parsed according to
+// its version, and one without a header is read as version 1, the format
+// written before headers were introduced. Versions 1 and 2 share the same
+// lines. Blank lines are skipped; any other malformed line, or a version newer
+// than ManifestVersion, is an error.
+func ParseManifest(r io.Reader) (map[string]Digest, error) {
+	m := make(map[string]Digest)
+
+	mr := newManifestReader(r)
+	for {
+		path, sum, err := mr.next()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[path] = sum
+	}
+}
+
+// manifestReader reads the entries of a manifest one at a time, as
+// ParseManifest does.
+type manifestReader struct {
+	s       *bufio.Scanner
+	n       int // number of the last line read
+	version int // version of the manifest, once its first line is read
+}
+
+func newManifestReader(r io.Reader) *manifestReader {
+	return &manifestReader{s: bufio.NewScanner(r)}
+}
+
+// next returns the path and digest of the next entry of the manifest, or
+// io.EOF once there are no more.
+func (mr *manifestReader) next() (string, Digest, error) {
+	for mr.s.Scan() {
+		mr.n++
+		line := mr.s.Text()
+		if mr.version == 0 {
+			v, ok, err := parseManifestHeader(line)
+			if err != nil {
+				return "", nil, &ParseError{mr.n, err}
+			}
+			mr.version = v
+			if ok {
+				continue
+			}
+		}
+		if line == "" {
+			continue
+		}
+
+		path, sum, err := parseManifestLine(line)
+		if err != nil {
+			return "", nil, &ParseError{mr.n, err}
+		}
+		return path, sum, nil
+	}
+	if err := mr.s.Err(); err != nil {
+		return "", nil, err
+	}
+	return "", nil, io.EOF
+}
+
+// parseManifestHeader returns the version announced by line, the first line of
+// a manifest, and whether line is a header at all. A manifest without a header
+// is version 1.
+func parseManifestHeader(line string) (int, bool, error) {
+	if !strings.HasPrefix(line, manifestHeaderPrefix) {
+		return 1, false, nil
+	}
+	v, err := strconv.Atoi(line[len(manifestHeaderPrefix):])
+	if err != nil || v < 1 {
+		return 0, false, fmt.Errorf("malformed manifest header %q", line)
+	}
+	if v > ManifestVersion {
+		return 0, false, fmt.Errorf("unsupported manifest version %d", v)
+	}
+	return v, true, nil
+}
+
+// parseManifestLine parses a single "sum\tpath" line of a manifest.
+func parseManifestLine(line string) (string, Digest, error) {
+	i := strings.IndexByte(line, '\t')
+	if i < 0 {
+		return "", nil, fmt.Errorf("malformed manifest line %q", line)
+	}
+	sum, err := hex.DecodeString(line[:i])
+	if err != nil {
+		return "", nil, err
+	}
+	return line[i+1:], sum, nil
+}
+
+// MismatchKind says how a file differs from its manifest entry.
+type MismatchKind int
+
+const (
+	// Changed means the file's digest differs from the manifest's.
+	Changed MismatchKind = iota + 1
+
+	// Missing means the file is in the manifest but not in the tree.
+	Missing
+
+	// Extra means the file is in the tree but not in the manifest.
+	Extra
+)
+
+func (k MismatchKind) String() string {
+	switch k {
+	case Changed:
+		return "changed"
+	case Missing:
+		return "missing"
+	case Extra:
+		return "extra"
+	}
+	return fmt.Sprintf("MismatchKind(%d)", int(k))
+}
+
+// A Mismatch is a file of a tree that does not match its manifest entry. If
+// Informational is set, the mismatch is only reported for information and
+// does not make verification fail: this is the case for Extra files, unless
+// Hasher.StrictExtra is set. Expected is the digest of the manifest entry and
+// Actual that of the file, so that a Changed file can be logged with both;
+// Expected is nil for an Extra file, and Actual for a Missing one.
+type Mismatch struct {
+	Path          string
+	Kind          MismatchKind
+	Informational bool
+	Expected      Digest
+	Actual        Digest
+}
+
+// mismatch returns the mismatch of kind for the file at path, given the
+// digest of its manifest entry and its own, either of which may be nil.
+func (h *Hasher) mismatch(path string, kind MismatchKind, expected, actual []byte) Mismatch {
+	return Mismatch{path, kind, kind == Extra && !h.StrictExtra, expected, actual}
+}
+
+// match reports whether the digest actual of a file matches the digest
+// expected of the manifest, using h.CompareFunc.
+func (h *Hasher) match(expected, actual []byte) bool {
+	if h.CompareFunc != nil {
+		return h.CompareFunc(expected, actual)
+	}
+	return hmac.Equal(expected, actual)
+}
+
+// Verify reads all the files in the file tree rooted at root and compares
+// their digests with manifest, which maps paths, as produced by walking root,
+// to digests. It returns the mismatches sorted by path: files whose digest
+// changed, files of the manifest missing from the tree, and files of the tree
+// missing from the manifest, which are informational unless StrictExtra is
+// set. Digests are compared in constant time, which matters for manifests of
+// HMACs; see VerifyHMAC and Hasher.CompareFunc. If the directory walk fails or
+// any read operation fails, Verify returns an error.
+//
+// Verify uses a zero Hasher; see Hasher.Verify.
+func Verify(root string, manifest map[string]Digest) ([]Mismatch, error) {
+	return new(Hasher).Verify(root, manifest)
+}
+
+// Verify is like the package-level Verify, but reads the files through h and
+// digests them with h.Hash, which must be the hash the manifest was made with.
+func (h *Hasher) Verify(root string, manifest map[string]Digest) ([]Mismatch, error) {
+	return h.VerifyStore(root, ManifestMap(manifest))
+}
+
+// VerifyStore is like Verify, but looks up the expected digests in store, so
+// that the manifest need not be held in memory.
+//
+// VerifyStore uses a zero Hasher; see Hasher.VerifyStore.
+func VerifyStore(root string, store ManifestStore) ([]Mismatch, error) {
+	return new(Hasher).VerifyStore(root, store)
+}
+
+// VerifyStore is like the package-level VerifyStore, but reads the files
+// through h and digests them with h.Hash, which must be the hash the manifest
+// was made with.
+func (h *Hasher) VerifyStore(root string, store ManifestStore) ([]Mismatch, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	var mismatches []Mismatch
+	seen := make(map[string]bool)
+
+	err := h.collect(done, root, func(r Result) error {
+		if r.Err != nil {
+			return r.Err
+		}
+
+		want, ok := store.Lookup(r.Path)
+		if !ok {
+			mismatches = append(mismatches, h.mismatch(r.Path, Extra, nil, r.Sum))
+			return nil
+		}
+		seen[r.Path] = true
+		if !h.match(want, r.Sum) {
+			mismatches = append(mismatches, h.mismatch(r.Path, Changed, want, r.Sum))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	store.Range(func(path string, sum []byte) bool {
+		if !seen[path] {
+			mismatches = append(mismatches, h.mismatch(path, Missing, sum, nil))
+		}
+		return true
+	})
+	if es, ok := store.(interface{ Err() error }); ok {
+		if err := es.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+
+	return mismatches, nil
+}
+
+// A VerifySummary counts the outcome of a verification by category, for
+// mapping it to a process exit code.
+type VerifySummary struct {
+	// Changed, Missing and Extra count the mismatches of each kind that
+	// are not informational, and Informational those that are.
+	Changed       int
+	Missing       int
+	Extra         int
+	Informational int
+
+	// Err is the error that stopped the verification, if any.
+	Err error
+}
+
+// Summarize returns the summary of a verification that found mismatches and
+// ended with err, as returned by Verify.
+func Summarize(mismatches []Mismatch, err error) VerifySummary {
+	s := VerifySummary{Err: err}
+	for _, m := range mismatches {
+		switch {
+		case m.Informational:
+			s.Informational++
+		case m.Kind == Changed:
+			s.Changed++
+		case m.Kind == Missing:
+			s.Missing++
+		case m.Kind == Extra:
+			s.Extra++
+		}
+	}
+	return s
+}
+
+// ExitCode returns the exit code for the most severe outcome in s: 3 if the
+// verification failed with an error, 2 if files are missing, 1 if files
+// changed or, with Hasher.StrictExtra, extra files were found, and 0 if the
+// tree matches the manifest.
+func (s VerifySummary) ExitCode() int {
+	switch {
+	case s.Err != nil:
+		return 3
+	case s.Missing > 0:
+		return 2
+	case s.Changed > 0 || s.Extra > 0:
+		return 1
+	}
+	return 0
+}
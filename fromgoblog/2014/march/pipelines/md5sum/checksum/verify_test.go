@@ -0,0 +1,273 @@
+package checksum
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// sumOf returns the MD5 sum of s as a Digest.
+func sumOf(s string) Digest {
+	sum := md5Of(s)
+	return sum[:]
+}
+
+// verifyTree is the tree the Verify tests check against their manifests.
+var verifyTree = fstest.MapFS{
+	"a.txt":     mapFile("a"),
+	"sub/b.txt": mapFile("b"),
+}
+
+// verifyTests each check a single category of mismatch: the manifest differs
+// from verifyTree in one way only.
+var verifyTests = []struct {
+	name     string
+	manifest map[string]Digest
+	strict   bool
+	want     []Mismatch
+}{
+	{
+		name:     "match",
+		manifest: map[string]Digest{"a.txt": sumOf("a"), "sub/b.txt": sumOf("b")},
+	},
+	{
+		name:     "changed",
+		manifest: map[string]Digest{"a.txt": sumOf("a"), "sub/b.txt": sumOf("old b")},
+		want:     []Mismatch{{Path: "sub/b.txt", Kind: Changed, Expected: sumOf("old b"), Actual: sumOf("b")}},
+	},
+	{
+		name:     "missing",
+		manifest: map[string]Digest{"a.txt": sumOf("a"), "sub/b.txt": sumOf("b"), "sub/gone.txt": sumOf("gone")},
+		want:     []Mismatch{{Path: "sub/gone.txt", Kind: Missing, Expected: sumOf("gone")}},
+	},
+	{
+		name:     "extra",
+		manifest: map[string]Digest{"a.txt": sumOf("a")},
+		want:     []Mismatch{{Path: "sub/b.txt", Kind: Extra, Informational: true, Actual: sumOf("b")}},
+	},
+	{
+		name:     "strict extra",
+		manifest: map[string]Digest{"a.txt": sumOf("a")},
+		strict:   true,
+		want:     []Mismatch{{Path: "sub/b.txt", Kind: Extra, Actual: sumOf("b")}},
+	},
+}
+
+func TestVerify(t *testing.T) {
+	for _, tt := range verifyTests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Hasher{FS: verifyTree, StrictExtra: tt.strict}
+			got, err := h.Verify(".", tt.manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Verify = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyStrictExtraOnly(t *testing.T) {
+	// StrictExtra changes nothing about the other categories.
+	for _, tt := range verifyTests {
+		if tt.strict || len(tt.want) == 0 || tt.want[0].Kind == Extra {
+			continue
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Hasher{FS: verifyTree, StrictExtra: true}
+			got, err := h.Verify(".", tt.manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Verify = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// verifiers run each way of verifying a tree against a manifest, and return
+// the mismatches sorted by path.
+var verifiers = []struct {
+	name   string
+	verify func(h *Hasher, manifest map[string]Digest) ([]Mismatch, error)
+}{
+	{"Verify", func(h *Hasher, manifest map[string]Digest) ([]Mismatch, error) {
+		return h.Verify(".", manifest)
+	}},
+	{"VerifyStore", func(h *Hasher, manifest map[string]Digest) ([]Mismatch, error) {
+		return h.VerifyStore(".", ManifestMap(manifest))
+	}},
+	{"VerifyStream", func(h *Hasher, manifest map[string]Digest) ([]Mismatch, error) {
+		var mismatches []Mismatch
+		for ev := range h.VerifyStream(context.Background(), ".", manifest) {
+			if ev.Err != nil {
+				return nil, ev.Err
+			}
+			if ev.Mismatch != nil {
+				mismatches = append(mismatches, *ev.Mismatch)
+			}
+		}
+		sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+		return mismatches, nil
+	}},
+	{"VerifySubset", func(h *Hasher, manifest map[string]Digest) ([]Mismatch, error) {
+		// Every path of the tree and of the manifest is listed.
+		m := make(map[string][md5.Size]byte)
+		paths := []string{"a.txt", "sub/b.txt"}
+		for path, sum := range manifest {
+			md5Sum, err := sum.md5Sum()
+			if err != nil {
+				return nil, err
+			}
+			m[path] = md5Sum
+			if _, ok := verifyTree[path]; !ok {
+				paths = append(paths, path)
+			}
+		}
+		return h.VerifySubset(".", m, paths)
+	}},
+}
+
+func TestMismatchDigests(t *testing.T) {
+	for _, v := range verifiers {
+		for _, tt := range verifyTests {
+			t.Run(v.name+"/"+tt.name, func(t *testing.T) {
+				h := &Hasher{FS: verifyTree, StrictExtra: tt.strict}
+				got, err := v.verify(h, tt.manifest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("mismatches = %+v, want %+v", got, tt.want)
+				}
+				for _, m := range got {
+					// Only the digests a category has are set.
+					if (m.Expected == nil) != (m.Kind == Extra) || (m.Actual == nil) != (m.Kind == Missing) {
+						t.Errorf("%s mismatch of %s has Expected %x, Actual %x", m.Kind, m.Path, m.Expected, m.Actual)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestVerifySummaryExitCode(t *testing.T) {
+	failed := errors.New("read failed")
+	changed := Mismatch{Path: "c", Kind: Changed}
+	missing := Mismatch{Path: "m", Kind: Missing}
+	extra := Mismatch{Path: "e", Kind: Extra}
+	info := Mismatch{Path: "i", Kind: Extra, Informational: true}
+
+	tests := []struct {
+		name       string
+		mismatches []Mismatch
+		err        error
+		want       int
+	}{
+		{"match", nil, nil, 0},
+		{"informational only", []Mismatch{info}, nil, 0},
+		{"changed", []Mismatch{changed, info}, nil, 1},
+		{"strict extra", []Mismatch{extra}, nil, 1},
+		{"missing", []Mismatch{missing}, nil, 2},
+		{"missing over changed", []Mismatch{changed, missing, extra}, nil, 2},
+		{"error", nil, failed, 3},
+		{"error over missing", []Mismatch{changed, missing}, failed, 3},
+	}
+	for _, tt := range tests {
+		if got := Summarize(tt.mismatches, tt.err).ExitCode(); got != tt.want {
+			t.Errorf("%s: ExitCode = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	a, b := sumOf("a"), sumOf("b")
+	lines := fmt.Sprintf("%x\ta.txt\n%x\tsub/b.txt\n", a, b)
+	want := map[string]Digest{"a.txt": a, "sub/b.txt": b}
+
+	tests := []struct {
+		name     string
+		manifest string
+		want     map[string]Digest
+		wantLine int // the line of the ParseError, if the manifest is malformed
+	}{
+		{name: "v1 without header", manifest: lines, want: want},
+		{name: "v1 with header", manifest: "# gosandbox-manifest v1\n" + lines, want: want},
+		{name: "v2", manifest: ManifestHeader + "\n" + lines, want: want},
+		{name: "v2 blank lines", manifest: ManifestHeader + "\n\n" + lines + "\n", want: want},
+		{name: "v2 tab in path", manifest: ManifestHeader + "\n" + fmt.Sprintf("%x\ta\tb\n", a), want: map[string]Digest{"a\tb": a}},
+		{name: "empty", manifest: "", want: map[string]Digest{}},
+		{name: "header only", manifest: ManifestHeader + "\n", want: map[string]Digest{}},
+		{name: "malformed header", manifest: "# gosandbox-manifest vX\n" + lines, wantLine: 1},
+		{name: "version 0", manifest: "# gosandbox-manifest v0\n" + lines, wantLine: 1},
+		{name: "newer version", manifest: fmt.Sprintf("# gosandbox-manifest v%d\n", ManifestVersion+1) + lines, wantLine: 1},
+		{name: "header after first line", manifest: lines + ManifestHeader + "\n", wantLine: 3},
+		{name: "line without tab", manifest: ManifestHeader + "\n" + lines + "d41d8cd98f00b204e9800998ecf8427e c.txt\n", wantLine: 4},
+		{name: "bad hex", manifest: ManifestHeader + "\nxyz\ta.txt\n", wantLine: 2},
+	}
+	parsers := []struct {
+		name  string
+		parse func(r io.Reader) (map[string]Digest, error)
+	}{
+		{"ParseManifest", ParseManifest},
+		{"ParseManifestConcurrent", func(r io.Reader) (map[string]Digest, error) { return ParseManifestConcurrent(r, 3) }},
+	}
+	for _, p := range parsers {
+		for _, tt := range tests {
+			t.Run(p.name+"/"+tt.name, func(t *testing.T) {
+				got, err := p.parse(strings.NewReader(tt.manifest))
+				if tt.wantLine > 0 {
+					var pe *ParseError
+					if !errors.As(err, &pe) || pe.Line != tt.wantLine {
+						t.Fatalf("error = %v, want a ParseError for line %d", err, tt.wantLine)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestParseManifestHeader(t *testing.T) {
+	tests := []struct {
+		line    string
+		version int
+		header  bool
+		wantErr bool
+	}{
+		{"d41d8cd98f00b204e9800998ecf8427e\ta.txt", 1, false, false},
+		{"", 1, false, false},
+		{"# a comment", 1, false, false},
+		{"# gosandbox-manifest v1", 1, true, false},
+		{ManifestHeader, ManifestVersion, true, false},
+		{"# gosandbox-manifest v", 0, false, true},
+		{"# gosandbox-manifest v2.0", 0, false, true},
+		{"# gosandbox-manifest v-1", 0, false, true},
+		{"# gosandbox-manifest v99", 0, false, true},
+	}
+	for _, tt := range tests {
+		v, header, err := parseManifestHeader(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseManifestHeader(%q) error = %v, want error %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (v != tt.version || header != tt.header) {
+			t.Errorf("parseManifestHeader(%q) = %d, %v, want %d, %v", tt.line, v, header, tt.version, tt.header)
+		}
+	}
+}
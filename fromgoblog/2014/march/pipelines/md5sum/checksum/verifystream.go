@@ -0,0 +1,118 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// progressInterval bounds how often VerifyStream sends progress events and
+// Hasher.OnProgress is called.
+const progressInterval = 100 * time.Millisecond
+
+// A VerifyEvent is sent by VerifyStream. If Mismatch is non-nil, the event
+// reports a newly found mismatch; if Err is non-nil, verification failed and
+// the event is the last one; otherwise it is a progress update. Every event
+// carries the number of manifest entries checked so far and the number of
+// mismatches found that are not informational.
+type VerifyEvent struct {
+	Checked    int
+	Mismatched int
+	Mismatch   *Mismatch
+	Err        error
+}
+
+// VerifyStream is like Verify, but reports its findings as it goes on the
+// returned channel: each mismatch as soon as it is found, progress updates at
+// most every 100ms, and a final progress update once every manifest entry
+// has been checked. Changed and extra files are reported in the order they are
+// digested, then missing files sorted by path. If the directory walk fails or
+// any read operation fails, the last event holds the error. The channel is
+// closed after the last event.
+//
+// The caller must either receive from the channel until it is closed or
+// cancel ctx. If ctx is canceled, VerifyStream abandons its work and closes
+// the channel, possibly without sending an event for ctx.Err().
+//
+// VerifyStream uses a zero Hasher; see Hasher.VerifyStream.
+func VerifyStream(ctx context.Context, root string, manifest map[string]Digest) <-chan VerifyEvent {
+	return new(Hasher).VerifyStream(ctx, root, manifest)
+}
+
+// VerifyStream is like the package-level VerifyStream, but reads the files
+// through h and digests them with h.Hash, which must be the hash the manifest
+// was made with.
+func (h *Hasher) VerifyStream(ctx context.Context, root string, manifest map[string]Digest) <-chan VerifyEvent {
+	out := make(chan VerifyEvent)
+
+	go func() {
+		defer close(out)
+
+		var checked, mismatched int
+		last := time.Now()
+
+		send := func(m *Mismatch, err error) error {
+			select {
+			case out <- VerifyEvent{checked, mismatched, m, err}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		mismatch := func(path string, kind MismatchKind, expected, actual []byte) error {
+			m := h.mismatch(path, kind, expected, actual)
+			if !m.Informational {
+				mismatched++
+			}
+			return send(&m, nil)
+		}
+
+		seen := make(map[string]bool)
+		err := h.collect(ctx.Done(), root, func(r Result) error {
+			if r.Err != nil {
+				return r.Err
+			}
+
+			want, ok := manifest[r.Path]
+			if !ok {
+				return mismatch(r.Path, Extra, nil, r.Sum)
+			}
+			seen[r.Path] = true
+			checked++
+			if !h.match(want, r.Sum) {
+				return mismatch(r.Path, Changed, want, r.Sum)
+			}
+			if time.Since(last) >= progressInterval {
+				last = time.Now()
+				return send(nil, nil)
+			}
+			return nil
+		})
+		if errors.Is(err, ErrWalkCanceled) || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			send(nil, err)
+			return
+		}
+
+		var missing []string
+		for path := range manifest {
+			if !seen[path] {
+				missing = append(missing, path)
+			}
+		}
+		sort.Strings(missing)
+
+		for _, path := range missing {
+			checked++
+			if mismatch(path, Missing, manifest[path], nil) != nil {
+				return
+			}
+		}
+		send(nil, nil)
+	}()
+
+	return out
+}
@@ -0,0 +1,299 @@
+package checksum
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// numWalkers is the default value of Hasher.WalkWorkers.
+	numWalkers = 8
+
+	// walkAhead is the number of subdirectories of each directory being
+	// walked that are listed ahead of the walk reaching them.
+	walkAhead = 16
+)
+
+// walkWorkers returns the number of directories the walk of h lists at once.
+func (h *Hasher) walkWorkers() int {
+	if h.WalkWorkers > 0 {
+		return h.WalkWorkers
+	}
+	return numWalkers
+}
+
+// A listing holds the entries of a directory, sorted by name, and their Lstat
+// information or the error getting it, once done is closed. err is set instead
+// if the directory could not be read.
+type listing struct {
+	done  chan struct{}
+	names []string
+	infos []os.FileInfo
+	errs  []error
+	err   error
+}
+
+// A treeWalker walks a tree as filepath.Walk does, but lists the directories
+// ahead of the walk on up to cap(sem) goroutines.
+type treeWalker struct {
+	fn   filepath.WalkFunc
+	sem  chan struct{}
+	quit chan struct{} // closed once the walk is over, to drop pending listings
+
+	// follow, if non-nil, is called with each symbolic link found, and
+	// returns the information of the directory the link points to if the
+	// walk is to descend into it as if it were found at the link's path.
+	// loop is then called instead for a link to a directory the walk is
+	// already inside, which it skips, and ids holds the identities of the
+	// directories the walk is inside, where they are known.
+	follow func(path string) (os.FileInfo, bool)
+	loop   func(path string)
+	ids    []fileID
+
+	// fsys, if non-nil, is the file system walked instead of the
+	// operating system's, with slash-separated paths.
+	fsys fs.FS
+}
+
+// walkTree walks the file tree rooted at root and calls fn for each file and
+// directory, exactly as filepath.Walk does: in lexical order, one call at a
+// time, with the same errors, and honoring filepath.SkipDir in the same way.
+// Only the listing of directories happens concurrently, up to workers
+// directories at once, ahead of the calls to fn. The information fn is given
+// for the entries of a directory on disk is a lazyInfo, which only calls
+// Lstat if fn asks for more than the name and type of the entry, so that an
+// entry gone by then is not an error of the walk.
+// The subdirectories listed ahead are those of the directories the walk is
+// in, so a directory fn skips may still have been listed, but none below it.
+//
+// If follow is non-nil, the walk also descends into the directories symbolic
+// links point to, as treeWalker.follow says, calling loop for those that
+// would make it loop. If fsys is non-nil, the tree is that of fsys, where
+// root is a path as fs.ValidPath requires.
+func walkTree(root string, workers int, fn filepath.WalkFunc, follow func(path string) (os.FileInfo, bool), loop func(path string), fsys fs.FS) error {
+	w := &treeWalker{fn: fn, sem: make(chan struct{}, workers), quit: make(chan struct{}), follow: follow, loop: loop, fsys: fsys}
+	defer close(w.quit)
+
+	info, err := lstatIn(fsys, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else if info.IsDir() {
+		err = w.walkDir(root, info, w.list(root))
+	} else {
+		err = fn(root, info, nil)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// list starts listing the directory at path, and returns its listing.
+func (w *treeWalker) list(path string) *listing {
+	l := &listing{done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		select {
+		case w.sem <- struct{}{}:
+		case <-w.quit:
+			return
+		}
+		defer func() { <-w.sem }()
+		if w.fsys != nil {
+			l.readFS(w.fsys, path)
+		} else {
+			l.read(path)
+		}
+	}()
+	return l
+}
+
+// join returns the path of the entry name of the directory at dir.
+func (w *treeWalker) join(dir, name string) string {
+	if w.fsys != nil {
+		return path.Join(dir, name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// read lists the directory at path into l, as filepath.Walk would, but with
+// the information of each entry only fetched with Lstat once more than its
+// name and type is asked for, since listing a directory tells those of its
+// entries without a call per entry on most systems.
+func (l *listing) read(path string) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		l.err = err
+		return
+	}
+
+	l.names = make([]string, len(entries))
+	l.infos = make([]os.FileInfo, len(entries))
+	l.errs = make([]error, len(entries))
+	for i, e := range entries {
+		l.names[i] = e.Name()
+		l.infos[i] = &lazyInfo{entry: e}
+	}
+}
+
+// A lazyInfo is the information of a file found by listing its directory,
+// fetched with Lstat the first time more than its name and type is asked for.
+// If the file is gone by then, it is described by its entry alone, and it is
+// reading it that fails.
+type lazyInfo struct {
+	entry fs.DirEntry
+	once  sync.Once
+	info  os.FileInfo
+}
+
+func (l *lazyInfo) Name() string       { return l.entry.Name() }
+func (l *lazyInfo) IsDir() bool        { return l.entry.IsDir() }
+func (l *lazyInfo) Size() int64        { return l.stat().Size() }
+func (l *lazyInfo) Mode() os.FileMode  { return l.stat().Mode() }
+func (l *lazyInfo) ModTime() time.Time { return l.stat().ModTime() }
+func (l *lazyInfo) Sys() interface{}   { return l.stat().Sys() }
+
+// stat returns the Lstat information of l, fetching it the first time.
+func (l *lazyInfo) stat() os.FileInfo {
+	l.once.Do(func() {
+		info, err := l.entry.Info()
+		if err != nil {
+			info = entryInfo{l.entry}
+		}
+		l.info = info
+	})
+	return l.info
+}
+
+// An entryInfo describes a file by its directory entry alone: its name and
+// type, with no size and no modification time.
+type entryInfo struct {
+	entry fs.DirEntry
+}
+
+func (e entryInfo) Name() string       { return e.entry.Name() }
+func (e entryInfo) IsDir() bool        { return e.entry.IsDir() }
+func (e entryInfo) Size() int64        { return 0 }
+func (e entryInfo) Mode() os.FileMode  { return e.entry.Type() }
+func (e entryInfo) ModTime() time.Time { return time.Time{} }
+func (e entryInfo) Sys() interface{}   { return nil }
+
+// fileType returns the type bits of the mode of info, without fetching the
+// information of a lazyInfo.
+func fileType(info os.FileInfo) os.FileMode {
+	if l, ok := info.(*lazyInfo); ok {
+		return l.entry.Type()
+	}
+	return info.Mode().Type()
+}
+
+// readFS lists the directory at dir of fsys into l, as fs.WalkDir would, with
+// the information of each entry as fs.DirEntry.Info returns it.
+func (l *listing) readFS(fsys fs.FS, dir string) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		l.err = err
+		return
+	}
+
+	l.names = make([]string, len(entries))
+	l.infos = make([]os.FileInfo, len(entries))
+	l.errs = make([]error, len(entries))
+	for i, e := range entries {
+		l.names[i] = e.Name()
+		l.infos[i], l.errs[i] = e.Info()
+	}
+}
+
+// lstatIn returns the information of the file at path of fsys, as fs.Stat
+// returns it, or as os.Lstat does if fsys is nil. An fs.FS has no way to tell
+// a symbolic link from what it points to, other than by listing its directory.
+func lstatIn(fsys fs.FS, path string) (os.FileInfo, error) {
+	if fsys != nil {
+		return fs.Stat(fsys, path)
+	}
+	return os.Lstat(path)
+}
+
+// walkDir walks the directory at path, whose Lstat information is info and
+// whose listing is l, as filepath.Walk walks a directory.
+func (w *treeWalker) walkDir(path string, info os.FileInfo, l *listing) error {
+	<-l.done
+	err := w.fn(path, info, l.err)
+	if l.err != nil || err != nil {
+		return err
+	}
+
+	if w.follow != nil {
+		if id, ok := inode(info); ok {
+			w.ids = append(w.ids, id)
+			defer func() { w.ids = w.ids[:len(w.ids)-1] }()
+		}
+	}
+
+	// ahead holds the listings of the subdirectories listed ahead of the
+	// walk, by entry index, and next is the first entry that has not been
+	// considered for that yet.
+	ahead := make(map[int]*listing)
+	next := 0
+
+	for i, name := range l.names {
+		for next < len(l.names) && len(ahead) < walkAhead {
+			if l.errs[next] == nil && l.infos[next].IsDir() {
+				ahead[next] = w.list(w.join(path, l.names[next]))
+			}
+			next++
+		}
+
+		filename := w.join(path, name)
+		fileInfo, err := l.infos[i], l.errs[i]
+		if err != nil {
+			if err := w.fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		if fileType(fileInfo)&os.ModeSymlink != 0 && w.follow != nil {
+			if target, ok := w.follow(filename); ok {
+				if w.inside(target) {
+					w.loop(filename)
+					continue
+				}
+				fileInfo = target
+				ahead[i] = w.list(filename)
+			}
+		}
+
+		if !fileInfo.IsDir() {
+			err = w.fn(filename, fileInfo, nil)
+		} else {
+			sub := ahead[i]
+			delete(ahead, i)
+			err = w.walkDir(filename, fileInfo, sub)
+		}
+		if err != nil && (!fileInfo.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// inside reports whether the directory described by info is one the walk is
+// already inside.
+func (w *treeWalker) inside(info os.FileInfo) bool {
+	id, ok := inode(info)
+	if !ok {
+		return false
+	}
+	for _, d := range w.ids {
+		if d == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,311 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// watchDebounce is the default value of Hasher.WatchDebounce.
+	watchDebounce = 200 * time.Millisecond
+
+	// watchHold is how many times the debounce delay a change is held at
+	// most, so that a file written to without pause is still hashed again
+	// once in a while.
+	watchHold = 10
+
+	// pollInterval is how often Watch looks for changes where it cannot be
+	// notified of them.
+	pollInterval = 2 * time.Second
+)
+
+// Watch reads all the files in the file tree rooted at root and calls fn with
+// the result of each, as they complete, including results for files that
+// could not be read, as Stream does. It then watches the tree, and calls fn
+// again with a new result for each file that is created or written to, and a
+// result with Removed set for each file that is removed. A file renamed has a
+// result of each kind, one for either name. Changes are hashed once a file is
+// left alone for a while, so that a burst of writes, or an editor saving a
+// file by way of a temporary one, is hashed only once. Watch runs until ctx
+// is canceled, and then returns ctx.Err(), or until fn or the directory walk
+// returns an error, or the tree can no longer be watched, and then returns
+// that error.
+//
+// On Linux, Watch is notified of changes by inotify, and can only watch as
+// many directories as the system allows. Elsewhere, it walks the tree again
+// every two seconds, and compares the sizes and modification times of the
+// files with those it saw before.
+//
+// Watch uses a zero Hasher; see Hasher.Watch.
+func Watch(ctx context.Context, root string, fn func(r Result) error) error {
+	return new(Hasher).Watch(ctx, root, fn)
+}
+
+// Watch is like the package-level Watch, but reads the files through h, and
+// waits for h.WatchDebounce after the last change to a file before hashing it
+// again. The files changed are filtered as those of the first run are, by
+// h.Exclude, h.MaxDepth, h.GitIgnore and the like, counting from root, and
+// Watch does not watch the directories they skip. h.Stats describes the first
+// run, and
+// h.CheckpointDir, h.RecordOrder and h.ProgressFunc only apply to it. Watch
+// cannot watch a tree in h.FS.
+func (h *Hasher) Watch(ctx context.Context, root string, fn func(r Result) error) error {
+	if h.FS != nil {
+		return errors.New("checksum: Watch cannot watch the tree of a Hasher.FS")
+	}
+
+	// The watch starts first, so that no change made during the first
+	// run is missed.
+	events, errc, err := h.notify(ctx.Done(), root)
+	if err != nil {
+		return err
+	}
+
+	w := &watcher{root: root, fn: fn, abs: newAbsolutizer(root), known: make(map[string]string), below: make(map[string]map[string]bool)}
+	if err := h.collect(ctx.Done(), root, w.deliver); err != nil {
+		if errors.Is(err, ErrWalkCanceled) {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	wh := *h
+	wh.Stats, wh.CheckpointDir, wh.RecordOrder, wh.ReplayOrder = nil, "", nil, nil
+	wh.ProgressFunc, wh.CountFirst = nil, false
+	w.h = &wh
+
+	debounce := h.WatchDebounce
+	if debounce <= 0 {
+		debounce = watchDebounce
+	}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	pending := make(map[string]bool)
+	var first time.Time
+
+	for {
+		select {
+		case path := <-events:
+			if len(pending) == 0 {
+				first = time.Now()
+			}
+			pending[path] = true
+			wait := debounce
+			if left := time.Until(first.Add(watchHold * debounce)); left < wait {
+				wait = left
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+
+		case <-timer.C:
+			err := w.flush(ctx.Done(), pending)
+			if errors.Is(err, ErrWalkCanceled) {
+				return ctx.Err()
+			}
+			if err != nil {
+				return err
+			}
+			pending = make(map[string]bool)
+
+		case err := <-errc:
+			return err
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// A watcher keeps track of the files of a watched tree, for Hasher.Watch.
+type watcher struct {
+	h    *Hasher
+	root string
+	fn   func(r Result) error
+	abs  absolutizer
+
+	// known holds the path of the last result delivered for each file, by
+	// absolute path, and stale those of the files changed in the current
+	// batch that have not been delivered again yet. below holds the
+	// absolute paths of the known files and of the directories and
+	// containers holding them right inside each directory or container
+	// file, by its absolute path, so that those at or below a changed path
+	// are found without looking at the others.
+	known map[string]string
+	stale map[string]bool
+	below map[string]map[string]bool
+}
+
+// deliver records r and passes it on to w.fn.
+func (w *watcher) deliver(r Result) error {
+	if _, ok := w.known[r.AbsPath]; !ok {
+		w.index(r.AbsPath)
+	}
+	w.known[r.AbsPath] = r.Path
+	delete(w.stale, r.AbsPath)
+	return w.fn(r)
+}
+
+// index adds the known file at abs to w.below, along with the directories
+// and containers between it and the root.
+func (w *watcher) index(abs string) {
+	for path := abs; path != w.abs.absRoot; {
+		parent := indexParent(path)
+		if parent == path {
+			return
+		}
+		set := w.below[parent]
+		if set == nil {
+			set = make(map[string]bool)
+			w.below[parent] = set
+		}
+		if set[path] {
+			return
+		}
+		set[path] = true
+		path = parent
+	}
+}
+
+// unindex removes the file at abs, no longer known, from w.below, along with
+// the directories and containers it leaves without any known file.
+func (w *watcher) unindex(abs string) {
+	for path := abs; path != w.abs.absRoot && len(w.below[path]) == 0; {
+		parent := indexParent(path)
+		if parent == path {
+			return
+		}
+		delete(w.below[parent], path)
+		if len(w.below[parent]) == 0 {
+			delete(w.below, parent)
+		}
+		path = parent
+	}
+}
+
+// indexParent returns the path of the directory holding the file at path, or
+// that of the container file, if path is that of one of its members, or of a
+// directory inside it.
+func indexParent(path string) string {
+	dir := filepath.Dir(path)
+	if i := strings.LastIndex(path, MemberSep); i > len(dir) {
+		return path[:i]
+	}
+	return dir
+}
+
+// markStale marks the known files at or below abs stale.
+func (w *watcher) markStale(abs string) {
+	if _, ok := w.known[abs]; ok {
+		w.stale[abs] = true
+	}
+	for path := range w.below[abs] {
+		w.markStale(path)
+	}
+}
+
+// admits reports whether the walk of w.root reaches the file or directory at
+// path, below it, rather than skipping path or a directory on the way to it.
+func (w *watcher) admits(path string, isDir bool) (bool, error) {
+	f := w.h.newPathFilter(w.root)
+	if skip, err := f.descend(path); skip || err != nil {
+		return false, err
+	}
+	skip, err := f.skip(path, isDir)
+	return !skip, err
+}
+
+// flush hashes the files at the paths changed again, as found by the walk of
+// w.root: the files themselves, every file below a directory, and none for a
+// path gone or one the walk skips. Any file known to lie at or below a changed
+// path that is not found again has a result with Removed set.
+func (w *watcher) flush(done <-chan struct{}, changed map[string]bool) error {
+	w.stale = make(map[string]bool)
+	var files, dirs []string
+	for path := range changed {
+		w.markStale(w.abs.abs(path))
+		info, err := os.Lstat(path)
+		if err != nil {
+			// The path is gone, and so are the files known there.
+			continue
+		}
+		if ok, err := w.admits(path, info.IsDir()); err != nil {
+			return &WalkError{path, err}
+		} else if !ok {
+			continue
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		} else {
+			files = append(files, path)
+		}
+	}
+
+	// A path below a directory that changed too is hashed with it. A
+	// directory sorts before the paths below it.
+	sort.Strings(dirs)
+	var outer []string
+	for _, dir := range dirs {
+		if !belowAny(dir, outer) {
+			outer = append(outer, dir)
+		}
+	}
+	dirs = outer
+	var rest []string
+	for _, path := range files {
+		if !belowAny(path, dirs) {
+			rest = append(rest, path)
+		}
+	}
+	files = rest
+	sort.Strings(files)
+
+	if len(files) > 0 {
+		j := w.h.newJob()
+		j.paths = files
+		if err := w.h.collectJob(done, w.root, j, w.deliver); err != nil {
+			return err
+		}
+	}
+	for _, dir := range dirs {
+		j := w.h.newJob()
+		j.base = w.root
+		if err := w.h.collectJob(done, dir, j, w.deliver); err != nil {
+			return err
+		}
+	}
+
+	gone := make([]string, 0, len(w.stale))
+	for abs := range w.stale {
+		gone = append(gone, abs)
+	}
+	sort.Strings(gone)
+	for _, abs := range gone {
+		r := Result{Path: w.known[abs], AbsPath: abs, Removed: true}
+		delete(w.known, abs)
+		w.unindex(abs)
+		if err := w.fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// belowAny reports whether path lies below any of dirs.
+func belowAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if inDir(path, dir) {
+			return true
+		}
+	}
+	return false
+}
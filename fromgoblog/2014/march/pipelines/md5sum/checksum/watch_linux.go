@@ -0,0 +1,160 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask is the set of inotify events that mark a path as changed.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// An inotify watches the directories of a tree with an inotify instance, for
+// Hasher.Watch. It is only used by the goroutine of notify once started.
+type inotify struct {
+	h    *Hasher
+	fd   int
+	f    *os.File // fd, read through the runtime poller
+	root string
+	dirs map[int32]string // the path of each watched directory, by watch
+}
+
+// notify starts watching the tree rooted at root, and returns a channel of the
+// paths below it that change until done is closed, when the watch ends, and a
+// channel of the error that ends it early, if any.
+func (h *Hasher) notify(done <-chan struct{}, root string) (<-chan string, <-chan error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking file is read through the runtime poller, so that
+	// closing it ends a pending read. Its Fd method would make it block,
+	// which is why fd is kept.
+	w := &inotify{h: h, fd: fd, f: os.NewFile(uintptr(fd), "inotify"), root: root, dirs: make(map[int32]string)}
+	if err := w.addTree(root); err != nil {
+		w.f.Close()
+		return nil, nil, err
+	}
+
+	paths := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		<-done
+		w.f.Close()
+	}()
+	go func() {
+		if err := w.run(done, paths); err != nil {
+			errc <- err
+		}
+	}()
+	return paths, errc, nil
+}
+
+// addTree watches the directory at dir and every directory below it, but for
+// those the walk of w.root skips, as Hasher.Exclude and the like have it. A
+// directory that vanishes before it is watched is left out.
+func (w *inotify) addTree(dir string) error {
+	f := w.h.newPathFilter(w.root)
+	if skip, err := f.descend(dir); err != nil {
+		return &WalkError{dir, err}
+	} else if skip {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return &WalkError{path, err}
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if skip, err := f.skip(path, true); err != nil {
+			return &WalkError{path, err}
+		} else if skip {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask|syscall.IN_ONLYDIR)
+		if err == syscall.ENOENT || err == syscall.ENOTDIR {
+			return nil
+		}
+		if err != nil {
+			return &WalkError{path, os.NewSyscallError("inotify_add_watch", err)}
+		}
+		w.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// forget stops watching the directory at dir and those below it, once they
+// have been moved away, and would be reported at their old paths.
+func (w *inotify) forget(dir string) {
+	for wd, path := range w.dirs {
+		if path == dir || inDir(path, dir) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// run reads the events of w and sends the paths they are about on paths,
+// until done is closed, when it returns nil.
+func (w *inotify) run(done <-chan struct{}, paths chan<- string) error {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		if err != nil {
+			return os.NewSyscallError("read inotify", err)
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			dir, ok := w.dirs[ev.Wd]
+			var path string
+			switch {
+			case ev.Mask&syscall.IN_Q_OVERFLOW != 0:
+				// Events were lost, so the whole tree may have
+				// changed.
+				path = w.root
+			case ev.Mask&syscall.IN_IGNORED != 0:
+				delete(w.dirs, ev.Wd)
+				continue
+			case !ok:
+				continue
+			default:
+				path = filepath.Join(dir, strings.TrimRight(string(name), "\x00"))
+			}
+
+			if ev.Mask&syscall.IN_ISDIR != 0 {
+				switch {
+				case ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+					if err := w.addTree(path); err != nil {
+						return err
+					}
+				case ev.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+					w.forget(path)
+				default:
+					continue
+				}
+			}
+
+			select {
+			case paths <- path:
+			case <-done:
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+)
+
+// TestInotifyPrunes checks that the inotify of Watch does not watch the
+// directories the walk skips, whether found at first or created later.
+func TestInotifyPrunes(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		".gitignore":          "build/\n",
+		"a/keep.txt":          "keep",
+		"a/b/deep.txt":        "deep",
+		"node_modules/dep.js": "dep",
+		"build/out.o":         "out",
+		".git/HEAD":           "ref",
+	})
+	h := &Hasher{Exclude: []string{"node_modules"}, MaxDepth: 2, GitIgnore: true}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &inotify{h: h, fd: fd, f: os.NewFile(uintptr(fd), "inotify"), root: root, dirs: make(map[int32]string)}
+	defer w.f.Close()
+
+	if err := w.addTree(root); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"c/node_modules/x", "c/build", "c/d/e"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// As the events of the directories created would have it.
+	for _, dir := range []string{"c", "c/node_modules", "c/build", "c/d"} {
+		if err := w.addTree(filepath.Join(root, filepath.FromSlash(dir))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, path := range w.dirs {
+		got = append(got, relTo(t, root, path))
+	}
+	sort.Strings(got)
+	want := []string{".", "a", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("watched %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("watched %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//go:build !linux
+
+package checksum
+
+import "time"
+
+// notify starts watching the tree rooted at root, and returns a channel of the
+// paths below it that change until done is closed, when the watch ends, and a
+// channel of the error that ends it early, if any. There being no inotify, the
+// tree is walked every pollInterval, and a file whose size or modification
+// time differs from the last walk, or that is new or gone, has changed.
+func (h *Hasher) notify(done <-chan struct{}, root string) (<-chan string, <-chan error, error) {
+	// The paths sent are those of the walk itself, so KeyFunc is not
+	// applied to them.
+	sh := h.walkOnly()
+	sh.KeyFunc = nil
+	if sh.InvalidNames == InvalidNamesEscape {
+		sh.InvalidNames = InvalidNamesKeep
+	}
+
+	snapshot := func() (map[string]FileState, error) {
+		states := make(map[string]FileState)
+		err := sh.collect(done, root, func(r Result) error {
+			states[r.Path] = FileState{Size: r.Size, ModTime: r.ModTime}
+			return nil
+		})
+		return states, err
+	}
+	prev, err := snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		t := time.NewTicker(pollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+			cur, err := snapshot()
+			if err != nil {
+				select {
+				case <-done:
+				default:
+					errc <- err
+				}
+				return
+			}
+			var changed []string
+			for path, st := range cur {
+				if old, ok := prev[path]; !ok || old.Size != st.Size || !old.ModTime.Equal(st.ModTime) {
+					changed = append(changed, path)
+				}
+			}
+			for path := range prev {
+				if _, ok := cur[path]; !ok {
+					changed = append(changed, path)
+				}
+			}
+			prev = cur
+			for _, path := range changed {
+				select {
+				case paths <- path:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return paths, errc, nil
+}
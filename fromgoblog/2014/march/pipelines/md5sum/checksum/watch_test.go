@@ -0,0 +1,173 @@
+package checksum
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatchFilters checks that the changes Watch hashes again are filtered as
+// the files of its first run are, counting from the root: writes into an
+// excluded directory, one too deep, or one a .gitignore file ignores, are
+// left out, be the directory old or new, and so are files ignored.
+func TestWatchFilters(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"keep.txt":             "keep",
+		".gitignore":           "build/\n*.log\n",
+		"a/keep.txt":           "keep",
+		"a/b/old.txt":          "old",
+		"node_modules/old.js":  "old",
+		"build/old.o":          "old",
+		"a/node_modules/x.txt": "old",
+	})
+	h := &Hasher{
+		Exclude:       []string{"node_modules"},
+		MaxDepth:      2,
+		GitIgnore:     true,
+		WatchDebounce: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan Result)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.Watch(ctx, root, func(r Result) error {
+			select {
+			case results <- r:
+			case <-ctx.Done():
+			}
+			return nil
+		})
+	}()
+
+	next := func() (Result, bool) {
+		select {
+		case r := <-results:
+			return r, true
+		case err := <-errc:
+			t.Fatalf("Watch returned %v", err)
+		case <-time.After(20 * h.WatchDebounce):
+		}
+		return Result{}, false
+	}
+
+	first := make(map[string]bool)
+	for len(first) < 3 {
+		r, ok := next()
+		if !ok {
+			t.Fatalf("first run found %v, want it to find .gitignore, keep.txt and a/keep.txt", first)
+		}
+		first[relTo(t, root, r.Path)] = true
+	}
+	for _, want := range []string{".gitignore", "keep.txt", "a/keep.txt"} {
+		if !first[want] {
+			t.Errorf("first run found %v, want %s", first, want)
+		}
+	}
+
+	write := func(path, content string) {
+		name := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("node_modules/dep.js", "new")
+	write("a/b/deep.txt", "new")
+	write("a/b/c/deeper.txt", "new")
+	write("build/out.o", "new")
+	write("a/node_modules/new/dep.js", "new")
+	write("node_modules/new/dep.js", "new")
+	write("a/debug.log", "new")
+	write("x/y/deep.txt", "new")
+	write("x/build/out.o", "new")
+	write("x/node_modules/dep.js", "new")
+	write("x/keep.txt", "new")
+	// The file changed last is hashed again, and tells that the changes
+	// before it were seen.
+	write("a/keep.txt", "changed")
+
+	// A write may be seen in two batches, and hashed twice.
+	got := make(map[string]bool)
+	for {
+		r, ok := next()
+		if !ok {
+			break
+		}
+		got[relTo(t, root, r.Path)] = true
+	}
+	if len(got) != 2 || !got["a/keep.txt"] || !got["x/keep.txt"] {
+		t.Errorf("changes hashed: %v, want only a/keep.txt and x/keep.txt", got)
+	}
+}
+
+// TestWatchRemoved checks that moving a directory out of the tree has Watch
+// report the files known below it removed, and none of the others.
+func TestWatchRemoved(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"d/x.txt":   "x",
+		"d/e/y.txt": "y",
+		"d.txt":     "d",
+		"dd/z.txt":  "z",
+	})
+	h := &Hasher{WatchDebounce: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan Result)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.Watch(ctx, root, func(r Result) error {
+			select {
+			case results <- r:
+			case <-ctx.Done():
+			}
+			return nil
+		})
+	}()
+	next := func() Result {
+		select {
+		case r := <-results:
+			return r
+		case err := <-errc:
+			t.Fatalf("Watch returned %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Watch delivered nothing for 10s")
+		}
+		return Result{}
+	}
+
+	for i := 0; i < 4; i++ {
+		next()
+	}
+	// A directory moved away is reported gone by itself, with none of the
+	// files below it.
+	if err := os.Rename(filepath.Join(root, "d"), filepath.Join(t.TempDir(), "d")); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for len(got) < 2 {
+		r := next()
+		if !r.Removed {
+			t.Fatalf("got %s hashed again, want it removed", relTo(t, root, r.Path))
+		}
+		got[relTo(t, root, r.Path)] = true
+	}
+	if !got["d/x.txt"] || !got["d/e/y.txt"] {
+		t.Errorf("removed %v, want d/x.txt and d/e/y.txt", got)
+	}
+	// A removal of the only file left below dd tells that nothing else
+	// was reported removed with d.
+	if err := os.Remove(filepath.Join(root, "dd", "z.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if r := next(); !r.Removed || relTo(t, root, r.Path) != "dd/z.txt" {
+		t.Errorf("got %+v, want dd/z.txt removed", r)
+	}
+}